@@ -0,0 +1,60 @@
+package backend
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// RequireAdmin is RequireAuth for instance administrators: the caller needs a session (API tokens
+// are rejected) of an active user with the admin role. Other users get 403.
+func RequireAdmin(pool *pgxpool.Pool, next http.Handler) http.Handler {
+	return RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid := GetUserIDFromCtx(r.Context())
+		var isAdmin bool
+		err := pool.QueryRow(r.Context(), `
+			SELECT EXISTS (SELECT 1 FROM users WHERE id::text = $1 AND is_admin AND is_active)
+		`, uid).Scan(&isAdmin)
+		if err != nil {
+			log.Printf("admin: role check user=%s error: %v", uid, err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		if !isAdmin {
+			log.Printf("admin: rejected %s %s for user %s", r.Method, r.URL.Path, uid)
+			http.Error(w, "admin only", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// BootstrapAdmins grants the admin role to the accounts listed in ADMIN_EMAILS (comma separated),
+// so a fresh instance has an administrator without touching the database by hand.
+// Admins are never demoted here; use the admin API for that.
+func BootstrapAdmins(ctx context.Context, pool *pgxpool.Pool) error {
+	var emails []string
+	for _, e := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			emails = append(emails, strings.ToLower(e))
+		}
+	}
+	if len(emails) == 0 {
+		return nil
+	}
+	tag, err := pool.Exec(ctx, `
+		UPDATE users SET is_admin = true, updated_at = now()
+		WHERE lower(email) = ANY($1) AND NOT is_admin
+	`, emails)
+	if err != nil {
+		return err
+	}
+	if n := tag.RowsAffected(); n > 0 {
+		log.Printf("admin: granted admin role to %d account(s) from ADMIN_EMAILS", n)
+	}
+	return nil
+}
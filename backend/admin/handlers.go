@@ -0,0 +1,328 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Y3rnur/go-realtime-chat/backend"
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+// Handlers here expect backend.RequireAdmin in front of them.
+
+const maxLimit = 100
+
+// Hub is the part of the websocket hub the admin handlers need.
+type Hub interface {
+	DisconnectUser(userID, reason string)
+	// LocalStats counts websocket connections (and distinct users) on this instance
+	LocalStats() (connections, users int)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// pageParams parses ?limit= and ?cursor= of the admin lists.
+func pageParams(w http.ResponseWriter, r *http.Request) (limit int, beforeTime *time.Time, beforeID *uuid.UUID, ok bool) {
+	qs := r.URL.Query()
+	limit = 50
+	if v := qs.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return 0, nil, nil, false
+		}
+		limit = min(n, maxLimit)
+	}
+	if v := qs.Get("cursor"); v != "" {
+		t, id, valid := backend.DecodeCursor(v)
+		if !valid {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return 0, nil, nil, false
+		}
+		beforeTime, beforeID = &t, &id
+	}
+	return limit, beforeTime, beforeID, true
+}
+
+// audit records an admin action on a user; failures are logged, the action itself already happened.
+func audit(r *http.Request, pool *pgxpool.Pool, adminID, userID uuid.UUID, action string, note *string) {
+	a := store.ModerationAction{ModeratorID: &adminID, Action: action, TargetUserID: &userID, Note: note}
+	if _, err := store.RecordModerationAction(r.Context(), pool, a); err != nil {
+		log.Printf("admin: audit %s on %s by %s error: %v", action, userID, adminID, err)
+	}
+}
+
+// UsersHandler lists and searches accounts, newest first:
+// GET /api/admin/users?q=&status=active|suspended&limit=&cursor=
+func UsersHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		status := r.URL.Query().Get("status")
+		switch status {
+		case "", store.UserStatusActive, store.UserStatusSuspended:
+		case "all":
+			status = ""
+		default:
+			http.Error(w, "invalid status", http.StatusBadRequest)
+			return
+		}
+		limit, beforeTime, beforeID, ok := pageParams(w, r)
+		if !ok {
+			return
+		}
+		users, err := store.ListUsersAdmin(r.Context(), pool, store.AdminUserFilter{
+			Query:      strings.TrimSpace(r.URL.Query().Get("q")),
+			Status:     status,
+			BeforeTime: beforeTime,
+			BeforeID:   beforeID,
+			Limit:      limit,
+		})
+		if err != nil {
+			log.Printf("admin: list users error: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		res := map[string]any{"users": users}
+		if len(users) == limit {
+			last := users[len(users)-1]
+			res["next_cursor"] = backend.EncodeCursor(last.CreatedAt, last.ID)
+		}
+		writeJSON(w, http.StatusOK, res)
+	})
+}
+
+// UserHandler shows (GET) an account and deactivates/reactivates it or changes its admin role
+// (PATCH {"is_active", "is_admin", "note"}). Route: /api/admin/users/{id}
+func UserHandler(pool *pgxpool.Pool, hub Hub) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPatch {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		adminID, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+		if err != nil {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+		userID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid user id", http.StatusBadRequest)
+			return
+		}
+
+		if r.Method == http.MethodPatch {
+			var req struct {
+				IsActive *bool   `json:"is_active"`
+				IsAdmin  *bool   `json:"is_admin"`
+				Note     *string `json:"note"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "invalid request", http.StatusBadRequest)
+				return
+			}
+			if req.IsActive == nil && req.IsAdmin == nil {
+				http.Error(w, "is_active or is_admin required", http.StatusBadRequest)
+				return
+			}
+			// admins can't lock themselves out; another admin has to do it
+			if userID == adminID && ((req.IsActive != nil && !*req.IsActive) || (req.IsAdmin != nil && !*req.IsAdmin)) {
+				http.Error(w, "you can't deactivate or demote yourself", http.StatusBadRequest)
+				return
+			}
+			if _, err := store.GetUserAdmin(r.Context(), pool, userID); errors.Is(err, store.ErrNotFound) {
+				http.Error(w, "user not found", http.StatusNotFound)
+				return
+			} else if err != nil {
+				http.Error(w, "database error", http.StatusInternalServerError)
+				return
+			}
+
+			if req.IsActive != nil {
+				changed, err := store.SetUserActive(r.Context(), pool, userID, *req.IsActive)
+				if err != nil {
+					log.Printf("admin: set active=%t on %s error: %v", *req.IsActive, userID, err)
+					http.Error(w, "database error", http.StatusInternalServerError)
+					return
+				}
+				if changed {
+					action := store.ActionReactivateUser
+					if !*req.IsActive {
+						action = store.ActionDeactivateUser
+						hub.DisconnectUser(userID.String(), "suspended")
+					}
+					audit(r, pool, adminID, userID, action, req.Note)
+					log.Printf("admin: %s %s by %s", action, userID, adminID)
+				}
+			}
+			if req.IsAdmin != nil {
+				changed, err := store.SetUserAdmin(r.Context(), pool, userID, *req.IsAdmin)
+				if err != nil {
+					log.Printf("admin: set admin=%t on %s error: %v", *req.IsAdmin, userID, err)
+					http.Error(w, "database error", http.StatusInternalServerError)
+					return
+				}
+				if changed {
+					action := store.ActionGrantAdmin
+					if !*req.IsAdmin {
+						action = store.ActionRevokeAdmin
+					}
+					audit(r, pool, adminID, userID, action, req.Note)
+					log.Printf("admin: %s %s by %s", action, userID, adminID)
+				}
+			}
+		}
+
+		u, err := store.GetUserAdmin(r.Context(), pool, userID)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, u)
+	})
+}
+
+// SessionsHandler force-revokes every session of a user and closes their websockets:
+// DELETE /api/admin/users/{id}/sessions
+// Access tokens already issued stay valid until they expire (minutes).
+func SessionsHandler(pool *pgxpool.Pool, hub Hub) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		adminID, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+		if err != nil {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+		userID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid user id", http.StatusBadRequest)
+			return
+		}
+		if _, err := store.GetUserAdmin(r.Context(), pool, userID); errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		n, err := store.RevokeSessions(r.Context(), pool, userID)
+		if err != nil {
+			log.Printf("admin: revoke sessions of %s error: %v", userID, err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		hub.DisconnectUser(userID.String(), "revoked")
+		audit(r, pool, adminID, userID, store.ActionRevokeSessions, nil)
+		log.Printf("admin: revoked %d session(s) of %s by %s", n, userID, adminID)
+		writeJSON(w, http.StatusOK, map[string]any{"revoked": n})
+	})
+}
+
+// ConversationsHandler lists conversation metadata (never content), newest first:
+// GET /api/admin/conversations?user_id=&limit=&cursor=
+func ConversationsHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var memberID *uuid.UUID
+		if v := r.URL.Query().Get("user_id"); v != "" {
+			id, err := uuid.Parse(v)
+			if err != nil {
+				http.Error(w, "invalid user_id", http.StatusBadRequest)
+				return
+			}
+			memberID = &id
+		}
+		limit, beforeTime, beforeID, ok := pageParams(w, r)
+		if !ok {
+			return
+		}
+		convs, err := store.ListConversationsAdmin(r.Context(), pool, memberID, beforeTime, beforeID, limit)
+		if err != nil {
+			log.Printf("admin: list conversations error: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		res := map[string]any{"conversations": convs}
+		if len(convs) == limit {
+			last := convs[len(convs)-1]
+			res["next_cursor"] = backend.EncodeCursor(last.CreatedAt, last.ID)
+		}
+		writeJSON(w, http.StatusOK, res)
+	})
+}
+
+// ConversationHandler shows a conversation's metadata and participants: GET /api/admin/conversations/{id}
+func ConversationHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		convID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid conversation id", http.StatusBadRequest)
+			return
+		}
+		c, err := store.GetConversationAdmin(r.Context(), pool, convID)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "conversation not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("admin: get conversation %s error: %v", convID, err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	})
+}
+
+// StatsHandler reports instance-wide counters and this instance's websocket load: GET /api/admin/stats
+func StatsHandler(pool *pgxpool.Pool, hub Hub, started time.Time) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		stats, err := store.GetServerStats(r.Context(), pool)
+		if err != nil {
+			log.Printf("admin: stats error: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		conns, users := hub.LocalStats()
+		writeJSON(w, http.StatusOK, map[string]any{
+			"stats": stats,
+			"instance": map[string]any{
+				"websocket_connections": conns,
+				"websocket_users":       users,
+				"uptime_seconds":        int(time.Since(started).Seconds()),
+				"db_connections":        pool.Stat().TotalConns(),
+			},
+		})
+	})
+}
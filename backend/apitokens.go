@@ -0,0 +1,291 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// API token scopes
+const (
+	ScopeMessagesRead        = "messages:read"
+	ScopeMessagesWrite       = "messages:write"
+	ScopeConversationsManage = "conversations:manage"
+)
+
+var knownScopes = map[string]bool{
+	ScopeMessagesRead:        true,
+	ScopeMessagesWrite:       true,
+	ScopeConversationsManage: true,
+}
+
+// raw API tokens carry this prefix so they can't be confused with JWTs
+const apiTokenPrefix = "rtc_"
+
+// PrincipalKind tells interactive sessions and API tokens apart.
+type PrincipalKind string
+
+const (
+	PrincipalUser     PrincipalKind = "user"
+	PrincipalAPIToken PrincipalKind = "api_token"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Kind    PrincipalKind
+	UserID  string
+	TokenID string   // set for API tokens
+	Scopes  []string // set for API tokens
+}
+
+func userPrincipal(userID string) Principal {
+	return Principal{Kind: PrincipalUser, UserID: userID}
+}
+
+// HasScope reports whether the principal may act within scope; user sessions are not restricted.
+func (p Principal) HasScope(scope string) bool {
+	if p.Kind == PrincipalUser {
+		return true
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// MethodScopes maps HTTP methods to the scope an API token needs for them.
+type MethodScopes map[string]string
+
+// the pool API tokens are looked up in (set by ConfigureAPITokens)
+var (
+	apiTokenMu   sync.RWMutex
+	apiTokenPool *pgxpool.Pool
+)
+
+// ConfigureAPITokens enables API token authentication backed by pool.
+func ConfigureAPITokens(pool *pgxpool.Pool) {
+	apiTokenMu.Lock()
+	defer apiTokenMu.Unlock()
+	apiTokenPool = pool
+}
+
+func isAPIToken(tok string) bool {
+	return strings.HasPrefix(tok, apiTokenPrefix)
+}
+
+// lookupAPIToken resolves a raw API token to its principal.
+func lookupAPIToken(ctx context.Context, raw string) (Principal, error) {
+	apiTokenMu.RLock()
+	pool := apiTokenPool
+	apiTokenMu.RUnlock()
+	if pool == nil {
+		return Principal{}, errors.New("api tokens not enabled")
+	}
+
+	var p Principal
+	err := pool.QueryRow(ctx, `
+		SELECT t.id::text, t.user_id::text, t.scopes
+		FROM api_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1
+			AND t.revoked_at IS NULL
+			AND (t.expires_at IS NULL OR t.expires_at > now())
+			AND u.is_active
+	`, HashToken(raw)).Scan(&p.TokenID, &p.UserID, &p.Scopes)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Principal{}, errors.New("invalid api token")
+		}
+		return Principal{}, err
+	}
+	p.Kind = PrincipalAPIToken
+
+	// last_used_at is informational; at most one write per minute per token
+	if _, err := pool.Exec(ctx, `
+		UPDATE api_tokens SET last_used_at = now()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - INTERVAL '1 minute')
+	`, p.TokenID); err != nil {
+		log.Printf("api token: update last_used_at error token=%s: %v", p.TokenID, err)
+	}
+	return p, nil
+}
+
+// APIToken is the listable view of a token (never includes the secret).
+type APIToken struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// NormalizeScopes validates requested scopes and removes duplicates.
+func NormalizeScopes(in []string) ([]string, error) {
+	if len(in) == 0 {
+		return nil, errors.New("at least one scope required")
+	}
+	seen := map[string]bool{}
+	out := make([]string, 0, len(in))
+	for _, s := range in {
+		if !knownScopes[s] {
+			return nil, errors.New("unknown scope: " + s)
+		}
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+// CreateAPIToken stores a new token for userID and returns the raw token (shown once).
+func CreateAPIToken(ctx context.Context, pool *pgxpool.Pool, userID, name string, scopes []string, expiresAt *time.Time) (APIToken, string, error) {
+	var t APIToken
+	rnd, err := generateRandomToken(32)
+	if err != nil {
+		return t, "", err
+	}
+	raw := apiTokenPrefix + rnd
+	err = pool.QueryRow(ctx, `
+		INSERT INTO api_tokens (user_id, name, token_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, name, scopes, created_at, last_used_at, expires_at, revoked_at
+	`, userID, name, HashToken(raw), scopes, expiresAt).Scan(&t.ID, &t.Name, &t.Scopes, &t.CreatedAt, &t.LastUsedAt, &t.ExpiresAt, &t.RevokedAt)
+	if err != nil {
+		return t, "", err
+	}
+	return t, raw, nil
+}
+
+func listAPITokens(ctx context.Context, pool *pgxpool.Pool, userID string) ([]APIToken, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT id, name, scopes, created_at, last_used_at, expires_at, revoked_at
+		FROM api_tokens
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []APIToken{}
+	for rows.Next() {
+		var t APIToken
+		if err := rows.Scan(&t.ID, &t.Name, &t.Scopes, &t.CreatedAt, &t.LastUsedAt, &t.ExpiresAt, &t.RevokedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// APITokensHandler lists (GET) and creates (POST) API tokens of the authenticated user.
+func APITokensHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid := GetUserIDFromCtx(r.Context())
+		switch r.Method {
+		case http.MethodGet:
+			tokens, err := listAPITokens(r.Context(), pool, uid)
+			if err != nil {
+				log.Printf("api tokens: list error user=%s: %v", uid, err)
+				http.Error(w, "database error", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(tokens)
+
+		case http.MethodPost:
+			var req struct {
+				Name          string   `json:"name"`
+				Scopes        []string `json:"scopes"`
+				ExpiresInDays int      `json:"expires_in_days"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "invalid request", http.StatusBadRequest)
+				return
+			}
+			name := strings.TrimSpace(req.Name)
+			if name == "" || len(name) > 100 {
+				http.Error(w, "name required (max 100 characters)", http.StatusBadRequest)
+				return
+			}
+			scopes, err := NormalizeScopes(req.Scopes)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var expiresAt *time.Time
+			if req.ExpiresInDays < 0 {
+				http.Error(w, "invalid expires_in_days", http.StatusBadRequest)
+				return
+			}
+			if req.ExpiresInDays > 0 {
+				t := time.Now().Add(time.Duration(req.ExpiresInDays) * 24 * time.Hour)
+				expiresAt = &t
+			}
+
+			tok, raw, err := CreateAPIToken(r.Context(), pool, uid, name, scopes, expiresAt)
+			if err != nil {
+				log.Printf("api tokens: create error user=%s: %v", uid, err)
+				http.Error(w, "server error", http.StatusInternalServerError)
+				return
+			}
+			log.Printf("api tokens: created token %s for user %s scopes=%v", tok.ID, uid, scopes)
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"token":     raw,
+				"api_token": tok,
+			})
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// APITokenHandler revokes (DELETE /api/tokens/{id}) a token of the authenticated user.
+func APITokenHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid token id", http.StatusBadRequest)
+			return
+		}
+		uid := GetUserIDFromCtx(r.Context())
+		tag, err := pool.Exec(r.Context(), `
+			UPDATE api_tokens SET revoked_at = now()
+			WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+		`, id, uid)
+		if err != nil {
+			log.Printf("api tokens: revoke error token=%s: %v", id, err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		if tag.RowsAffected() == 0 {
+			http.Error(w, "token not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("api tokens: revoked token %s of user %s", id, uid)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
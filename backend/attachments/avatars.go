@@ -0,0 +1,114 @@
+package attachments
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// AvatarSize is the edge length (in pixels) of processed avatars.
+const AvatarSize = 256
+
+// AvatarPathPrefix is where uploaded avatars are served from (/api/avatars/{file}).
+const AvatarPathPrefix = "/api/avatars/"
+
+const maxAvatarURLLength = 2048
+
+var (
+	avatarFileRe     = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\.(jpg|png)$`)
+	thumbnailQueryRe = regexp.MustCompile(`^size=[0-9]{1,4}$`)
+	attachmentPathRe = regexp.MustCompile(`^/api/attachments/([0-9a-f-]{36})(/thumbnail)?$`)
+)
+
+// ValidAvatarFile reports whether name is the file name of an uploaded avatar.
+func ValidAvatarFile(name string) bool {
+	return avatarFileRe.MatchString(name)
+}
+
+// ValidAvatarURL is the check for user and conversation avatars: uploaded avatars, uploaded
+// image attachments, or https URLs on a host listed in AVATAR_URL_HOSTS (subdomains included).
+// Anything else would make every viewer's browser fetch an arbitrary URL.
+func ValidAvatarURL(s string) bool {
+	if len(s) > maxAvatarURLLength {
+		return false
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.User != nil || u.Fragment != "" || u.RawPath != "" {
+		return false
+	}
+	if u.Scheme == "" && u.Host == "" {
+		if file, ok := strings.CutPrefix(u.Path, AvatarPathPrefix); ok {
+			return u.RawQuery == "" && ValidAvatarFile(file)
+		}
+		m := attachmentPathRe.FindStringSubmatch(u.Path)
+		if m == nil {
+			return false
+		}
+		if _, err := uuid.Parse(m[1]); err != nil {
+			return false
+		}
+		return u.RawQuery == "" || (m[2] != "" && thumbnailQueryRe.MatchString(u.RawQuery))
+	}
+	return u.Scheme == "https" && allowedAvatarHost(u.Hostname())
+}
+
+// allowedAvatarHost matches host against the comma-separated AVATAR_URL_HOSTS.
+func allowedAvatarHost(host string) bool {
+	host = strings.ToLower(host)
+	if host == "" {
+		return false
+	}
+	for _, allowed := range strings.Split(os.Getenv("AVATAR_URL_HOSTS"), ",") {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed != "" && (host == allowed || strings.HasSuffix(host, "."+allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrUnsupportedImage is returned for avatars that aren't decodable JPEG, PNG or GIF images.
+var ErrUnsupportedImage = errors.New("avatar must be a JPEG, PNG or GIF image")
+
+// ProcessAvatar turns an uploaded image into a square avatar: the sniffed content type is
+// checked, EXIF orientation is applied, the center square is cropped and scaled down to
+// AvatarSize. Re-encoding drops all metadata. Returns the encoded image and its content type.
+func ProcessAvatar(data []byte) ([]byte, string, error) {
+	contentType := http.DetectContentType(data)
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return nil, "", ErrUnsupportedImage
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width*cfg.Height > maxDecodePixels {
+		return nil, "", ErrUnsupportedImage
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", ErrUnsupportedImage
+	}
+	orientation := 1
+	if contentType == "image/jpeg" {
+		orientation = jpegOrientation(data)
+	}
+	img := applyOrientation(toRGBA(src), orientation)
+
+	// center square
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	side := min(w, h)
+	square := img.SubImage(image.Rect((w-side)/2, (h-side)/2, (w-side)/2+side, (h-side)/2+side))
+	size := min(side, AvatarSize)
+	out, ct, err := encodeImage(resize(toRGBA(square), size, size), contentType, 85)
+	if err != nil {
+		return nil, "", err
+	}
+	return out, ct, nil
+}
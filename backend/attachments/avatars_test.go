@@ -0,0 +1,45 @@
+package attachments
+
+import "testing"
+
+func TestValidAvatarURL(t *testing.T) {
+	t.Setenv("AVATAR_URL_HOSTS", "gravatar.com, cdn.example.org")
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"/api/avatars/0b9f6a52-3c1e-4d7a-9a43-5f2d8c1e7b10.png", true},
+		{"/api/avatars/0b9f6a52-3c1e-4d7a-9a43-5f2d8c1e7b10.svg", false},
+		{"/api/avatars/../me", false},
+		{"/api/attachments/0b9f6a52-3c1e-4d7a-9a43-5f2d8c1e7b10", true},
+		{"/api/attachments/0b9f6a52-3c1e-4d7a-9a43-5f2d8c1e7b10/thumbnail?size=480", true},
+		{"/api/attachments/0b9f6a52-3c1e-4d7a-9a43-5f2d8c1e7b10?download=1", false},
+		{"/api/attachments/not-a-uuid", false},
+		{"/api/me", false},
+		{"https://gravatar.com/avatar/abc", true},
+		{"https://secure.gravatar.com/avatar/abc", true},
+		{"https://cdn.example.org/a.png", true},
+		{"https://example.org/a.png", false},
+		{"https://evilgravatar.com/a.png", false},
+		{"http://gravatar.com/avatar/abc", false},
+		{"https://user@gravatar.com/avatar/abc", false},
+		{"//gravatar.com/avatar/abc", false},
+		{"javascript:alert(1)", false},
+		{"https://internal.corp/a.png", false},
+	}
+	for _, tt := range tests {
+		if got := ValidAvatarURL(tt.url); got != tt.want {
+			t.Errorf("ValidAvatarURL(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestValidAvatarURLWithoutAllowlist(t *testing.T) {
+	t.Setenv("AVATAR_URL_HOSTS", "")
+	if ValidAvatarURL("https://gravatar.com/avatar/abc") {
+		t.Error("external URL accepted without AVATAR_URL_HOSTS")
+	}
+	if !ValidAvatarURL("/api/avatars/0b9f6a52-3c1e-4d7a-9a43-5f2d8c1e7b10.jpg") {
+		t.Error("uploaded avatar rejected")
+	}
+}
@@ -0,0 +1,359 @@
+package attachments
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Y3rnur/go-realtime-chat/backend"
+	"github.com/Y3rnur/go-realtime-chat/backend/filters"
+	"github.com/Y3rnur/go-realtime-chat/backend/ratelimit"
+	"github.com/Y3rnur/go-realtime-chat/backend/storage"
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+// default upload limit (ATTACHMENT_MAX_BYTES overrides it)
+const defaultMaxBytes = 25 << 20
+
+// allowedTypes are the sniffed content types accepted for upload. Types not listed
+// (HTML, SVG, executables, ...) are rejected so downloads can't be used to serve active content.
+var allowedTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+	"text/plain":      true,
+	"application/zip": true,
+	"audio/mpeg":      true,
+	"audio/wave":      true,
+	"video/mp4":       true,
+	"video/webm":      true,
+}
+
+// MaxBytes returns the maximum accepted attachment size.
+func MaxBytes() int64 {
+	if v, err := strconv.ParseInt(os.Getenv("ATTACHMENT_MAX_BYTES"), 10, 64); err == nil && v > 0 {
+		return v
+	}
+	return defaultMaxBytes
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// sanitizeFilename keeps the base name without control characters (used in Content-Disposition).
+func sanitizeFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == "/" {
+		name = "file"
+	}
+	if len(name) > 255 {
+		name = name[:255]
+	}
+	return name
+}
+
+// sniffContentType detects the type from the first 512 bytes and rewinds the file.
+func sniffContentType(f io.ReadSeeker) (string, error) {
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	ct, _, _ := mime.ParseMediaType(http.DetectContentType(buf[:n]))
+	return ct, nil
+}
+
+// UploadHandler accepts a multipart upload ("file" plus an optional "body" caption) and posts it
+// as an attachment message. Route: POST /api/conversations/{id}/attachments (participants only).
+// Uploads count against the message rate limits, captions go through contentFilters and publish
+// fans the saved message out (hub + webhooks), like regular messages. Images stay "pending"
+// until proc has stripped their metadata.
+func UploadHandler(pool *pgxpool.Pool, st storage.Storage, proc *Processor, contentFilters *filters.Pipeline, limiter *ratelimit.Limiter, publish func(store.Message)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uid, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, "invalid user")
+			return
+		}
+		convID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid conversation_id")
+			return
+		}
+		member, err := store.IsUserInConversation(r.Context(), pool, convID, uid)
+		if err != nil {
+			log.Printf("attachments: membership check error conv=%s user=%s: %v", convID, uid, err)
+			writeError(w, http.StatusInternalServerError, "server error")
+			return
+		}
+		if !member {
+			writeError(w, http.StatusForbidden, "forbidden")
+			return
+		}
+		// checked before the upload is read
+		var exceeded *ratelimit.Exceeded
+		if limiter != nil {
+			if err := limiter.AllowMessage(r.Context(), uid.String(), convID.String()); errors.As(err, &exceeded) {
+				exceeded.WriteHTTP(w)
+				return
+			}
+		}
+
+		maxBytes := MaxBytes()
+		// leaving room for the multipart envelope and the caption
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes+64<<10)
+		if err := r.ParseMultipartForm(8 << 20); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				writeError(w, http.StatusRequestEntityTooLarge, "file too large")
+				return
+			}
+			writeError(w, http.StatusBadRequest, "invalid multipart form")
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "file required")
+			return
+		}
+		defer file.Close()
+		if header.Size <= 0 {
+			writeError(w, http.StatusBadRequest, "file is empty")
+			return
+		}
+		if header.Size > maxBytes {
+			writeError(w, http.StatusRequestEntityTooLarge, "file too large")
+			return
+		}
+		caption := strings.TrimSpace(r.FormValue("body"))
+		if len(caption) > 4000 {
+			writeError(w, http.StatusBadRequest, "body too long")
+			return
+		}
+
+		// the declared Content-Type is not trusted; the stored type is sniffed from the content
+		contentType, err := sniffContentType(file)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "unreadable file")
+			return
+		}
+		if !allowedTypes[contentType] {
+			writeError(w, http.StatusUnsupportedMediaType, "file type not allowed: "+contentType)
+			return
+		}
+
+		// the caption is filtered like a message body, before anything is stored
+		var filtered filters.Result
+		if caption != "" && contentFilters != nil {
+			filtered, err = contentFilters.Apply(r.Context(), pool, filters.Message{ConversationID: convID, AuthorID: uid, Body: caption})
+			var rejection *filters.Rejection
+			if errors.As(err, &rejection) {
+				writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": rejection.Reason, "filter": rejection.Filter})
+				return
+			}
+			if err != nil {
+				log.Printf("attachments: content filters conv=%s error: %v", convID, err)
+				writeError(w, http.StatusInternalServerError, "server error")
+				return
+			}
+			caption = filtered.Body
+		}
+
+		a := store.Attachment{
+			ID:          uuid.New(),
+			Filename:    sanitizeFilename(header.Filename),
+			ContentType: contentType,
+			SizeBytes:   header.Size,
+		}
+		a.StorageKey = "attachments/" + convID.String() + "/" + a.ID.String()
+		a.Status = store.AttachmentReady
+		blobKey := a.StorageKey
+		if proc != nil && needsProcessing(contentType) {
+			a.Status = store.AttachmentPending
+			blobKey = uploadKey(a)
+		}
+		if err := st.Put(r.Context(), blobKey, file, header.Size, contentType); err != nil {
+			log.Printf("attachments: store blob %s error: %v", blobKey, err)
+			writeError(w, http.StatusInternalServerError, "storage error")
+			return
+		}
+
+		saved, err := store.SaveAttachmentMessage(r.Context(), pool, convID, uid, caption, a)
+		if err != nil {
+			log.Printf("attachments: save message error: %v", err)
+			if derr := st.Delete(r.Context(), blobKey); derr != nil {
+				log.Printf("attachments: cleanup blob %s error: %v", blobKey, derr)
+			}
+			writeError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+		if err := filtered.Flag(r.Context(), pool, saved); err != nil {
+			log.Printf("attachments: flag message %s error: %v", saved.ID, err)
+		}
+		if a.Status == store.AttachmentPending {
+			proc.Enqueue(r.Context(), a.ID)
+		}
+		if publish != nil {
+			publish(saved)
+		}
+		writeJSON(w, http.StatusCreated, saved)
+	})
+}
+
+// loadAttachmentForMember returns a processed attachment when the caller participates in its conversation.
+func loadAttachmentForMember(w http.ResponseWriter, r *http.Request, pool *pgxpool.Pool) (store.Attachment, bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return store.Attachment{}, false
+	}
+	uid, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+	if err != nil {
+		http.Error(w, "invalid user", http.StatusUnauthorized)
+		return store.Attachment{}, false
+	}
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid attachment id", http.StatusBadRequest)
+		return store.Attachment{}, false
+	}
+	a, err := store.GetAttachment(r.Context(), pool, id)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "attachment not found", http.StatusNotFound)
+			return a, false
+		}
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return a, false
+	}
+	member, err := store.IsUserInConversation(r.Context(), pool, a.ConversationID, uid)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return a, false
+	}
+	if !member {
+		// not revealing attachments of other conversations
+		http.Error(w, "attachment not found", http.StatusNotFound)
+		return a, false
+	}
+	switch a.Status {
+	case store.AttachmentPending:
+		// the original still carries its metadata; it is only served once processed
+		w.Header().Set("Retry-After", "2")
+		http.Error(w, "attachment is still being processed", http.StatusConflict)
+		return a, false
+	case store.AttachmentFailed:
+		http.Error(w, "attachment could not be processed", http.StatusGone)
+		return a, false
+	}
+	return a, true
+}
+
+// serveBlob streams a stored blob with headers that prevent browsers from sniffing active content.
+func serveBlob(w http.ResponseWriter, r *http.Request, st storage.Storage, key, contentType string, size int64, filename string) {
+	blob, err := st.Get(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "attachment not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("attachments: read blob %s error: %v", key, err)
+		http.Error(w, "storage error", http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+
+	disposition := "attachment"
+	if strings.HasPrefix(contentType, "image/") {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, blob); err != nil {
+		log.Printf("attachments: stream %s error: %v", key, err)
+	}
+}
+
+// DownloadHandler streams an attachment to participants of its conversation (GET /api/attachments/{id}).
+func DownloadHandler(pool *pgxpool.Pool, st storage.Storage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a, ok := loadAttachmentForMember(w, r, pool)
+		if !ok {
+			return
+		}
+		serveBlob(w, r, st, a.StorageKey, a.ContentType, a.SizeBytes, a.Filename)
+	})
+}
+
+// ThumbnailHandler serves the smallest thumbnail covering ?size= (default 480), falling back to
+// the largest one, or to the image itself when it is smaller than every thumbnail size.
+// Route: GET /api/attachments/{id}/thumbnail
+func ThumbnailHandler(pool *pgxpool.Pool, st storage.Storage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a, ok := loadAttachmentForMember(w, r, pool)
+		if !ok {
+			return
+		}
+		want := 480
+		if v, err := strconv.Atoi(r.URL.Query().Get("size")); err == nil && v > 0 {
+			want = v
+		}
+		if len(a.Thumbnails) == 0 {
+			if !strings.HasPrefix(a.ContentType, "image/") {
+				http.Error(w, "no thumbnail for this attachment", http.StatusNotFound)
+				return
+			}
+			serveBlob(w, r, st, a.StorageKey, a.ContentType, a.SizeBytes, a.Filename)
+			return
+		}
+		t := a.Thumbnails[len(a.Thumbnails)-1]
+		for _, c := range a.Thumbnails {
+			if c.Size >= want {
+				t = c
+				break
+			}
+		}
+		serveBlob(w, r, st, t.StorageKey, t.ContentType, t.SizeBytes, "thumb_"+a.Filename)
+	})
+}
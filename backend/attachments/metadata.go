@@ -0,0 +1,253 @@
+package attachments
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errMalformed = errors.New("malformed image")
+
+// stripJPEG removes metadata segments (EXIF/XMP in APP1, IPTC in APP13, comments and
+// vendor APPn) without re-encoding. JFIF (APP0), ICC profiles (APP2) and the Adobe
+// segment (APP14, needed to decode CMYK images) are kept.
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errMalformed
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF {
+			return nil, errMalformed
+		}
+		// skipping fill bytes
+		for i < len(data) && data[i] == 0xFF {
+			i++
+		}
+		if i >= len(data) {
+			return nil, errMalformed
+		}
+		marker := data[i]
+		i++
+		// markers without a length
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out.Write([]byte{0xFF, marker})
+			continue
+		}
+		if marker == 0xD9 {
+			out.Write([]byte{0xFF, marker})
+			return out.Bytes(), nil
+		}
+		if i+2 > len(data) {
+			return nil, errMalformed
+		}
+		n := int(binary.BigEndian.Uint16(data[i:]))
+		if n < 2 || i+n > len(data) {
+			return nil, errMalformed
+		}
+		seg := data[i+2 : i+n]
+		if marker == 0xDA {
+			// start of scan: the rest is entropy-coded data
+			out.Write([]byte{0xFF, marker})
+			out.Write(data[i:])
+			return out.Bytes(), nil
+		}
+		if keepJPEGSegment(marker, seg) {
+			out.Write([]byte{0xFF, marker})
+			out.Write(data[i : i+n])
+		}
+		i += n
+	}
+	return nil, errMalformed
+}
+
+func keepJPEGSegment(marker byte, seg []byte) bool {
+	switch {
+	case marker == 0xFE: // COM
+		return false
+	case marker == 0xE0, marker == 0xEE: // JFIF, Adobe
+		return true
+	case marker == 0xE2:
+		return bytes.HasPrefix(seg, []byte("ICC_PROFILE\x00"))
+	case marker >= 0xE1 && marker <= 0xEF:
+		return false
+	}
+	return true
+}
+
+// jpegOrientation returns the EXIF orientation (1-8) of a JPEG, or 1 when absent.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	i := 2
+	for i+4 <= len(data) && data[i] == 0xFF {
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		n := int(binary.BigEndian.Uint16(data[i+2:]))
+		if n < 2 || i+2+n > len(data) {
+			break
+		}
+		seg := data[i+4 : i+2+n]
+		if marker == 0xE1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			if o := exifOrientation(seg[6:]); o >= 1 && o <= 8 {
+				return o
+			}
+			return 1
+		}
+		i += 2 + n
+	}
+	return 1
+}
+
+// exifOrientation reads tag 0x0112 from IFD0 of a TIFF structure.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var bo binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return 0
+	}
+	off := int(bo.Uint32(tiff[4:]))
+	if off < 8 || off+2 > len(tiff) {
+		return 0
+	}
+	count := int(bo.Uint16(tiff[off:]))
+	for e := 0; e < count; e++ {
+		p := off + 2 + e*12
+		if p+12 > len(tiff) {
+			return 0
+		}
+		if bo.Uint16(tiff[p:]) == 0x0112 {
+			return int(bo.Uint16(tiff[p+8:]))
+		}
+	}
+	return 0
+}
+
+// png chunks carrying metadata
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+// stripPNG drops textual, EXIF and timestamp chunks.
+func stripPNG(data []byte) ([]byte, error) {
+	const sig = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(data, []byte(sig)) {
+		return nil, errMalformed
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.WriteString(sig)
+	i := len(sig)
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, errMalformed
+		}
+		n := int(binary.BigEndian.Uint32(data[i:]))
+		typ := string(data[i+4 : i+8])
+		end := i + 12 + n
+		if n < 0 || end > len(data) {
+			return nil, errMalformed
+		}
+		if !pngMetadataChunks[typ] {
+			out.Write(data[i:end])
+		}
+		i = end
+		if typ == "IEND" {
+			return out.Bytes(), nil
+		}
+	}
+	return nil, errMalformed
+}
+
+// stripWebP drops EXIF and XMP chunks (and their flags in the VP8X header).
+func stripWebP(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errMalformed
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:12])
+	i := 12
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, errMalformed
+		}
+		fourCC := string(data[i : i+4])
+		n := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + n + n%2
+		if end > len(data) {
+			if i+8+n == len(data) {
+				end = len(data) // missing pad byte at the very end
+			} else {
+				return nil, errMalformed
+			}
+		}
+		switch fourCC {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= 0x08 | 0x04 // EXIF and XMP flags
+			}
+			out.Write(chunk)
+		default:
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	b := out.Bytes()
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)-8))
+	return b, nil
+}
+
+// webpSize reads the canvas size from the WebP container: the VP8X header of extended files,
+// otherwise the frame header of the VP8 (lossy) or VP8L (lossless) bitstream.
+func webpSize(data []byte) (int, int, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return 0, 0, errMalformed
+	}
+	le24 := func(b []byte) int { return int(b[0]) | int(b[1])<<8 | int(b[2])<<16 }
+	for i := 12; i+8 <= len(data); {
+		n := int(binary.LittleEndian.Uint32(data[i+4:]))
+		payload := data[i+8 : min(len(data), i+8+n)]
+		switch string(data[i : i+4]) {
+		case "VP8X":
+			// flags (1), reserved (3), canvas width - 1 (3), canvas height - 1 (3)
+			if len(payload) < 10 {
+				return 0, 0, errMalformed
+			}
+			return le24(payload[4:]) + 1, le24(payload[7:]) + 1, nil
+		case "VP8 ":
+			// frame tag (3), start code 9d 01 2a, then 14-bit width and height (2 bits of scale each)
+			if len(payload) < 10 || payload[3] != 0x9d || payload[4] != 0x01 || payload[5] != 0x2a {
+				return 0, 0, errMalformed
+			}
+			w := int(binary.LittleEndian.Uint16(payload[6:]) & 0x3fff)
+			h := int(binary.LittleEndian.Uint16(payload[8:]) & 0x3fff)
+			return w, h, nil
+		case "VP8L":
+			// signature 0x2f, then width - 1 and height - 1 in 14 bits each
+			if len(payload) < 5 || payload[0] != 0x2f {
+				return 0, 0, errMalformed
+			}
+			bits := binary.LittleEndian.Uint32(payload[1:])
+			return int(bits&0x3fff) + 1, int(bits>>14&0x3fff) + 1, nil
+		}
+		i += 8 + n + n%2
+	}
+	return 0, 0, errMalformed
+}
@@ -0,0 +1,71 @@
+package attachments
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// riff wraps chunks (fourCC followed by payload) in a WebP container.
+func riff(chunks ...[]byte) []byte {
+	var body []byte
+	for _, c := range chunks {
+		body = append(body, c[:4]...)
+		body = binary.LittleEndian.AppendUint32(body, uint32(len(c)-4))
+		body = append(body, c[4:]...)
+		if (len(c)-4)%2 == 1 {
+			body = append(body, 0)
+		}
+	}
+	out := []byte("RIFF")
+	out = binary.LittleEndian.AppendUint32(out, uint32(4+len(body)))
+	out = append(out, "WEBP"...)
+	return append(out, body...)
+}
+
+func TestWebPSize(t *testing.T) {
+	lossy := append([]byte("VP8 "), 0x50, 0x01, 0x00, 0x9d, 0x01, 0x2a)
+	lossy = binary.LittleEndian.AppendUint16(lossy, 640)
+	lossy = binary.LittleEndian.AppendUint16(lossy, 480|1<<14) // scale bits are ignored
+	lossy = append(lossy, 0, 0, 0)
+
+	lossless := append([]byte("VP8L"), 0x2f)
+	lossless = binary.LittleEndian.AppendUint32(lossless, uint32(300-1)|uint32(200-1)<<14)
+
+	extended := append([]byte("VP8X"), 0x10, 0, 0, 0)
+	extended = append(extended, 0xff, 0x03, 0, 0xff, 0x02, 0) // 1023, 767
+
+	tests := []struct {
+		name string
+		data []byte
+		w, h int
+	}{
+		{"lossy", riff(lossy), 640, 480},
+		{"lossless", riff(lossless), 300, 200},
+		{"extended", riff(extended, []byte("EXIFxxx"), lossy), 1024, 768},
+	}
+	for _, tt := range tests {
+		w, h, err := webpSize(tt.data)
+		if err != nil || w != tt.w || h != tt.h {
+			t.Errorf("%s: webpSize = %d, %d, %v; want %d, %d", tt.name, w, h, err, tt.w, tt.h)
+		}
+		// stripping metadata keeps the size readable
+		clean, err := stripWebP(tt.data)
+		if err != nil {
+			t.Fatalf("%s: stripWebP: %v", tt.name, err)
+		}
+		if w, h, err := webpSize(clean); err != nil || w != tt.w || h != tt.h {
+			t.Errorf("%s: webpSize(stripped) = %d, %d, %v", tt.name, w, h, err)
+		}
+	}
+
+	for name, data := range map[string][]byte{
+		"not riff":     []byte("GIF89a......"),
+		"no bitstream": riff([]byte("EXIFxx")),
+		"bad vp8":      riff(append([]byte("VP8 "), 0, 0, 0, 1, 2, 3, 0, 0, 0, 0)),
+		"truncated":    riff(lossless)[:22],
+	} {
+		if _, _, err := webpSize(data); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package attachments
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Y3rnur/go-realtime-chat/backend/storage"
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+// event types published when processing finishes
+const (
+	EventAttachmentReady  = "attachment_ready"
+	EventAttachmentFailed = "attachment_failed"
+)
+
+// EventPublisher is the part of the hub the processor needs.
+type EventPublisher interface {
+	PublishEvent(convID string, v interface{}) error
+}
+
+// needsProcessing reports whether uploads of this type go through the processor.
+func needsProcessing(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// uploadKey is where an unprocessed upload waits; it is never served.
+func uploadKey(a store.Attachment) string {
+	return a.StorageKey + "_upload"
+}
+
+func thumbnailKey(a store.Attachment, size int) string {
+	return fmt.Sprintf("%s_thumb_%d", a.StorageKey, size)
+}
+
+// DeleteBlobs removes everything stored for the attachments: the served file, a leftover
+// upload and every thumbnail size (whether or not it was rendered).
+func DeleteBlobs(ctx context.Context, st storage.Storage, atts []store.Attachment) {
+	for _, a := range atts {
+		keys := []string{a.StorageKey, uploadKey(a)}
+		for _, size := range thumbnailSizes {
+			keys = append(keys, thumbnailKey(a, size))
+		}
+		for _, key := range keys {
+			if err := st.Delete(ctx, key); err != nil && !errors.Is(err, storage.ErrNotFound) {
+				log.Printf("attachments: delete blob %s of %s error: %v", key, a.ID, err)
+			}
+		}
+	}
+}
+
+// Processor strips metadata from uploaded images and renders their thumbnails in the background.
+type Processor struct {
+	pool      *pgxpool.Pool
+	storage   storage.Storage
+	publisher EventPublisher
+
+	queue chan uuid.UUID
+	once  sync.Once
+}
+
+func NewProcessor(pool *pgxpool.Pool, st storage.Storage, publisher EventPublisher) *Processor {
+	return &Processor{
+		pool:      pool,
+		storage:   st,
+		publisher: publisher,
+		queue:     make(chan uuid.UUID, 256),
+	}
+}
+
+// Start runs the workers until ctx is cancelled and re-queues uploads left pending by a previous run.
+func (p *Processor) Start(ctx context.Context, workers int) {
+	p.once.Do(func() {
+		if workers <= 0 {
+			workers = 2
+		}
+		for i := 0; i < workers; i++ {
+			go p.run(ctx)
+		}
+		go func() {
+			ids, err := store.GetPendingAttachmentIDs(ctx, p.pool, 0)
+			if err != nil {
+				log.Printf("attachments: load pending error: %v", err)
+				return
+			}
+			if len(ids) > 0 {
+				log.Printf("attachments: resuming %d pending attachment(s)", len(ids))
+			}
+			for _, id := range ids {
+				p.Enqueue(ctx, id)
+			}
+		}()
+	})
+}
+
+// Enqueue schedules an attachment for processing. While the queue is full it blocks, so uploads
+// slow down to the processing rate; if ctx ends first the attachment stays pending until the
+// next Start picks it up.
+func (p *Processor) Enqueue(ctx context.Context, id uuid.UUID) {
+	select {
+	case p.queue <- id:
+	case <-ctx.Done():
+		log.Printf("attachments: %s not queued (%v), left pending", id, ctx.Err())
+	}
+}
+
+func (p *Processor) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-p.queue:
+			p.process(ctx, id)
+		}
+	}
+}
+
+func (p *Processor) process(ctx context.Context, id uuid.UUID) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	a, err := store.GetAttachment(ctx, p.pool, id)
+	if err != nil {
+		log.Printf("attachments: load %s error: %v", id, err)
+		return
+	}
+	if a.Status != store.AttachmentPending {
+		return
+	}
+
+	event := EventAttachmentReady
+	if err := p.processImage(ctx, a); err != nil {
+		log.Printf("attachments: process %s failed: %v", id, err)
+		if err := store.MarkAttachmentFailed(ctx, p.pool, id); err != nil {
+			log.Printf("attachments: mark %s failed error: %v", id, err)
+			return
+		}
+		event = EventAttachmentFailed
+	}
+
+	if a, err = store.GetAttachment(ctx, p.pool, id); err != nil {
+		log.Printf("attachments: reload %s error: %v", id, err)
+		return
+	}
+	if p.publisher != nil {
+		payload := map[string]any{
+			"type":            event,
+			"conversation_id": a.ConversationID,
+			"message_id":      a.MessageID,
+			"attachment":      a,
+		}
+		if err := p.publisher.PublishEvent(a.ConversationID.String(), payload); err != nil {
+			log.Printf("attachments: publish %s error: %v", event, err)
+		}
+	}
+}
+
+// processImage stores a metadata-free copy of the upload and its thumbnails, then marks the attachment ready.
+func (p *Processor) processImage(ctx context.Context, a store.Attachment) error {
+	rc, err := p.storage.Get(ctx, uploadKey(a))
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(rc, MaxBytes()+1))
+	rc.Close()
+	if err != nil {
+		return err
+	}
+
+	orientation := 1
+	var clean []byte
+	switch a.ContentType {
+	case "image/jpeg":
+		orientation = jpegOrientation(data)
+		clean, err = stripJPEG(data)
+	case "image/png":
+		clean, err = stripPNG(data)
+	case "image/webp":
+		clean, err = stripWebP(data)
+	default:
+		// GIF has no EXIF block
+		clean = data
+	}
+	if err != nil {
+		return err
+	}
+
+	var width, height *int
+	var thumbs []store.Thumbnail
+	cfg, _, cfgErr := image.DecodeConfig(bytes.NewReader(clean))
+	if a.ContentType == "image/webp" {
+		// webp can't be decoded with the standard library: it is served stripped, without
+		// thumbnails, with the size from its headers
+		if w, h, err := webpSize(clean); err == nil {
+			width, height = &w, &h
+		}
+	} else if cfgErr == nil && cfg.Width*cfg.Height <= maxDecodePixels {
+		src, _, err := image.Decode(bytes.NewReader(clean))
+		if err != nil {
+			return err
+		}
+		img := applyOrientation(toRGBA(src), orientation)
+		w, h := img.Rect.Dx(), img.Rect.Dy()
+		width, height = &w, &h
+
+		if orientation > 1 {
+			// the orientation tag was stripped along with the rest of EXIF, so the pixels are rotated instead
+			if clean, _, err = encodeImage(img, a.ContentType, 90); err != nil {
+				return err
+			}
+		}
+
+		for _, size := range thumbnailSizes {
+			if size >= max(w, h) {
+				break
+			}
+			tw, th := fitWithin(w, h, size)
+			b, ct, err := encodeImage(resize(img, tw, th), a.ContentType, 80)
+			if err != nil {
+				return err
+			}
+			t := store.Thumbnail{Size: size, Width: tw, Height: th, ContentType: ct, SizeBytes: int64(len(b)), StorageKey: thumbnailKey(a, size)}
+			if err := p.storage.Put(ctx, t.StorageKey, bytes.NewReader(b), t.SizeBytes, ct); err != nil {
+				return err
+			}
+			thumbs = append(thumbs, t)
+		}
+	}
+
+	if err := p.storage.Put(ctx, a.StorageKey, bytes.NewReader(clean), int64(len(clean)), a.ContentType); err != nil {
+		return err
+	}
+	if err := store.MarkAttachmentReady(ctx, p.pool, a.ID, int64(len(clean)), width, height, thumbs); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			// its message was deleted meanwhile: drop what was just stored
+			DeleteBlobs(ctx, p.storage, []store.Attachment{a})
+		}
+		return err
+	}
+	if err := p.storage.Delete(ctx, uploadKey(a)); err != nil {
+		log.Printf("attachments: delete upload of %s error: %v", a.ID, err)
+	}
+	log.Printf("attachments: processed %s (%d thumbnail(s))", a.ID, len(thumbs))
+	return nil
+}
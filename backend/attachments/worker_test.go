@@ -0,0 +1,48 @@
+package attachments
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/Y3rnur/go-realtime-chat/backend/storage"
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+func TestDeleteBlobs(t *testing.T) {
+	st, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	put := func(key string) {
+		t.Helper()
+		if err := st.Put(ctx, key, strings.NewReader("x"), 1, "image/png"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ready := store.Attachment{ID: uuid.New(), StorageKey: "attachments/" + uuid.NewString()}
+	pending := store.Attachment{ID: uuid.New(), StorageKey: "attachments/" + uuid.NewString()}
+	kept := store.Attachment{ID: uuid.New(), StorageKey: "attachments/" + uuid.NewString()}
+	// only some thumbnail sizes were rendered
+	put(ready.StorageKey)
+	put(thumbnailKey(ready, thumbnailSizes[0]))
+	put(uploadKey(pending))
+	put(kept.StorageKey)
+
+	DeleteBlobs(ctx, st, []store.Attachment{ready, pending})
+
+	for _, key := range []string{ready.StorageKey, thumbnailKey(ready, thumbnailSizes[0]), uploadKey(pending)} {
+		if _, err := st.Get(ctx, key); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("%s: err = %v, want %v", key, err, storage.ErrNotFound)
+		}
+	}
+	rc, err := st.Get(ctx, kept.StorageKey)
+	if err != nil {
+		t.Fatalf("other attachment: %v", err)
+	}
+	rc.Close()
+}
@@ -22,7 +22,13 @@ import (
 
 type authCtxKey string
 
-const userIDKey authCtxKey = "userID"
+const (
+	userIDKey    authCtxKey = "userID"
+	principalKey authCtxKey = "principal"
+)
+
+// ErrAccountSuspended is returned for sessions of users deactivated by a moderator or admin.
+var ErrAccountSuspended = errors.New("account suspended")
 
 // Access token lifetime
 const accessTokenTTL = 15 * time.Minute
@@ -30,6 +36,12 @@ const accessTokenTTL = 15 * time.Minute
 // Refresh token lifetime
 const refreshTokenTTL = 7 * 24 * time.Hour
 
+// token types ("typ" claim); tokens without the claim are treated as access tokens
+const (
+	tokenTypeAccess    = "access"
+	tokenTypeChallenge = "2fa_challenge"
+)
+
 // GenerateJWT creates an HS256 JWT with configurable expiry.
 func GenerateJWTWithExpiry(userID, email, displayName string, ttl time.Duration) (string, error) {
 	secret := os.Getenv("JWT_SECRET")
@@ -41,6 +53,7 @@ func GenerateJWTWithExpiry(userID, email, displayName string, ttl time.Duration)
 		"sub":          userID,
 		"email":        email,
 		"display_name": displayName,
+		"typ":          tokenTypeAccess,
 		"iat":          now.Unix(),
 		"exp":          now.Add(ttl).Unix(),
 	}
@@ -77,53 +90,120 @@ func parseToken(tokenStr string) (jwt.MapClaims, error) {
 	return claims, nil
 }
 
+// parseAccessToken validates a token and rejects the ones that are not access tokens (e.g. 2FA challenges).
+func parseAccessToken(tokenStr string) (jwt.MapClaims, error) {
+	claims, err := parseToken(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if typ, _ := claims["typ"].(string); typ != "" && typ != tokenTypeAccess {
+		return nil, errors.New("not an access token")
+	}
+	return claims, nil
+}
+
+// where the access token of a request came from
+type authSource int
+
+const (
+	authSourceNone authSource = iota
+	authSourceHeader
+	authSourceCookie
+	authSourceQuery
+)
+
 // GetUserIDFromRequest extracts a token from Authorization header, cookie, or ?token= and returns the "sub".
+// API tokens are accepted as well; use GetPrincipalFromRequest to tell them apart.
 func GetUserIDFromRequest(r *http.Request) (string, error) {
+	p, err := GetPrincipalFromRequest(r)
+	return p.UserID, err
+}
+
+// GetPrincipalFromRequest authenticates the request with a session JWT or an API token.
+func GetPrincipalFromRequest(r *http.Request) (Principal, error) {
+	p, _, err := authenticateRequest(r)
+	return p, err
+}
+
+// authenticateRequest resolves the principal and also reports the token source (needed for CSRF checks).
+func authenticateRequest(r *http.Request) (Principal, authSource, error) {
 	// Authorization header
 	if auth := r.Header.Get("Authorization"); auth != "" {
 		if strings.HasPrefix(auth, "Bearer ") {
 			token := strings.TrimPrefix(auth, "Bearer ")
-			if claims, err := parseToken(token); err == nil {
+			if isAPIToken(token) {
+				p, err := lookupAPIToken(r.Context(), token)
+				return p, authSourceHeader, err
+			}
+			if claims, err := parseAccessToken(token); err == nil {
 				if sub, ok := claims["sub"].(string); ok {
-					return sub, nil
+					return userPrincipal(sub), authSourceHeader, nil
 				}
 			} else {
-				return "", err
+				return Principal{}, authSourceNone, err
 			}
 		}
 	}
 	// cookie
 	if c, err := r.Cookie("access_token"); err == nil && c.Value != "" {
-		if claims, err := parseToken(c.Value); err == nil {
+		if claims, err := parseAccessToken(c.Value); err == nil {
 			if sub, ok := claims["sub"].(string); ok {
-				return sub, nil
+				return userPrincipal(sub), authSourceCookie, nil
 			}
 		} else {
-			return "", err
+			return Principal{}, authSourceNone, err
 		}
 	}
 	// query param
 	if q := r.URL.Query().Get("token"); q != "" {
-		if claims, err := parseToken(q); err == nil {
+		if isAPIToken(q) {
+			p, err := lookupAPIToken(r.Context(), q)
+			return p, authSourceQuery, err
+		}
+		if claims, err := parseAccessToken(q); err == nil {
 			if sub, ok := claims["sub"].(string); ok {
-				return sub, nil
+				return userPrincipal(sub), authSourceQuery, nil
 			}
 		} else {
-			return "", err
+			return Principal{}, authSourceNone, err
 		}
 	}
-	return "", errors.New("missing or invalid token")
+	return Principal{}, authSourceNone, errors.New("missing or invalid token")
 }
 
-// RequireAuth wraps a handler and enforces a valid token; it injects user id into the request context.
+// RequireAuth wraps a handler and enforces a valid session token; it injects user id into the request context.
+// Cookie-authenticated state-changing requests must also carry a valid CSRF token.
+// API tokens are rejected here; endpoints open to them use RequireAuthScoped.
 func RequireAuth(next http.Handler) http.Handler {
+	return RequireAuthScoped(nil, next)
+}
+
+// RequireAuthScoped is RequireAuth that also accepts API tokens holding the scope listed for the request method.
+func RequireAuthScoped(scopes MethodScopes, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		uid, err := GetUserIDFromRequest(r)
+		p, src, err := authenticateRequest(r)
 		if err != nil {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
-		ctx := context.WithValue(r.Context(), userIDKey, uid)
+		if src == authSourceCookie && isStateChangingMethod(r.Method) && !validCSRF(r) {
+			log.Printf("csrf: rejected %s %s for user %s", r.Method, r.URL.Path, p.UserID)
+			http.Error(w, "invalid csrf token", http.StatusForbidden)
+			return
+		}
+		if p.Kind == PrincipalAPIToken {
+			scope, ok := scopes[r.Method]
+			if !ok {
+				http.Error(w, "endpoint not available to api tokens", http.StatusForbidden)
+				return
+			}
+			if !p.HasScope(scope) {
+				http.Error(w, "missing scope: "+scope, http.StatusForbidden)
+				return
+			}
+		}
+		ctx := context.WithValue(r.Context(), userIDKey, p.UserID)
+		ctx = context.WithValue(ctx, principalKey, p)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -138,6 +218,12 @@ func GetUserIDFromCtx(ctx context.Context) string {
 	return ""
 }
 
+// GetPrincipalFromCtx returns the authenticated principal from context.
+func GetPrincipalFromCtx(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalKey).(Principal)
+	return p, ok
+}
+
 // helpers for refresh token generation / hashing
 func generateRandomToken(nbytes int) (string, error) {
 	b := make([]byte, nbytes)
@@ -147,7 +233,9 @@ func generateRandomToken(nbytes int) (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
-func hashToken(tok string) string {
+// HashToken returns the hex SHA-256 of a random token; only this hash is stored (refresh,
+// reset and API tokens, recovery codes, incoming webhook tokens).
+func HashToken(tok string) string {
 	h := sha256.Sum256([]byte(tok))
 	return hex.EncodeToString(h[:])
 }
@@ -158,7 +246,7 @@ func createRefreshToken(pool *pgxpool.Pool, userID string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	hash := hashToken(token)
+	hash := HashToken(token)
 	expires := time.Now().Add(refreshTokenTTL)
 	_, err = pool.Exec(context.Background(), `
 		INSERT INTO refresh_tokens (user_id, token_hash, expires_at)
@@ -171,30 +259,35 @@ func createRefreshToken(pool *pgxpool.Pool, userID string) (string, error) {
 }
 
 // rotateRefreshToken finds a token row by hash, ensures valid, and rotates it (update token_hash/expires). Returns new raw token.
+// Tokens of suspended users fail with ErrAccountSuspended.
 func rotateRefreshToken(pool *pgxpool.Pool, oldToken string) (string, string, error) {
-	oldHash := hashToken(oldToken)
+	oldHash := HashToken(oldToken)
 	var id string
 	var userID string
-	var revoked bool
+	var revoked, active bool
 	var expires time.Time
 	err := pool.QueryRow(context.Background(), `
-		SELECT id::text, user_id::text, revoked, expires_at
-		FROM refresh_tokens
-		WHERE token_hash = $1
+		SELECT rt.id::text, rt.user_id::text, rt.revoked, rt.expires_at, u.is_active
+		FROM refresh_tokens rt
+		JOIN users u ON u.id = rt.user_id
+		WHERE rt.token_hash = $1
 		LIMIT 1
-	`, oldHash).Scan(&id, &userID, &revoked, &expires)
+	`, oldHash).Scan(&id, &userID, &revoked, &expires, &active)
 	if err != nil {
 		return "", "", fmt.Errorf("invalid refresh token")
 	}
 	if revoked || time.Now().After(expires) {
 		return "", "", fmt.Errorf("refresh token revoked or expired")
 	}
+	if !active {
+		return "", "", ErrAccountSuspended
+	}
 	// rotate: generate new token and update row
 	newTok, err := generateRandomToken(32)
 	if err != nil {
 		return "", "", err
 	}
-	newHash := hashToken(newTok)
+	newHash := HashToken(newTok)
 	newExpires := time.Now().Add(refreshTokenTTL)
 	_, err = pool.Exec(context.Background(), `
 		UPDATE refresh_tokens SET token_hash=$1, expires_at=$2, created_at=now(), revoked=false WHERE id=$3
@@ -210,7 +303,7 @@ func revokeRefreshToken(pool *pgxpool.Pool, token string) error {
 	if token == "" {
 		return nil
 	}
-	h := hashToken(token)
+	h := HashToken(token)
 	_, err := pool.Exec(context.Background(), `
 		UPDATE refresh_tokens SET revoked = true WHERE token_hash=$1
 	`, h)
@@ -232,8 +325,9 @@ func LoginHandler(pool *pgxpool.Pool) http.Handler {
 		var pwHash string
 		var display sql.NullString
 		var email string
-		row := pool.QueryRow(r.Context(), `SELECT id, password_hash, display_name, email FROM users WHERE email = $1`, req.Email)
-		if err := row.Scan(&id, &pwHash, &display, &email); err != nil {
+		var totpEnabled, active bool
+		row := pool.QueryRow(r.Context(), `SELECT id, password_hash, display_name, email, totp_enabled, is_active FROM users WHERE email = $1`, req.Email)
+		if err := row.Scan(&id, &pwHash, &display, &email, &totpEnabled, &active); err != nil {
 			http.Error(w, "invalid credentials", http.StatusUnauthorized)
 			return
 		}
@@ -241,61 +335,113 @@ func LoginHandler(pool *pgxpool.Pool) http.Handler {
 			http.Error(w, "invalid credentials", http.StatusUnauthorized)
 			return
 		}
-
-		token, err := GenerateJWTWithExpiry(id, email, display.String, accessTokenTTL)
-		if err != nil {
-			http.Error(w, "server error", http.StatusInternalServerError)
+		// only told after the password matched, so suspension doesn't reveal which emails exist
+		if !active {
+			log.Printf("login: rejected suspended user %s", id)
+			http.Error(w, ErrAccountSuspended.Error(), http.StatusForbidden)
 			return
 		}
 
-		// setting httpOnly cookie (secure when TLS)
-		accessCookie := &http.Cookie{
-			Name:     "access_token",
-			Value:    token,
-			HttpOnly: true,
-			SameSite: http.SameSiteLaxMode,
-			Path:     "/",
-			Expires:  time.Now().Add(accessTokenTTL),
-		}
-		if r.TLS != nil {
-			accessCookie.Secure = true
+		if totpEnabled {
+			// password is fine, but a second factor is required before any session cookie is issued
+			challenge, err := generateChallengeToken(r.Context(), pool, id)
+			if err != nil {
+				http.Error(w, "server error", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"two_factor_required": true,
+				"challenge_token":     challenge,
+			})
+			return
 		}
-		http.SetCookie(w, accessCookie)
 
-		// creating refresh token row & cookie
-		refreshRaw, err := createRefreshToken(pool, id)
-		if err != nil {
+		if err := issueSession(w, r, pool, id, email, display.String); err != nil {
+			log.Printf("login: issue session error user=%s: %v", id, err)
 			http.Error(w, "server error", http.StatusInternalServerError)
 			return
 		}
-		refreshCookie := &http.Cookie{
-			Name:     "refresh_token",
-			Value:    refreshRaw,
-			HttpOnly: true,
-			SameSite: http.SameSiteLaxMode,
-			Path:     "/",
-			Expires:  time.Now().Add(refreshTokenTTL),
-		}
-		if r.TLS != nil {
-			refreshCookie.Secure = true
-		}
-		http.SetCookie(w, refreshCookie)
+	})
+}
 
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(map[string]interface{}{
-			"token": token,
-			"user": map[string]interface{}{
-				"id":           id,
-				"email":        email,
-				"display_name": display.String,
-			},
-		})
+// issueSession creates a new session (see setSessionCookies) and writes the login response body.
+func issueSession(w http.ResponseWriter, r *http.Request, pool *pgxpool.Pool, id, email, displayName string) error {
+	token, err := setSessionCookies(w, r, pool, id, email, displayName)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"token": token,
+		"user": map[string]interface{}{
+			"id":           id,
+			"email":        email,
+			"display_name": displayName,
+		},
 	})
 }
 
+// setSessionCookies creates a new access token + refresh token and sets both cookies. Returns the access token.
+func setSessionCookies(w http.ResponseWriter, r *http.Request, pool *pgxpool.Pool, id, email, displayName string) (string, error) {
+	token, err := GenerateJWTWithExpiry(id, email, displayName, accessTokenTTL)
+	if err != nil {
+		return "", err
+	}
+
+	// creating refresh token row first, so no cookie is set when it fails
+	refreshRaw, err := createRefreshToken(pool, id)
+	if err != nil {
+		return "", err
+	}
+
+	// setting httpOnly cookie (secure when TLS)
+	accessCookie := &http.Cookie{
+		Name:     "access_token",
+		Value:    token,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/",
+		Expires:  time.Now().Add(accessTokenTTL),
+	}
+	if r.TLS != nil {
+		accessCookie.Secure = true
+	}
+	http.SetCookie(w, accessCookie)
+
+	refreshCookie := &http.Cookie{
+		Name:     "refresh_token",
+		Value:    refreshRaw,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/",
+		Expires:  time.Now().Add(refreshTokenTTL),
+	}
+	if r.TLS != nil {
+		refreshCookie.Secure = true
+	}
+	http.SetCookie(w, refreshCookie)
+
+	if _, err := setCSRFCookie(w, r); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
 // RefreshHandler rotates refresh token and issues a new access token.
 func RefreshHandler(pool *pgxpool.Pool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// rotation changes state, so it's POST-only and CSRF protected like other cookie-authenticated mutations
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !validCSRF(r) {
+			log.Printf("refresh: csrf check failed from %s", r.RemoteAddr)
+			http.Error(w, "invalid csrf token", http.StatusForbidden)
+			return
+		}
 		// reading the cookie
 		cookieHeader := r.Header.Get("Cookie")
 		var candidates []string
@@ -328,6 +474,11 @@ func RefreshHandler(pool *pgxpool.Pool) http.Handler {
 			}
 			log.Printf("refresh: candidate rotation failed: %v", rotateErr)
 		}
+		if errors.Is(rotateErr, ErrAccountSuspended) {
+			log.Printf("refresh: rejected suspended account")
+			http.Error(w, ErrAccountSuspended.Error(), http.StatusForbidden)
+			return
+		}
 		if rotateErr != nil {
 			log.Printf("refresh: all candidates failed")
 			http.Error(w, "invalid refresh token", http.StatusUnauthorized)
@@ -372,6 +523,12 @@ func RefreshHandler(pool *pgxpool.Pool) http.Handler {
 		}
 		http.SetCookie(w, refreshCookie)
 
+		// issuing a fresh csrf token with the rotated session
+		if _, err := setCSRFCookie(w, r); err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(map[string]interface{}{
 			"token": accessTok,
@@ -387,9 +544,17 @@ func RefreshHandler(pool *pgxpool.Pool) http.Handler {
 // LogoutHandler clears the httpOnly access_token cookie and revokes refresh token.
 func LogoutHandler(pool *pgxpool.Pool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
 		// revoking refresh token if cookie present
 		c, err := r.Cookie("refresh_token")
 		if err == nil && c.Value != "" {
+			if !validCSRF(r) {
+				http.Error(w, "invalid csrf token", http.StatusForbidden)
+				return
+			}
 			_ = revokeRefreshToken(pool, c.Value)
 		}
 		// clearing cookies (access & refresh)
@@ -416,6 +581,7 @@ func LogoutHandler(pool *pgxpool.Pool) http.Handler {
 		clearR2 := *clearR
 		clearR2.Path = "/api"
 		http.SetCookie(w, &clearR2)
+		clearCSRFCookie(w)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(w).Encode(map[string]any{
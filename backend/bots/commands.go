@@ -0,0 +1,170 @@
+package bots
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+var ErrUnknownCommand = errors.New("unknown command")
+
+// ErrInvalidCommand is returned by handlers for malformed arguments; the message is shown to the user.
+type ErrInvalidCommand struct {
+	Msg string
+}
+
+func (e ErrInvalidCommand) Error() string { return e.Msg }
+
+var commandNameRe = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+// Command is a parsed slash command ("/name args...").
+type Command struct {
+	Name           string    `json:"command"`
+	Args           string    `json:"args"`
+	ConversationID uuid.UUID `json:"conversation_id"`
+	UserID         uuid.UUID `json:"user_id"`
+}
+
+// CommandResult tells the message handler what to do after a command ran.
+type CommandResult struct {
+	// Message is posted to the conversation as the invoking user (empty = nothing is posted).
+	Message string `json:"message,omitempty"`
+	// Reply is returned only to the caller.
+	Reply string `json:"reply,omitempty"`
+}
+
+// CommandHandler runs a slash command.
+type CommandHandler interface {
+	HandleCommand(ctx context.Context, cmd Command) (CommandResult, error)
+}
+
+// CommandHandlerFunc adapts a function to CommandHandler.
+type CommandHandlerFunc func(ctx context.Context, cmd Command) (CommandResult, error)
+
+func (f CommandHandlerFunc) HandleCommand(ctx context.Context, cmd Command) (CommandResult, error) {
+	return f(ctx, cmd)
+}
+
+// ParseCommand recognizes "/name args". Text like "/usr/bin" or "/ hi" is not a command.
+func ParseCommand(body string) (Command, bool) {
+	body = strings.TrimSpace(body)
+	if !strings.HasPrefix(body, "/") {
+		return Command{}, false
+	}
+	name, args, _ := strings.Cut(body[1:], " ")
+	name = strings.ToLower(name)
+	if !commandNameRe.MatchString(name) {
+		return Command{}, false
+	}
+	return Command{Name: name, Args: strings.TrimSpace(args)}, true
+}
+
+// Registry maps command names to handlers. Commands without a local handler are
+// forwarded to outgoing webhooks of the conversation that declared them.
+type Registry struct {
+	mu         sync.RWMutex
+	handlers   map[string]CommandHandler
+	dispatcher *Dispatcher
+}
+
+// NewRegistry returns a registry with the built-in commands (/poll, /deploy) registered.
+func NewRegistry(d *Dispatcher) *Registry {
+	reg := &Registry{
+		handlers:   map[string]CommandHandler{},
+		dispatcher: d,
+	}
+	reg.Register("poll", CommandHandlerFunc(pollCommand))
+	reg.Register("deploy", webhookCommand{d: d})
+	return reg
+}
+
+// Register adds (or replaces) the handler of a command.
+func (reg *Registry) Register(name string, h CommandHandler) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.handlers[strings.ToLower(name)] = h
+}
+
+// Names returns the registered command names (sorted).
+func (reg *Registry) Names() []string {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	out := make([]string, 0, len(reg.handlers))
+	for n := range reg.handlers {
+		out = append(out, n)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Dispatch runs cmd with its registered handler, falling back to webhooks declaring the command.
+func (reg *Registry) Dispatch(ctx context.Context, cmd Command) (CommandResult, error) {
+	reg.mu.RLock()
+	h, ok := reg.handlers[cmd.Name]
+	reg.mu.RUnlock()
+	if ok {
+		return h.HandleCommand(ctx, cmd)
+	}
+	if reg.dispatcher != nil {
+		n, err := reg.dispatcher.DispatchCommand(ctx, cmd)
+		if err != nil {
+			return CommandResult{}, err
+		}
+		if n > 0 {
+			return CommandResult{Reply: fmt.Sprintf("/%s sent to %d webhook(s)", cmd.Name, n)}, nil
+		}
+	}
+	return CommandResult{}, ErrUnknownCommand
+}
+
+// webhookCommand forwards a command to the conversation's webhooks that declared it.
+type webhookCommand struct {
+	d *Dispatcher
+}
+
+func (c webhookCommand) HandleCommand(ctx context.Context, cmd Command) (CommandResult, error) {
+	if c.d == nil {
+		return CommandResult{}, ErrInvalidCommand{Msg: fmt.Sprintf("no webhook configured for /%s", cmd.Name)}
+	}
+	n, err := c.d.DispatchCommand(ctx, cmd)
+	if err != nil {
+		return CommandResult{}, err
+	}
+	if n == 0 {
+		return CommandResult{}, ErrInvalidCommand{Msg: fmt.Sprintf("no webhook configured for /%s in this conversation", cmd.Name)}
+	}
+	return CommandResult{Reply: fmt.Sprintf("/%s sent to %d webhook(s)", cmd.Name, n)}, nil
+}
+
+// max options of a /poll
+const maxPollOptions = 10
+
+// pollCommand formats "/poll Question | option 1 | option 2" into a poll message.
+func pollCommand(ctx context.Context, cmd Command) (CommandResult, error) {
+	parts := strings.Split(cmd.Args, "|")
+	var fields []string
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			fields = append(fields, p)
+		}
+	}
+	if len(fields) < 3 {
+		return CommandResult{}, ErrInvalidCommand{Msg: "usage: /poll Question | option 1 | option 2 [| ...]"}
+	}
+	if len(fields)-1 > maxPollOptions {
+		return CommandResult{}, ErrInvalidCommand{Msg: fmt.Sprintf("a poll can have at most %d options", maxPollOptions)}
+	}
+	var b strings.Builder
+	b.WriteString("📊 Poll: ")
+	b.WriteString(fields[0])
+	for i, opt := range fields[1:] {
+		fmt.Fprintf(&b, "\n%d. %s", i+1, opt)
+	}
+	return CommandResult{Message: b.String()}, nil
+}
@@ -0,0 +1,342 @@
+package bots
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Y3rnur/go-realtime-chat/backend"
+	"github.com/Y3rnur/go-realtime-chat/backend/netguard"
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func currentUser(r *http.Request) (uuid.UUID, bool) {
+	uid, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+	return uid, err == nil
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validWebhookURL accepts absolute http(s) URLs without credentials whose host isn't an
+// internal address. Host names are checked again when connecting (see NewDispatcher).
+func validWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.User != nil {
+		return false
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && !netguard.PublicAddr(ip) {
+		return false
+	}
+	return true
+}
+
+// BotsHandler lists (GET) and creates (POST) bot users owned by the caller.
+func BotsHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid, ok := currentUser(r)
+		if !ok {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			list, err := store.GetBotsForOwner(r.Context(), pool, uid)
+			if err != nil {
+				http.Error(w, "database error", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, list)
+
+		case http.MethodPost:
+			var req struct {
+				DisplayName string `json:"display_name"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "invalid request", http.StatusBadRequest)
+				return
+			}
+			name := strings.TrimSpace(req.DisplayName)
+			if name == "" || len(name) > 64 {
+				http.Error(w, "display_name required (max 64 characters)", http.StatusBadRequest)
+				return
+			}
+			b, err := store.CreateBotUser(r.Context(), pool, uid, name)
+			if err != nil {
+				log.Printf("bots: create bot error owner=%s: %v", uid, err)
+				http.Error(w, "server error", http.StatusInternalServerError)
+				return
+			}
+			log.Printf("bots: created bot %s owned by %s", b.ID, uid)
+			writeJSON(w, http.StatusCreated, b)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// BotTokensHandler mints an API token for a bot (POST /api/bots/{id}/tokens, owner only).
+func BotTokensHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uid, ok := currentUser(r)
+		if !ok {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+		botID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid bot id", http.StatusBadRequest)
+			return
+		}
+		owned, err := store.IsBotOwnedBy(r.Context(), pool, botID, uid)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		if !owned {
+			http.Error(w, "bot not found", http.StatusNotFound)
+			return
+		}
+		var req struct {
+			Name   string   `json:"name"`
+			Scopes []string `json:"scopes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		name := strings.TrimSpace(req.Name)
+		if name == "" {
+			name = "bot token"
+		}
+		scopes, err := backend.NormalizeScopes(req.Scopes)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tok, raw, err := backend.CreateAPIToken(r.Context(), pool, botID.String(), name, scopes, nil)
+		if err != nil {
+			log.Printf("bots: create token bot=%s error: %v", botID, err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]any{
+			"token":     raw,
+			"api_token": tok,
+		})
+	})
+}
+
+// ConversationWebhooksHandler lists (GET) and registers (POST) outgoing webhooks of a conversation.
+// Route: /api/conversations/{id}/webhooks (participants list, conversation admins register).
+func ConversationWebhooksHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid, ok := currentUser(r)
+		if !ok {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+		convID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid conversation_id", http.StatusBadRequest)
+			return
+		}
+		member, err := store.IsUserInConversation(r.Context(), pool, convID, uid)
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		if !member {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			hooks, err := store.GetOutgoingWebhooks(r.Context(), pool, convID)
+			if err != nil {
+				http.Error(w, "database error", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, hooks)
+
+		case http.MethodPost:
+			if !requireConversationAdmin(w, r, pool, convID) {
+				return
+			}
+			var req struct {
+				URL      string   `json:"url"`
+				BotID    *string  `json:"bot_id"`
+				Commands []string `json:"commands"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "invalid request", http.StatusBadRequest)
+				return
+			}
+			if !validWebhookURL(req.URL) {
+				http.Error(w, "url must be an absolute http(s) URL", http.StatusBadRequest)
+				return
+			}
+			var commands []string
+			for _, c := range req.Commands {
+				c = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(c), "/"))
+				if _, ok := ParseCommand("/" + c); !ok {
+					http.Error(w, "invalid command name: "+c, http.StatusBadRequest)
+					return
+				}
+				commands = append(commands, c)
+			}
+
+			var botID *uuid.UUID
+			if req.BotID != nil && *req.BotID != "" {
+				id, err := uuid.Parse(*req.BotID)
+				if err != nil {
+					http.Error(w, "invalid bot_id", http.StatusBadRequest)
+					return
+				}
+				owned, err := store.IsBotOwnedBy(r.Context(), pool, id, uid)
+				if err != nil {
+					http.Error(w, "database error", http.StatusInternalServerError)
+					return
+				}
+				if !owned {
+					http.Error(w, "bot not found", http.StatusNotFound)
+					return
+				}
+				// the bot posts replies, so it has to be a participant
+				if err := store.AddParticipant(r.Context(), pool, convID, id, store.RoleBot); err != nil {
+					http.Error(w, "database error", http.StatusInternalServerError)
+					return
+				}
+				botID = &id
+			}
+
+			secret, err := newWebhookSecret()
+			if err != nil {
+				http.Error(w, "server error", http.StatusInternalServerError)
+				return
+			}
+			h, err := store.CreateOutgoingWebhook(r.Context(), pool, convID, botID, uid, req.URL, secret, commands)
+			if err != nil {
+				log.Printf("bots: create webhook conv=%s error: %v", convID, err)
+				http.Error(w, "server error", http.StatusInternalServerError)
+				return
+			}
+			log.Printf("bots: registered webhook %s for conv %s", h.ID, convID)
+			// the signing secret is only returned once
+			writeJSON(w, http.StatusCreated, map[string]any{
+				"webhook": h,
+				"secret":  secret,
+			})
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// loadWebhookForMember returns the webhook when the caller participates in its conversation.
+func loadWebhookForMember(w http.ResponseWriter, r *http.Request, pool *pgxpool.Pool) (store.OutgoingWebhook, bool) {
+	uid, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "invalid user", http.StatusUnauthorized)
+		return store.OutgoingWebhook{}, false
+	}
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid webhook id", http.StatusBadRequest)
+		return store.OutgoingWebhook{}, false
+	}
+	h, err := store.GetOutgoingWebhook(r.Context(), pool, id)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+			return h, false
+		}
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return h, false
+	}
+	member, err := store.IsUserInConversation(r.Context(), pool, h.ConversationID, uid)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return h, false
+	}
+	if !member {
+		// not revealing webhooks of other conversations
+		http.Error(w, "webhook not found", http.StatusNotFound)
+		return h, false
+	}
+	return h, true
+}
+
+// WebhookHandler removes an outgoing webhook (DELETE /api/webhooks/{id}, conversation admins only).
+func WebhookHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h, ok := loadWebhookForMember(w, r, pool)
+		if !ok || !requireConversationAdmin(w, r, pool, h.ConversationID) {
+			return
+		}
+		if err := store.DeleteOutgoingWebhook(r.Context(), pool, h.ID); err != nil && !errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("bots: deleted webhook %s of conv %s", h.ID, h.ConversationID)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// WebhookDeliveriesHandler returns the delivery log of a webhook (GET /api/webhooks/{id}/deliveries).
+func WebhookDeliveriesHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h, ok := loadWebhookForMember(w, r, pool)
+		if !ok {
+			return
+		}
+		list, err := store.GetWebhookDeliveries(r.Context(), pool, h.ID, 100)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, list)
+	})
+}
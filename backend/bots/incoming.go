@@ -0,0 +1,241 @@
+package bots
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Y3rnur/go-realtime-chat/backend"
+	"github.com/Y3rnur/go-realtime-chat/backend/filters"
+	"github.com/Y3rnur/go-realtime-chat/backend/ratelimit"
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+// limits of incoming webhook payloads
+const (
+	maxIncomingBody = 64 << 10
+	maxMessageLen   = 4000
+)
+
+func incomingWebhookURL(token string) string {
+	return backend.AppBaseURL() + "/api/hooks/" + token
+}
+
+// requireConversationAdmin writes the error response and returns false unless the caller administers convID.
+func requireConversationAdmin(w http.ResponseWriter, r *http.Request, pool *pgxpool.Pool, convID uuid.UUID) bool {
+	uid, ok := currentUser(r)
+	if !ok {
+		http.Error(w, "invalid user", http.StatusUnauthorized)
+		return false
+	}
+	admin, err := store.IsConversationAdmin(r.Context(), pool, convID, uid)
+	if err != nil {
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return false
+	}
+	if !admin {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// ConversationIncomingWebhooksHandler lists (GET) and creates (POST) incoming webhooks of a conversation.
+// Route: /api/conversations/{id}/incoming-webhooks (conversation admins only).
+func ConversationIncomingWebhooksHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		convID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid conversation_id", http.StatusBadRequest)
+			return
+		}
+		if !requireConversationAdmin(w, r, pool, convID) {
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			hooks, err := store.GetIncomingWebhooks(r.Context(), pool, convID)
+			if err != nil {
+				http.Error(w, "database error", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, hooks)
+
+		case http.MethodPost:
+			uid, _ := currentUser(r)
+			var req struct {
+				Name string `json:"name"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "invalid request", http.StatusBadRequest)
+				return
+			}
+			name := strings.TrimSpace(req.Name)
+			if name == "" || len(name) > 64 {
+				http.Error(w, "name required (max 64 characters)", http.StatusBadRequest)
+				return
+			}
+			token, err := newWebhookSecret()
+			if err != nil {
+				http.Error(w, "server error", http.StatusInternalServerError)
+				return
+			}
+			h, err := store.CreateIncomingWebhook(r.Context(), pool, convID, uid, name, backend.HashToken(token))
+			if err != nil {
+				log.Printf("bots: create incoming webhook conv=%s error: %v", convID, err)
+				http.Error(w, "server error", http.StatusInternalServerError)
+				return
+			}
+			log.Printf("bots: created incoming webhook %s for conv %s", h.ID, convID)
+			// the token (and so the URL) is only returned once
+			writeJSON(w, http.StatusCreated, map[string]any{
+				"webhook": h,
+				"url":     incomingWebhookURL(token),
+			})
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// loadIncomingWebhookForAdmin returns the hook when the caller administers its conversation.
+func loadIncomingWebhookForAdmin(w http.ResponseWriter, r *http.Request, pool *pgxpool.Pool) (store.IncomingWebhook, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "invalid webhook id", http.StatusBadRequest)
+		return store.IncomingWebhook{}, false
+	}
+	h, err := store.GetIncomingWebhook(r.Context(), pool, id)
+	if err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+			return h, false
+		}
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return h, false
+	}
+	return h, requireConversationAdmin(w, r, pool, h.ConversationID)
+}
+
+// IncomingWebhookHandler enables or disables an incoming webhook (PATCH /api/incoming-webhooks/{id}).
+func IncomingWebhookHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h, ok := loadIncomingWebhookForAdmin(w, r, pool)
+		if !ok {
+			return
+		}
+		var req struct {
+			IsActive *bool `json:"is_active"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.IsActive == nil {
+			http.Error(w, "is_active required", http.StatusBadRequest)
+			return
+		}
+		h, err := store.SetIncomingWebhookActive(r.Context(), pool, h.ID, *req.IsActive)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("bots: incoming webhook %s active=%t", h.ID, h.IsActive)
+		writeJSON(w, http.StatusOK, h)
+	})
+}
+
+// IncomingWebhookRotateHandler issues a new token, invalidating the old URL (POST /api/incoming-webhooks/{id}/rotate).
+func IncomingWebhookRotateHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h, ok := loadIncomingWebhookForAdmin(w, r, pool)
+		if !ok {
+			return
+		}
+		token, err := newWebhookSecret()
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		h, err = store.RotateIncomingWebhookToken(r.Context(), pool, h.ID, backend.HashToken(token))
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("bots: rotated incoming webhook %s", h.ID)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"webhook": h,
+			"url":     incomingWebhookURL(token),
+		})
+	})
+}
+
+// HookHandler accepts {"text": "..."} posted to an incoming webhook URL (POST /api/hooks/{token}).
+// The token is the only credential; the message is attributed to the hook's bot user, whose
+// message rate limits apply.
+func HookHandler(pool *pgxpool.Pool, d *Dispatcher, limiter *ratelimit.Limiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		token := r.PathValue("token")
+		if token == "" {
+			http.Error(w, "webhook not found", http.StatusNotFound)
+			return
+		}
+		h, err := store.GetActiveIncomingWebhookByTokenHash(r.Context(), pool, backend.HashToken(token))
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				http.Error(w, "webhook not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		var exceeded *ratelimit.Exceeded
+		if limiter != nil {
+			if err := limiter.AllowMessage(r.Context(), h.BotUserID.String(), h.ConversationID.String()); errors.As(err, &exceeded) {
+				exceeded.WriteHTTP(w)
+				return
+			}
+		}
+
+		var req struct {
+			Text string `json:"text"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxIncomingBody)).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		text := strings.TrimSpace(req.Text)
+		if text == "" || len(text) > maxMessageLen {
+			http.Error(w, "text required (max 4000 characters)", http.StatusBadRequest)
+			return
+		}
+
+		saved, err := d.postMessage(r.Context(), h.ConversationID, h.BotUserID, text)
+		var rejection *filters.Rejection
+		if errors.As(err, &rejection) {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": rejection.Reason, "filter": rejection.Filter})
+			return
+		}
+		if err != nil {
+			log.Printf("bots: incoming webhook %s save error: %v", h.ID, err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, saved)
+	})
+}
@@ -0,0 +1,424 @@
+package bots
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Y3rnur/go-realtime-chat/backend/filters"
+	"github.com/Y3rnur/go-realtime-chat/backend/netguard"
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+// Headers sent with every outgoing webhook request. The signature is
+// hex(HMAC-SHA256(secret, timestamp + "." + body)).
+const (
+	SignatureHeader = "X-Chat-Signature"
+	TimestampHeader = "X-Chat-Timestamp"
+	EventHeader     = "X-Chat-Event"
+)
+
+// event types delivered to outgoing webhooks
+const (
+	EventMessageCreated = "message_created"
+	EventCommand        = "command"
+)
+
+// delivery limits
+const (
+	// maximum size of a webhook response body that is read (for bot replies)
+	maxWebhookResponse = 64 << 10
+	deliveryTimeout    = 10 * time.Second
+	maxRedirects       = 3
+	// deliveries waiting for a busy webhook; further events for it are dropped
+	maxPendingPerHook = 32
+)
+
+// PublishFunc fans a saved message out like any other new message (hub, mentions, webhooks,
+// link previews).
+type PublishFunc func(store.Message)
+
+// job is a queued unit of work: the webhooks of a new message are looked up, a delivery is sent.
+type job struct {
+	message  *store.Message
+	delivery *delivery
+}
+
+type delivery struct {
+	hook    store.OutgoingWebhook
+	event   string
+	payload any
+	// attempt is the number of the next attempt; backoff the wait before the one after it
+	attempt int
+	backoff time.Duration
+}
+
+// Dispatcher delivers conversation events to outgoing webhooks with retries and a delivery log.
+// Deliveries run on a fixed number of workers; when the queue is full, events are dropped.
+// A webhook gets one delivery at a time and retries wait on a timer, not on a worker, so a
+// slow or unreachable URL holds up its own events only.
+type Dispatcher struct {
+	pool    *pgxpool.Pool
+	filters *filters.Pipeline
+	publish PublishFunc
+	client  *http.Client
+
+	maxAttempts int
+	backoff     time.Duration
+
+	queue chan job
+	once  sync.Once
+
+	mu sync.Mutex
+	// pending holds the waiting deliveries of each webhook with one in flight
+	pending map[uuid.UUID][]*delivery
+}
+
+// NewDispatcher creates a dispatcher posting bot replies and incoming webhook messages through
+// contentFilters and publish. Webhook URLs are user-supplied, so connections to internal
+// addresses are refused.
+func NewDispatcher(pool *pgxpool.Pool, contentFilters *filters.Pipeline, publish PublishFunc) *Dispatcher {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: netguard.Control(netguard.PublicAddr),
+	}
+	transport := &http.Transport{
+		// no proxy: it would connect on our behalf and skip the address check
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ResponseHeaderTimeout: deliveryTimeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+	return &Dispatcher{
+		pool:    pool,
+		filters: contentFilters,
+		publish: publish,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   deliveryTimeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxRedirects {
+					return errors.New("bots: too many redirects")
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return fmt.Errorf("bots: redirect to %q not allowed", req.URL.Scheme)
+				}
+				return nil
+			},
+		},
+		maxAttempts: 4,
+		backoff:     time.Second,
+		queue:       make(chan job, 256),
+		pending:     map[uuid.UUID][]*delivery{},
+	}
+}
+
+// Start runs the delivery workers until ctx is cancelled.
+func (d *Dispatcher) Start(ctx context.Context, workers int) {
+	d.once.Do(func() {
+		if workers <= 0 {
+			workers = 4
+		}
+		for i := 0; i < workers; i++ {
+			go d.run(ctx)
+		}
+	})
+}
+
+// enqueue never blocks: the request (or worker) handing over the job goes on, and a full
+// queue drops the event.
+func (d *Dispatcher) enqueue(j job) bool {
+	select {
+	case d.queue <- j:
+		return true
+	default:
+		return false
+	}
+}
+
+func (d *Dispatcher) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-d.queue:
+			if j.message != nil {
+				d.fanOut(ctx, *j.message)
+			} else {
+				d.deliver(j.delivery)
+			}
+		}
+	}
+}
+
+// Sign computes the signature header value for a payload.
+func Sign(secret string, ts int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(ts, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// MessageCreated notifies the conversation's webhooks about a new message (asynchronously).
+func (d *Dispatcher) MessageCreated(m store.Message) {
+	if !d.enqueue(job{message: &m}) {
+		log.Printf("bots: queue full, dropping webhook events of message %s", m.ID)
+	}
+}
+
+// fanOut queues a message_created delivery for each webhook of the message's conversation.
+func (d *Dispatcher) fanOut(ctx context.Context, m store.Message) {
+	hooks, err := store.GetActiveOutgoingWebhooks(ctx, d.pool, m.ConversationID)
+	if err != nil {
+		log.Printf("bots: load webhooks conv=%s error: %v", m.ConversationID, err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+	fromBot, err := store.IsBotUser(ctx, d.pool, m.AuthorID)
+	if err != nil {
+		log.Printf("bots: load author of message %s error: %v", m.ID, err)
+		return
+	}
+	for _, h := range hooks {
+		// webhooks with a bot can reply, so they don't get bot messages: two bots answering
+		// each other would loop forever
+		if h.BotUserID != nil && fromBot {
+			continue
+		}
+		payload := map[string]any{
+			"type":            EventMessageCreated,
+			"webhook_id":      h.ID,
+			"conversation_id": m.ConversationID,
+			"message":         m,
+		}
+		if !d.enqueue(job{delivery: &delivery{hook: h, event: EventMessageCreated, payload: payload, attempt: 1, backoff: d.backoff}}) {
+			log.Printf("bots: queue full, dropping %s delivery to webhook %s", EventMessageCreated, h.ID)
+		}
+	}
+}
+
+// DispatchCommand forwards a slash command to the webhooks that declared it; returns how many were targeted.
+func (d *Dispatcher) DispatchCommand(ctx context.Context, cmd Command) (int, error) {
+	hooks, err := store.GetActiveOutgoingWebhooks(ctx, d.pool, cmd.ConversationID)
+	if err != nil {
+		return 0, err
+	}
+	var userName string
+	if dn, err := store.GetUserDisplayName(ctx, d.pool, cmd.UserID); err == nil && dn != nil {
+		userName = *dn
+	}
+	n := 0
+	for _, h := range hooks {
+		if !hasCommand(h.Commands, cmd.Name) {
+			continue
+		}
+		payload := map[string]any{
+			"type":            EventCommand,
+			"webhook_id":      h.ID,
+			"conversation_id": cmd.ConversationID,
+			"command":         cmd.Name,
+			"args":            cmd.Args,
+			"user_id":         cmd.UserID,
+			"user_name":       userName,
+		}
+		if !d.enqueue(job{delivery: &delivery{hook: h, event: EventCommand, payload: payload, attempt: 1, backoff: d.backoff}}) {
+			log.Printf("bots: queue full, dropping %s delivery to webhook %s", EventCommand, h.ID)
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
+
+func hasCommand(list []string, name string) bool {
+	for _, c := range list {
+		if strings.EqualFold(c, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// deliver sends dl and then the deliveries that queued up for its webhook meanwhile. When the
+// webhook already has a delivery in flight, dl waits for it instead.
+func (d *Dispatcher) deliver(dl *delivery) {
+	if !d.acquire(dl) {
+		return
+	}
+	for dl != nil {
+		d.attempt(dl)
+		dl = d.release(dl.hook.ID)
+	}
+}
+
+// acquire marks dl's webhook busy and reports true, or queues dl behind the delivery in flight
+// (dropping it when maxPendingPerHook are waiting).
+func (d *Dispatcher) acquire(dl *delivery) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	waiting, busy := d.pending[dl.hook.ID]
+	if !busy {
+		d.pending[dl.hook.ID] = nil
+		return true
+	}
+	if len(waiting) >= maxPendingPerHook {
+		log.Printf("bots: webhook %s is backed up, dropping %s delivery", dl.hook.ID, dl.event)
+		return false
+	}
+	d.pending[dl.hook.ID] = append(waiting, dl)
+	return false
+}
+
+// release returns the next waiting delivery of the webhook, or marks it idle and returns nil.
+func (d *Dispatcher) release(hookID uuid.UUID) *delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	waiting := d.pending[hookID]
+	if len(waiting) == 0 {
+		delete(d.pending, hookID)
+		return nil
+	}
+	d.pending[hookID] = waiting[1:]
+	return waiting[0]
+}
+
+// attempt posts the payload once. Network errors, 429 and 5xx are retried with exponential
+// backoff: the retry is queued again when its timer fires.
+func (d *Dispatcher) attempt(dl *delivery) {
+	h := dl.hook
+	body, err := json.Marshal(dl.payload)
+	if err != nil {
+		log.Printf("bots: marshal %s payload error: %v", dl.event, err)
+		return
+	}
+	status, respBody, dur, err := d.post(h, dl.event, body)
+
+	var statusPtr *int
+	var errPtr *string
+	if status != 0 {
+		statusPtr = &status
+	}
+	if err != nil {
+		msg := err.Error()
+		errPtr = &msg
+	}
+	if lerr := store.LogWebhookDelivery(context.Background(), d.pool, h.ID, dl.event, dl.attempt, statusPtr, errPtr, dur); lerr != nil {
+		log.Printf("bots: log delivery webhook=%s error: %v", h.ID, lerr)
+	}
+
+	if err == nil && status >= 200 && status < 300 {
+		d.handleReply(h, respBody)
+		return
+	}
+	retryable := err != nil || status == http.StatusTooManyRequests || status >= 500
+	if !retryable || dl.attempt >= d.maxAttempts {
+		log.Printf("bots: delivery to webhook %s failed (attempt %d, status %d, err %v)", h.ID, dl.attempt, status, err)
+		return
+	}
+	retry := *dl
+	retry.attempt++
+	retry.backoff *= 2
+	time.AfterFunc(dl.backoff, func() {
+		if !d.enqueue(job{delivery: &retry}) {
+			log.Printf("bots: queue full, dropping retry %d of %s delivery to webhook %s", retry.attempt, retry.event, h.ID)
+		}
+	})
+}
+
+func (d *Dispatcher) post(h store.OutgoingWebhook, event string, body []byte) (int, []byte, time.Duration, error) {
+	start := time.Now()
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	ts := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(ts, 10))
+	req.Header.Set(SignatureHeader, Sign(h.Secret, ts, body))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, nil, time.Since(start), err
+	}
+	defer res.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(res.Body, maxWebhookResponse))
+	return res.StatusCode, respBody, time.Since(start), nil
+}
+
+// handleReply posts {"text": "..."} responses as a message of the webhook's bot.
+func (d *Dispatcher) handleReply(h store.OutgoingWebhook, body []byte) {
+	if h.BotUserID == nil || len(bytes.TrimSpace(body)) == 0 {
+		return
+	}
+	var reply struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(body, &reply); err != nil {
+		return
+	}
+	text := strings.TrimSpace(reply.Text)
+	if text == "" {
+		return
+	}
+	if _, err := d.postMessage(context.Background(), h.ConversationID, *h.BotUserID, truncate(text, maxMessageLen)); err != nil {
+		log.Printf("bots: save reply of bot %s error: %v", *h.BotUserID, err)
+	}
+}
+
+// postMessage saves and publishes a bot-authored message. It passes the content filters like a
+// message sent by a person; a refused message is returned as *filters.Rejection.
+func (d *Dispatcher) postMessage(ctx context.Context, convID, botUserID uuid.UUID, text string) (store.Message, error) {
+	filtered := filters.Result{Body: text}
+	if d.filters != nil {
+		var err error
+		filtered, err = d.filters.Apply(ctx, d.pool, filters.Message{ConversationID: convID, AuthorID: botUserID, Body: text})
+		if err != nil {
+			return store.Message{}, err
+		}
+	}
+	saved, err := store.SaveMessage(ctx, d.pool, convID, botUserID, filtered.Body)
+	if err != nil {
+		return saved, err
+	}
+	if err := filtered.Flag(ctx, d.pool, saved); err != nil {
+		log.Printf("bots: flag message %s error: %v", saved.ID, err)
+	}
+	if d.publish != nil {
+		d.publish(saved)
+	}
+	return saved, nil
+}
+
+// truncate shortens s to at most n bytes without splitting a UTF-8 sequence.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,113 @@
+package conversations
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Y3rnur/go-realtime-chat/backend"
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// SettingsHandler reads (GET) and changes (PATCH) the caller's own settings for a conversation.
+// Route: /api/conversations/{id}/settings. PATCH accepts any of
+//
+//	{"muted": true, "muted_until": "2026-01-02T15:04:05Z" | "mute_for": "8h", "archived": true, "pinned": true}
+//
+// A mute without muted_until/mute_for lasts until it is turned off.
+func SettingsHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPatch {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uid, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+		if err != nil {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+		convID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid conversation id", http.StatusBadRequest)
+			return
+		}
+
+		var settings store.ConversationSettings
+		if r.Method == http.MethodGet {
+			settings, err = store.GetConversationSettings(r.Context(), pool, convID, uid)
+		} else {
+			var upd store.ConversationSettingsUpdate
+			upd, err = decodeSettingsUpdate(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			settings, err = store.UpdateConversationSettings(r.Context(), pool, convID, uid, upd)
+		}
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		case errors.Is(err, store.ErrPinnedConversationsLimit):
+			http.Error(w, fmt.Sprintf("at most %d conversations can be pinned", store.MaxPinnedConversations), http.StatusConflict)
+			return
+		case err != nil:
+			log.Printf("conversations: settings %s conv=%s user=%s error: %v", r.Method, convID, uid, err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, settings)
+	})
+}
+
+func decodeSettingsUpdate(r *http.Request) (store.ConversationSettingsUpdate, error) {
+	var req struct {
+		Muted      *bool      `json:"muted"`
+		MutedUntil *time.Time `json:"muted_until"`
+		MuteFor    string     `json:"mute_for"`
+		Archived   *bool      `json:"archived"`
+		Pinned     *bool      `json:"pinned"`
+	}
+	var upd store.ConversationSettingsUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return upd, errors.New("invalid request")
+	}
+	if req.MuteFor != "" {
+		d, err := time.ParseDuration(req.MuteFor)
+		if err != nil || d <= 0 {
+			return upd, errors.New("invalid mute_for")
+		}
+		if req.MutedUntil != nil {
+			return upd, errors.New("use either muted_until or mute_for")
+		}
+		until := time.Now().Add(d)
+		req.MutedUntil = &until
+	}
+	if req.MutedUntil != nil {
+		if req.Muted != nil && !*req.Muted {
+			return upd, errors.New("muted_until requires muted")
+		}
+		if !req.MutedUntil.After(time.Now()) {
+			return upd, errors.New("muted_until must be in the future")
+		}
+		muted := true
+		req.Muted = &muted
+	}
+	if req.Muted == nil && req.Archived == nil && req.Pinned == nil {
+		return upd, errors.New("nothing to update")
+	}
+	upd.Muted, upd.MutedUntil, upd.Archived, upd.Pinned = req.Muted, req.MutedUntil, req.Archived, req.Pinned
+	return upd, nil
+}
@@ -0,0 +1,164 @@
+package conversations
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Y3rnur/go-realtime-chat/backend"
+	"github.com/Y3rnur/go-realtime-chat/backend/attachments"
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+// EventConversationUpdated is sent to every participant when a conversation's details change.
+const EventConversationUpdated = "conversation_updated"
+
+// field limits (characters)
+const (
+	maxTitleLength       = 100
+	maxDescriptionLength = 1000
+)
+
+// UserEventPublisher is the part of the hub the update handler needs.
+type UserEventPublisher interface {
+	PublishUserEvent(userID string, v interface{}) error
+}
+
+// Handler reads (GET) and changes (PATCH {"title", "avatar_url", "description"}) a conversation.
+// Route: /api/conversations/{id}. Any participant can read; only owners and admins of a group can
+// change it. Each change is recorded as a system message (sent through publish) and announced to
+// every participant with a conversation_updated event.
+func Handler(pool *pgxpool.Pool, events UserEventPublisher, publish func(store.Message)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPatch {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uid, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+		if err != nil {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+		convID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid conversation id", http.StatusBadRequest)
+			return
+		}
+
+		role, isGroup, err := store.GetParticipantRole(r.Context(), pool, convID, uid)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			c, err := store.GetConversation(r.Context(), pool, convID)
+			if err != nil {
+				http.Error(w, "database error", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, c)
+			return
+		}
+
+		if !isGroup {
+			http.Error(w, "direct conversations can't be changed", http.StatusBadRequest)
+			return
+		}
+		if role != store.RoleOwner && role != store.RoleAdmin {
+			http.Error(w, "only conversation owners and admins can change it", http.StatusForbidden)
+			return
+		}
+		upd, err := decodeUpdate(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		c, changed, msgs, err := store.UpdateConversation(r.Context(), pool, convID, uid, upd)
+		if err != nil {
+			log.Printf("conversations: update conv=%s user=%s error: %v", convID, uid, err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		if len(changed) > 0 {
+			for _, m := range msgs {
+				publish(m)
+			}
+			announceUpdate(r, pool, events, c, changed, uid)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"conversation": c, "changed": changed})
+	})
+}
+
+// announceUpdate sends conversation_updated to every participant (on all their connections,
+// so sidebars update whichever conversation is open).
+func announceUpdate(r *http.Request, pool *pgxpool.Pool, events UserEventPublisher, c store.Conversation, changed []string, actorID uuid.UUID) {
+	participants, _, err := store.GetParticipants(r.Context(), pool, c.ID)
+	if err != nil {
+		log.Printf("conversations: load participants conv=%s error: %v", c.ID, err)
+		return
+	}
+	payload := map[string]any{
+		"type":            EventConversationUpdated,
+		"conversation_id": c.ID,
+		"conversation":    c,
+		"changed":         changed,
+		"user_id":         actorID,
+	}
+	for _, p := range participants {
+		if err := events.PublishUserEvent(p.UserID.String(), payload); err != nil {
+			log.Printf("conversations: publish %s (user %s) error: %v", EventConversationUpdated, p.UserID, err)
+		}
+	}
+}
+
+func decodeUpdate(r *http.Request) (store.ConversationUpdate, error) {
+	var req struct {
+		Title       *string `json:"title"`
+		AvatarURL   *string `json:"avatar_url"`
+		Description *string `json:"description"`
+	}
+	var upd store.ConversationUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return upd, errors.New("invalid request")
+	}
+	if req.Title == nil && req.AvatarURL == nil && req.Description == nil {
+		return upd, errors.New("nothing to update")
+	}
+	if req.Title != nil {
+		t := strings.Join(strings.Fields(*req.Title), " ")
+		if t == "" {
+			return upd, errors.New("title can't be empty")
+		}
+		if utf8.RuneCountInString(t) > maxTitleLength {
+			return upd, errors.New("title is too long")
+		}
+		upd.Title = &t
+	}
+	if req.Description != nil {
+		d := strings.TrimSpace(*req.Description)
+		if utf8.RuneCountInString(d) > maxDescriptionLength {
+			return upd, errors.New("description is too long")
+		}
+		upd.Description = &d
+	}
+	if req.AvatarURL != nil {
+		a := strings.TrimSpace(*req.AvatarURL)
+		if a != "" && !attachments.ValidAvatarURL(a) {
+			return upd, errors.New("avatar_url must be an uploaded image or an https URL on an allowed host")
+		}
+		upd.AvatarURL = &a
+	}
+	return upd, nil
+}
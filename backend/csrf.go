@@ -0,0 +1,64 @@
+package backend
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+)
+
+// Double-submit CSRF protection: the token is kept in a cookie readable by our own JS,
+// and state-changing requests authenticated by cookie must echo it in the X-CSRF-Token header.
+const (
+	csrfCookieName = "csrf_token"
+	csrfHeaderName = "X-CSRF-Token"
+)
+
+// setCSRFCookie issues a new csrf token; it lives as long as the refresh token.
+func setCSRFCookie(w http.ResponseWriter, r *http.Request) (string, error) {
+	tok, err := generateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    tok,
+		HttpOnly: false, // the frontend has to read it
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/",
+		Expires:  time.Now().Add(refreshTokenTTL),
+	})
+	return tok, nil
+}
+
+func clearCSRFCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    "",
+		Path:     "/",
+		Expires:  time.Unix(0, 0),
+		MaxAge:   -1,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// validCSRF reports whether the header token matches the cookie token.
+func validCSRF(r *http.Request) bool {
+	c, err := r.Cookie(csrfCookieName)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	h := r.Header.Get(csrfHeaderName)
+	if h == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(h)) == 1
+}
+
+func isStateChangingMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,262 @@
+package filters
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+var modes = []string{store.FilterOff, store.FilterFlag, store.FilterRewrite, store.FilterReject}
+
+// settings returns the defaults with one mode changed by set.
+func settings(set func(*store.ContentFilterSettings)) store.ContentFilterSettings {
+	s := store.DefaultContentFilterSettings()
+	set(&s)
+	return s
+}
+
+func TestFiltersModes(t *testing.T) {
+	spamBody := strings.Repeat("!", maxRepeatedRune)
+	tests := []struct {
+		name     string
+		filter   Filter
+		set      func(s *store.ContentFilterSettings, mode string)
+		body     string
+		rewrite  string // expected body in rewrite mode; "" when the filter can't rewrite
+		harmless string
+	}{
+		{
+			name:     "profanity",
+			filter:   NewProfanityFilter(DefaultProfanity()),
+			set:      func(s *store.ContentFilterSettings, mode string) { s.Profanity = mode },
+			body:     "what the Sh1t is this",
+			rewrite:  "what the S*** is this",
+			harmless: "a classic assessment",
+		},
+		{
+			name:   "profanity extra words",
+			filter: NewProfanityFilter(nil),
+			set: func(s *store.ContentFilterSettings, mode string) {
+				s.Profanity = mode
+				s.ExtraWords = []string{"frak"}
+			},
+			body:     "Fraks toasters",
+			rewrite:  "F**** toasters",
+			harmless: "fracking toasters",
+		},
+		{
+			name:     "links",
+			filter:   NewLinkFilter([]string{"evil.example"}),
+			set:      func(s *store.ContentFilterSettings, mode string) { s.Links = mode },
+			body:     "see https://cdn.evil.example/x, then go",
+			rewrite:  "see [link removed], then go",
+			harmless: "see https://notevil.example/x",
+		},
+		{
+			name:   "links conversation blocklist",
+			filter: NewLinkFilter(nil),
+			set: func(s *store.ContentFilterSettings, mode string) {
+				s.Links = mode
+				s.BlockedDomains = []string{"*.spam.example"}
+			},
+			body:     "go to spam.example/buy now",
+			rewrite:  "go to [link removed] now",
+			harmless: "go to example.com/buy now",
+		},
+		{
+			name:     "spam",
+			filter:   NewSpamFilter(),
+			set:      func(s *store.ContentFilterSettings, mode string) { s.Spam = mode },
+			body:     spamBody,
+			harmless: "hello there",
+		},
+	}
+	for _, tt := range tests {
+		for _, mode := range modes {
+			t.Run(tt.name+"/"+mode, func(t *testing.T) {
+				// a new author each time, so the spam filter doesn't count the runs as duplicates
+				m := Message{ConversationID: uuid.New(), AuthorID: uuid.New()}
+				s := settings(func(s *store.ContentFilterSettings) { tt.set(s, mode) })
+
+				m.Body = tt.harmless
+				if v := tt.filter.Check(context.Background(), m, s); v.Action != Allow {
+					t.Fatalf("harmless %q: action %v", tt.harmless, v.Action)
+				}
+
+				m.Body = tt.body
+				v := tt.filter.Check(context.Background(), m, s)
+				want := map[string]Action{store.FilterOff: Allow, store.FilterFlag: Flag, store.FilterRewrite: Rewrite, store.FilterReject: Reject}[mode]
+				if mode == store.FilterRewrite && tt.rewrite == "" {
+					want = Flag
+				}
+				if v.Action != want {
+					t.Fatalf("action %v, want %v", v.Action, want)
+				}
+				if want == Rewrite && v.Body != tt.rewrite {
+					t.Fatalf("rewritten %q, want %q", v.Body, tt.rewrite)
+				}
+				if want != Allow && v.Reason == "" {
+					t.Fatal("no reason")
+				}
+			})
+		}
+	}
+}
+
+func TestSpamHeuristics(t *testing.T) {
+	tests := []struct {
+		body string
+		spam bool
+	}{
+		{"hi", false},
+		{strings.Repeat("https://a.example ", maxLinks), false},
+		{strings.Repeat("https://a.example ", maxLinks+1), true},
+		{strings.Repeat("@bob ", maxMentions+1), true},
+		{strings.Repeat(" ", 40) + "ok", false},
+		{"THIS IS A PERFECTLY NORMAL SENTENCE I AM WRITING", true},
+		{"NASA and ESA are agencies", false},
+	}
+	for _, tt := range tests {
+		if got := spamHeuristics(tt.body) != ""; got != tt.spam {
+			t.Errorf("spamHeuristics(%q) spam = %v, want %v", tt.body, got, tt.spam)
+		}
+	}
+}
+
+func TestSpamFilterDuplicateWindow(t *testing.T) {
+	f := NewSpamFilter()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return now }
+	s := store.DefaultContentFilterSettings()
+	m := Message{ConversationID: uuid.New(), AuthorID: uuid.New(), Body: "buy now"}
+	check := func(m Message) Action { return f.Check(context.Background(), m, s).Action }
+
+	for i := 0; i < maxDuplicates; i++ {
+		if a := check(m); a != Allow {
+			t.Fatalf("send %d: action %v", i+1, a)
+		}
+		now = now.Add(10 * time.Second)
+	}
+	if a := check(m); a != Flag {
+		t.Fatalf("duplicate: action %v, want flag", a)
+	}
+	// other authors, other conversations and other bodies are counted separately
+	if a := check(Message{ConversationID: m.ConversationID, AuthorID: uuid.New(), Body: m.Body}); a != Allow {
+		t.Fatalf("other author: action %v", a)
+	}
+	if a := check(Message{ConversationID: uuid.New(), AuthorID: m.AuthorID, Body: m.Body}); a != Allow {
+		t.Fatalf("other conversation: action %v", a)
+	}
+	if a := check(Message{ConversationID: m.ConversationID, AuthorID: m.AuthorID, Body: "something else"}); a != Allow {
+		t.Fatalf("other body: action %v", a)
+	}
+
+	// once the earlier sends are out of the window the body is fine again
+	now = now.Add(duplicateWindow + time.Second)
+	if a := check(m); a != Allow {
+		t.Fatalf("after window: action %v", a)
+	}
+	if len(f.recent) != 1 {
+		t.Fatalf("expired entries kept: %d", len(f.recent))
+	}
+}
+
+// fakeFilter returns a fixed verdict and records the bodies it saw.
+type fakeFilter struct {
+	name    string
+	verdict Verdict
+	seen    *[]string
+}
+
+func (f fakeFilter) Name() string { return f.name }
+
+func (f fakeFilter) Check(_ context.Context, m Message, _ store.ContentFilterSettings) Verdict {
+	*f.seen = append(*f.seen, f.name+":"+m.Body)
+	return f.verdict
+}
+
+func TestPipelineRun(t *testing.T) {
+	var seen []string
+	p := NewPipeline(
+		fakeFilter{"a", Verdict{Action: Rewrite, Reason: "r", Body: "rewritten"}, &seen},
+		fakeFilter{"b", Verdict{Action: Flag, Reason: "flagged"}, &seen},
+		fakeFilter{"c", Verdict{Action: Allow}, &seen},
+	)
+	res, err := p.Run(context.Background(), Message{Body: "original"}, store.DefaultContentFilterSettings())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a:original", "b:rewritten", "c:rewritten"}; strings.Join(seen, ",") != strings.Join(want, ",") {
+		t.Fatalf("filters saw %v, want %v", seen, want)
+	}
+	if res.Body != "rewritten" {
+		t.Fatalf("body %q", res.Body)
+	}
+	if len(res.Flags) != 1 || res.Flags[0] != (store.ContentFlag{Filter: "b", Reason: "flagged"}) {
+		t.Fatalf("flags %+v", res.Flags)
+	}
+}
+
+func TestPipelineStopsAtRejection(t *testing.T) {
+	var seen []string
+	p := NewPipeline(
+		fakeFilter{"a", Verdict{Action: Flag, Reason: "flagged"}, &seen},
+		fakeFilter{"b", Verdict{Action: Reject, Reason: "no"}, &seen},
+		fakeFilter{"c", Verdict{Action: Reject, Reason: "never asked"}, &seen},
+	)
+	_, err := p.Run(context.Background(), Message{Body: "x"}, store.DefaultContentFilterSettings())
+	var rejection *Rejection
+	if !errors.As(err, &rejection) || rejection.Filter != "b" || rejection.Reason != "no" {
+		t.Fatalf("err = %v", err)
+	}
+	if len(seen) != 2 {
+		t.Fatalf("filters after the rejection ran: %v", seen)
+	}
+}
+
+func TestResultReportReason(t *testing.T) {
+	tests := []struct {
+		flags []string
+		want  string
+	}{
+		{nil, store.ReportOther},
+		{[]string{profanityFilterName}, store.ReportInappropriate},
+		{[]string{profanityFilterName, spamFilterName}, store.ReportSpam},
+		{[]string{linkFilterName}, store.ReportSpam},
+	}
+	for _, tt := range tests {
+		var r Result
+		for _, f := range tt.flags {
+			r.Flags = append(r.Flags, store.ContentFlag{Filter: f})
+		}
+		if got := r.ReportReason(); got != tt.want {
+			t.Errorf("%v: reason %q, want %q", tt.flags, got, tt.want)
+		}
+	}
+}
+
+func TestLoosened(t *testing.T) {
+	floor := store.DefaultContentFilterSettings()
+	tests := []struct {
+		set  func(*store.ContentFilterSettings)
+		want string
+	}{
+		{func(s *store.ContentFilterSettings) {}, ""},
+		{func(s *store.ContentFilterSettings) { s.Profanity = store.FilterReject }, ""},
+		{func(s *store.ContentFilterSettings) { s.Spam = store.FilterReject }, ""},
+		{func(s *store.ContentFilterSettings) { s.Links = store.FilterRewrite }, "links"},
+		{func(s *store.ContentFilterSettings) { s.Links = store.FilterOff }, "links"},
+		{func(s *store.ContentFilterSettings) { s.Spam = store.FilterOff }, "spam"},
+	}
+	for i, tt := range tests {
+		if got := loosened(settings(tt.set), floor); got != tt.want {
+			t.Errorf("case %d: loosened = %q, want %q", i, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package filters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Y3rnur/go-realtime-chat/backend"
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+const (
+	maxListEntries = 200
+	maxEntryLen    = 100
+)
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// SettingsHandler reads (GET, participants) and changes (PATCH) a conversation's content filters.
+// Route: /api/conversations/{id}/filters. PATCH accepts any of
+//
+//	{"profanity": "off|flag|rewrite|reject", "links": "...", "spam": "off|flag|reject",
+//	 "extra_words": ["..."], "blocked_domains": ["example.com"]}
+func SettingsHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPatch {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uid, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+		if err != nil {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+		convID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid conversation id", http.StatusBadRequest)
+			return
+		}
+		role, isGroup, err := store.GetParticipantRole(r.Context(), pool, convID, uid)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		settings, err := store.GetContentFilterSettings(r.Context(), pool, convID)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		if r.Method == http.MethodGet {
+			writeJSON(w, http.StatusOK, settings)
+			return
+		}
+
+		if !store.CanManageConversation(role, isGroup) {
+			http.Error(w, "only group owners and admins can change content filters", http.StatusForbidden)
+			return
+		}
+		if err := decodeSettings(r, &settings); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// in a direct chat either person could otherwise switch off what protects the other one
+		if name := loosened(settings, store.DefaultContentFilterSettings()); !isGroup && name != "" {
+			http.Error(w, name+" can't be set below the instance default in a direct conversation", http.StatusForbidden)
+			return
+		}
+		settings, err = store.SaveContentFilterSettings(r.Context(), pool, convID, uid, settings)
+		if err != nil {
+			log.Printf("filters: save settings conv=%s user=%s error: %v", convID, uid, err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, settings)
+	})
+}
+
+// decodeSettings applies a PATCH body to s, validating modes and lists.
+func decodeSettings(r *http.Request, s *store.ContentFilterSettings) error {
+	var req struct {
+		Profanity      *string   `json:"profanity"`
+		Links          *string   `json:"links"`
+		Spam           *string   `json:"spam"`
+		ExtraWords     *[]string `json:"extra_words"`
+		BlockedDomains *[]string `json:"blocked_domains"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return errors.New("invalid request")
+	}
+	modes := []struct {
+		name      string
+		in        *string
+		out       *string
+		rewriting bool
+	}{
+		{"profanity", req.Profanity, &s.Profanity, true},
+		{"links", req.Links, &s.Links, true},
+		{"spam", req.Spam, &s.Spam, false},
+	}
+	for _, m := range modes {
+		if m.in == nil {
+			continue
+		}
+		switch *m.in {
+		case store.FilterOff, store.FilterFlag, store.FilterReject:
+		case store.FilterRewrite:
+			if !m.rewriting {
+				return fmt.Errorf("%s can't be rewritten; use off, flag or reject", m.name)
+			}
+		default:
+			return fmt.Errorf("invalid %s mode %q", m.name, *m.in)
+		}
+		*m.out = *m.in
+	}
+	if req.ExtraWords != nil {
+		words, err := cleanList("extra_words", *req.ExtraWords)
+		if err != nil {
+			return err
+		}
+		s.ExtraWords = words
+	}
+	if req.BlockedDomains != nil {
+		domains, err := cleanList("blocked_domains", normalizeDomains(*req.BlockedDomains))
+		if err != nil {
+			return err
+		}
+		s.BlockedDomains = domains
+	}
+	return nil
+}
+
+// modeStrength orders the modes from off to reject.
+var modeStrength = map[string]int{
+	store.FilterOff:     0,
+	store.FilterFlag:    1,
+	store.FilterRewrite: 2,
+	store.FilterReject:  3,
+}
+
+// loosened returns the first filter whose mode in s is weaker than in floor, or "".
+func loosened(s, floor store.ContentFilterSettings) string {
+	for _, m := range []struct{ name, mode, min string }{
+		{"profanity", s.Profanity, floor.Profanity},
+		{"links", s.Links, floor.Links},
+		{"spam", s.Spam, floor.Spam},
+	} {
+		if modeStrength[m.mode] < modeStrength[m.min] {
+			return m.name
+		}
+	}
+	return ""
+}
+
+// cleanList lowercases, trims and de-duplicates a settings list and enforces its limits.
+func cleanList(name string, in []string) ([]string, error) {
+	out := []string{}
+	seen := map[string]bool{}
+	for _, v := range in {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v == "" || seen[v] {
+			continue
+		}
+		if utf8.RuneCountInString(v) > maxEntryLen {
+			return nil, fmt.Errorf("%s entries are limited to %d characters", name, maxEntryLen)
+		}
+		seen[v] = true
+		out = append(out, v)
+	}
+	if len(out) > maxListEntries {
+		return nil, fmt.Errorf("%s is limited to %d entries", name, maxListEntries)
+	}
+	return out, nil
+}
@@ -0,0 +1,102 @@
+package filters
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+const linkFilterName = "links"
+
+// links with a scheme or "www.", and bare host names ("evil.example/path") so a missing
+// scheme doesn't get around the blocklist
+var (
+	urlPattern      = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"']+`)
+	bareHostPattern = regexp.MustCompile(`(?i)\b(?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)+[a-z]{2,}(?:/[^\s<>"']*)?`)
+)
+
+// trailing punctuation that ends a sentence rather than the link
+const linkTrailer = ".,;:!?)]}'\""
+
+// LinkFilter matches links to blocked domains (and their subdomains); rewriting replaces
+// them with "[link removed]".
+type LinkFilter struct {
+	domains []string
+}
+
+func NewLinkFilter(domains []string) *LinkFilter {
+	return &LinkFilter{domains: normalizeDomains(domains)}
+}
+
+func (f *LinkFilter) Name() string { return linkFilterName }
+
+func (f *LinkFilter) Check(_ context.Context, m Message, s store.ContentFilterSettings) Verdict {
+	if s.Links == store.FilterOff || s.Links == "" {
+		return Verdict{Action: Allow}
+	}
+	blocked := append(normalizeDomains(s.BlockedDomains), f.domains...)
+	if len(blocked) == 0 {
+		return Verdict{Action: Allow}
+	}
+	var hit string
+	replace := func(link string) string {
+		trimmed := strings.TrimRight(link, linkTrailer)
+		host := linkHost(trimmed)
+		if host == "" || !domainBlocked(host, blocked) {
+			return link
+		}
+		if hit == "" {
+			hit = host
+		}
+		return "[link removed]" + link[len(trimmed):]
+	}
+	rewritten := urlPattern.ReplaceAllStringFunc(m.Body, replace)
+	rewritten = bareHostPattern.ReplaceAllStringFunc(rewritten, replace)
+	if hit == "" {
+		return Verdict{Action: Allow}
+	}
+	return verdict(s.Links, "links to "+hit+" are not allowed", rewritten)
+}
+
+// linkHost returns the lowercased host of a link with or without a scheme.
+func linkHost(link string) string {
+	if !strings.Contains(link, "://") {
+		link = "http://" + link
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+}
+
+func domainBlocked(host string, blocked []string) bool {
+	for _, d := range blocked {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeDomains lowercases entries and strips schemes, paths and a leading "*." or ".".
+func normalizeDomains(domains []string) []string {
+	out := make([]string, 0, len(domains))
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if i := strings.Index(d, "://"); i >= 0 {
+			d = d[i+3:]
+		}
+		if i := strings.IndexAny(d, "/?#"); i >= 0 {
+			d = d[:i]
+		}
+		d = strings.TrimPrefix(strings.TrimPrefix(d, "*"), ".")
+		if d != "" {
+			out = append(out, d)
+		}
+	}
+	return out
+}
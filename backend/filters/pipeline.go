@@ -0,0 +1,162 @@
+// Package filters runs outgoing messages through an ordered pipeline of content filters
+// (profanity, link blocklist, spam heuristics) configured per conversation.
+package filters
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+// Action is what a filter decided about a message.
+type Action int
+
+const (
+	Allow Action = iota
+	// Rewrite replaces the body with Verdict.Body
+	Rewrite
+	// Flag lets the message through but records it for moderators
+	Flag
+	// Reject refuses the message
+	Reject
+)
+
+// Message is an outgoing message as seen by the filters.
+type Message struct {
+	ConversationID uuid.UUID
+	AuthorID       uuid.UUID
+	Body           string
+}
+
+// Verdict is a filter's decision. Reason explains matches (shown to the sender on Reject).
+type Verdict struct {
+	Action Action
+	Reason string
+	Body   string
+}
+
+// Filter inspects one message. Filters run in pipeline order and see the body as rewritten by
+// the filters before them; settings are the conversation's.
+type Filter interface {
+	Name() string
+	Check(ctx context.Context, m Message, settings store.ContentFilterSettings) Verdict
+}
+
+// verdict maps a match to the configured mode; rewritten is used in FilterRewrite mode
+// (filters that can't rewrite pass "" and get a flag instead).
+func verdict(mode, reason, rewritten string) Verdict {
+	switch mode {
+	case store.FilterReject:
+		return Verdict{Action: Reject, Reason: reason}
+	case store.FilterRewrite:
+		if rewritten != "" {
+			return Verdict{Action: Rewrite, Reason: reason, Body: rewritten}
+		}
+		return Verdict{Action: Flag, Reason: reason}
+	case store.FilterFlag:
+		return Verdict{Action: Flag, Reason: reason}
+	}
+	return Verdict{Action: Allow}
+}
+
+// Rejection is returned by Pipeline.Run when a filter refused the message.
+type Rejection struct {
+	Filter string
+	Reason string
+}
+
+func (r *Rejection) Error() string {
+	return fmt.Sprintf("message rejected by %s filter: %s", r.Filter, r.Reason)
+}
+
+// Result is a message that passed the pipeline: its (possibly rewritten) body and any flags.
+type Result struct {
+	Body  string
+	Flags []store.ContentFlag
+}
+
+// ReportReason is the moderation report reason for a flagged message.
+func (r Result) ReportReason() string {
+	reason := store.ReportOther
+	for _, f := range r.Flags {
+		switch f.Filter {
+		case spamFilterName, linkFilterName:
+			return store.ReportSpam
+		case profanityFilterName:
+			reason = store.ReportInappropriate
+		}
+	}
+	return reason
+}
+
+// Pipeline is an ordered list of filters.
+type Pipeline struct {
+	filters []Filter
+}
+
+func NewPipeline(filters ...Filter) *Pipeline {
+	return &Pipeline{filters: filters}
+}
+
+// NewDefaultPipeline builds the built-in filters: profanity, link blocklist and spam heuristics.
+// CONTENT_FILTER_WORDS and CONTENT_BLOCKED_DOMAINS (comma separated) extend the instance-wide lists.
+func NewDefaultPipeline() *Pipeline {
+	return NewPipeline(
+		NewProfanityFilter(append(DefaultProfanity(), splitList(os.Getenv("CONTENT_FILTER_WORDS"))...)),
+		NewLinkFilter(splitList(os.Getenv("CONTENT_BLOCKED_DOMAINS"))),
+		NewSpamFilter(),
+	)
+}
+
+// Run passes m through the filters in order. It stops at the first rejection, returned as *Rejection.
+func (p *Pipeline) Run(ctx context.Context, m Message, settings store.ContentFilterSettings) (Result, error) {
+	res := Result{Body: m.Body}
+	for _, f := range p.filters {
+		m.Body = res.Body
+		v := f.Check(ctx, m, settings)
+		switch v.Action {
+		case Reject:
+			return res, &Rejection{Filter: f.Name(), Reason: v.Reason}
+		case Rewrite:
+			res.Body = v.Body
+		case Flag:
+			res.Flags = append(res.Flags, store.ContentFlag{Filter: f.Name(), Reason: v.Reason})
+		}
+	}
+	return res, nil
+}
+
+// Apply runs m through the pipeline with its conversation's settings. Every path that saves a
+// new message (REST, attachment captions, incoming webhooks, bot replies) goes through it.
+func (p *Pipeline) Apply(ctx context.Context, pool *pgxpool.Pool, m Message) (Result, error) {
+	settings, err := store.GetContentFilterSettings(ctx, pool, m.ConversationID)
+	if err != nil {
+		return Result{Body: m.Body}, err
+	}
+	return p.Run(ctx, m, settings)
+}
+
+// Flag records the result's flags on the saved message; a no-op when nothing was flagged.
+func (r Result) Flag(ctx context.Context, pool *pgxpool.Pool, m store.Message) error {
+	if len(r.Flags) == 0 {
+		return nil
+	}
+	return store.FlagMessage(ctx, pool, m, r.ReportReason(), r.Flags)
+}
+
+// splitList splits a comma separated list, dropping blanks and lowercasing the entries.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,91 @@
+package filters
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+const profanityFilterName = "profanity"
+
+// DefaultProfanity is the built-in word list. Matching is on whole words, case-insensitive,
+// with common letter substitutions (sh1t, @ss) and plural/verb endings.
+func DefaultProfanity() []string {
+	return []string{
+		"arse", "arsehole", "ass", "asshole", "bastard", "bitch", "bollocks", "bullshit",
+		"cock", "crap", "cunt", "dick", "dickhead", "douche", "fag", "faggot", "fuck",
+		"fucker", "motherfucker", "nigger", "piss", "prick", "pussy", "retard", "shit",
+		"slut", "twat", "wanker", "whore",
+	}
+}
+
+// words of a message body, including the symbols used as letter substitutes
+var wordPattern = regexp.MustCompile(`[\p{L}\p{N}@$]+`)
+
+// letter substitutions undone before matching
+var leet = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s")
+
+// endings tried when a word isn't on the list as is
+var wordSuffixes = []string{"s", "es", "ed", "er", "ers", "ing", "in", "y"}
+
+// ProfanityFilter matches words on a list; rewriting masks them ("f***").
+type ProfanityFilter struct {
+	words map[string]bool
+}
+
+func NewProfanityFilter(words []string) *ProfanityFilter {
+	f := &ProfanityFilter{words: map[string]bool{}}
+	for _, w := range words {
+		if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+			f.words[w] = true
+		}
+	}
+	return f
+}
+
+func (f *ProfanityFilter) Name() string { return profanityFilterName }
+
+func (f *ProfanityFilter) Check(_ context.Context, m Message, s store.ContentFilterSettings) Verdict {
+	if s.Profanity == store.FilterOff || s.Profanity == "" {
+		return Verdict{Action: Allow}
+	}
+	extra := map[string]bool{}
+	for _, w := range s.ExtraWords {
+		extra[strings.ToLower(w)] = true
+	}
+	var found []string
+	rewritten := wordPattern.ReplaceAllStringFunc(m.Body, func(word string) string {
+		if !f.matches(word, extra) {
+			return word
+		}
+		found = append(found, word)
+		return mask(word)
+	})
+	if len(found) == 0 {
+		return Verdict{Action: Allow}
+	}
+	return verdict(s.Profanity, "inappropriate language", rewritten)
+}
+
+func (f *ProfanityFilter) matches(word string, extra map[string]bool) bool {
+	w := leet.Replace(strings.ToLower(word))
+	listed := func(w string) bool { return f.words[w] || extra[w] }
+	if listed(w) {
+		return true
+	}
+	for _, suffix := range wordSuffixes {
+		if stem, ok := strings.CutSuffix(w, suffix); ok && len(stem) >= 3 && listed(stem) {
+			return true
+		}
+	}
+	return false
+}
+
+// mask keeps the first letter of a word and stars out the rest.
+func mask(word string) string {
+	r, size := utf8.DecodeRuneInString(word)
+	return string(r) + strings.Repeat("*", utf8.RuneCountInString(word[size:]))
+}
@@ -0,0 +1,137 @@
+package filters
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+const spamFilterName = "spam"
+
+// spam heuristics
+const (
+	maxLinks        = 5
+	maxMentions     = 10
+	maxRepeatedRune = 30
+	// shouting: at least this many letters, almost all of them upper case
+	minShoutLetters = 30
+	shoutRatio      = 0.9
+	// the same body from the same author in the same conversation within duplicateWindow,
+	// more than maxDuplicates times
+	duplicateWindow = time.Minute
+	maxDuplicates   = 2
+)
+
+// SpamFilter applies simple heuristics: too many links or mentions, long runs of one character,
+// shouting, and the same message sent over and over. It can flag or reject, not rewrite.
+// Recent messages are remembered per instance.
+type SpamFilter struct {
+	mu        sync.Mutex
+	recent    map[recentKey][]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type recentKey struct {
+	author, conv uuid.UUID
+	body         [sha256.Size]byte
+}
+
+func NewSpamFilter() *SpamFilter {
+	return &SpamFilter{recent: map[recentKey][]time.Time{}, now: time.Now}
+}
+
+func (f *SpamFilter) Name() string { return spamFilterName }
+
+func (f *SpamFilter) Check(_ context.Context, m Message, s store.ContentFilterSettings) Verdict {
+	if s.Spam == store.FilterOff || s.Spam == "" {
+		return Verdict{Action: Allow}
+	}
+	reason := spamHeuristics(m.Body)
+	if f.duplicate(m) && reason == "" {
+		reason = "the same message was sent repeatedly"
+	}
+	if reason == "" {
+		return Verdict{Action: Allow}
+	}
+	return verdict(s.Spam, reason, "")
+}
+
+// spamHeuristics returns why body looks like spam, or "".
+func spamHeuristics(body string) string {
+	if n := len(urlPattern.FindAllString(body, -1)); n > maxLinks {
+		return fmt.Sprintf("too many links (%d)", n)
+	}
+	if n := strings.Count(body, "<@") + countWordsWithPrefix(body, '@'); n > maxMentions {
+		return fmt.Sprintf("too many mentions (%d)", n)
+	}
+	var prev rune
+	run, letters, upper := 0, 0, 0
+	for _, r := range body {
+		if r == prev {
+			run++
+		} else {
+			prev, run = r, 1
+		}
+		if run >= maxRepeatedRune && !unicode.IsSpace(r) {
+			return "repeated characters"
+		}
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	if letters >= minShoutLetters && float64(upper) >= shoutRatio*float64(letters) {
+		return "excessive capital letters"
+	}
+	return ""
+}
+
+// countWordsWithPrefix counts words starting with prefix ("@name").
+func countWordsWithPrefix(body string, prefix rune) int {
+	n := 0
+	for _, w := range strings.Fields(body) {
+		if r := []rune(w); len(r) > 1 && r[0] == prefix {
+			n++
+		}
+	}
+	return n
+}
+
+// duplicate records m and reports whether the author already sent the same body to the
+// conversation more than maxDuplicates times within duplicateWindow.
+func (f *SpamFilter) duplicate(m Message) bool {
+	now := f.now()
+	key := recentKey{author: m.AuthorID, conv: m.ConversationID,
+		body: sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(m.Body))))}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// dropping expired entries once per window keeps the map bounded by recent traffic
+	if now.Sub(f.lastSweep) > duplicateWindow {
+		for k, times := range f.recent {
+			if now.Sub(times[len(times)-1]) > duplicateWindow {
+				delete(f.recent, k)
+			}
+		}
+		f.lastSweep = now
+	}
+	var kept []time.Time
+	for _, t := range f.recent[key] {
+		if now.Sub(t) <= duplicateWindow {
+			kept = append(kept, t)
+		}
+	}
+	f.recent[key] = append(kept, now)
+	return len(kept) >= maxDuplicates
+}
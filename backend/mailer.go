@@ -0,0 +1,86 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Mailer delivers plain-text emails (password resets etc.)
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// NewMailerFromEnv returns an SMTP mailer when SMTP_ADDR is set, otherwise a LogMailer.
+func NewMailerFromEnv() Mailer {
+	addr := os.Getenv("SMTP_ADDR")
+	if addr == "" {
+		return LogMailer{}
+	}
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = "no-reply@localhost"
+	}
+	m := &SMTPMailer{Addr: addr, From: from}
+	if user := os.Getenv("SMTP_USER"); user != "" {
+		host := addr
+		if i := strings.LastIndex(addr, ":"); i >= 0 {
+			host = addr[:i]
+		}
+		m.Auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	return m
+}
+
+// SMTPMailer sends mail through a plain SMTP relay.
+type SMTPMailer struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, to, subject, body string) error {
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", m.From, to, subject, body)
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{to}, []byte(msg))
+}
+
+// LogMailer only writes emails to the server log (local development).
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, to, subject, body string) error {
+	log.Printf("mail: to=%s subject=%q\n%s", to, subject, body)
+	return nil
+}
+
+// SentMail is a single message captured by MemoryMailer.
+type SentMail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// MemoryMailer keeps sent messages in memory (stand-in for tests).
+type MemoryMailer struct {
+	mu   sync.Mutex
+	sent []SentMail
+}
+
+func (m *MemoryMailer) Send(ctx context.Context, to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, SentMail{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+// Sent returns a copy of all captured messages.
+func (m *MemoryMailer) Sent() []SentMail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]SentMail, len(m.sent))
+	copy(out, m.sent)
+	return out
+}
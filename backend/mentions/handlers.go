@@ -0,0 +1,107 @@
+package mentions
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Y3rnur/go-realtime-chat/backend"
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+const maxLimit = 100
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// Handler serves the caller's mention inbox, newest first:
+// GET /api/mentions?unread=true&limit=&cursor=
+func Handler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uid, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+		if err != nil {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+		qs := r.URL.Query()
+		limit := 50
+		if v := qs.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, maxLimit)
+		}
+		var beforeTime *time.Time
+		var beforeID *uuid.UUID
+		if v := qs.Get("cursor"); v != "" {
+			t, id, ok := backend.DecodeCursor(v)
+			if !ok {
+				http.Error(w, "invalid cursor", http.StatusBadRequest)
+				return
+			}
+			beforeTime, beforeID = &t, &id
+		}
+		unread := qs.Get("unread") == "true" || qs.Get("unread") == "1"
+
+		items, err := store.GetMentions(r.Context(), pool, uid, unread, beforeTime, beforeID, limit)
+		if err != nil {
+			log.Printf("mentions: list user=%s error: %v", uid, err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		res := map[string]any{"mentions": items}
+		if len(items) == limit {
+			last := items[len(items)-1]
+			res["next_cursor"] = backend.EncodeCursor(last.CreatedAt, last.Message.ID)
+		}
+		writeJSON(w, http.StatusOK, res)
+	})
+}
+
+// ReadHandler marks mentions as read: POST /api/mentions/read {"message_ids": [...]}
+// (all unread mentions when message_ids is empty or missing).
+func ReadHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uid, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+		if err != nil {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			MessageIDs []uuid.UUID `json:"message_ids"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "invalid request", http.StatusBadRequest)
+				return
+			}
+		}
+		if req.MessageIDs == nil {
+			req.MessageIDs = []uuid.UUID{}
+		}
+		n, err := store.MarkMentionsRead(r.Context(), pool, uid, req.MessageIDs)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"marked": n})
+	})
+}
@@ -0,0 +1,119 @@
+package mentions
+
+import (
+	"context"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+// EventMentioned is delivered to every mentioned user, wherever they are connected.
+const EventMentioned = "mentioned"
+
+// Publisher is the part of the hub the notifier needs.
+type Publisher interface {
+	PublishUserEvent(userID string, v interface{}) error
+	// OnlineUsers lists the users currently present in a conversation (for @here).
+	OnlineUsers(ctx context.Context, convID string) ([]string, error)
+}
+
+// Notifier stores the mentions of new messages and notifies the mentioned users.
+type Notifier struct {
+	pool      *pgxpool.Pool
+	publisher Publisher
+}
+
+func NewNotifier(pool *pgxpool.Pool, publisher Publisher) *Notifier {
+	return &Notifier{pool: pool, publisher: publisher}
+}
+
+// MessageCreated processes the mentions of a saved message asynchronously.
+func (n *Notifier) MessageCreated(m store.Message) {
+	// system messages quote user input (group titles) and must not ping anyone
+	if m.Body == nil || *m.Body == "" || m.MessageType == store.MessageTypeSystem {
+		return
+	}
+	go func() {
+		if err := n.process(context.Background(), m); err != nil {
+			log.Printf("mentions: message=%s error: %v", m.ID, err)
+		}
+	}()
+}
+
+func (n *Notifier) process(ctx context.Context, m store.Message) error {
+	participants, isGroup, err := store.GetParticipants(ctx, n.pool, m.ConversationID)
+	if err != nil {
+		return err
+	}
+	p := parse(*m.Body, participants, isGroup)
+	if len(p.users) == 0 && !p.here && !p.all {
+		return nil
+	}
+
+	// explicit mentions win over @here/@all for the stored kind
+	kinds := map[uuid.UUID]string{}
+	if p.all {
+		for _, pt := range participants {
+			if pt.Role != store.RoleBot {
+				kinds[pt.UserID] = store.MentionAll
+			}
+		}
+	} else if p.here {
+		online, err := n.publisher.OnlineUsers(ctx, m.ConversationID.String())
+		if err != nil {
+			log.Printf("mentions: online users conv=%s error: %v", m.ConversationID, err)
+		}
+		present := map[string]bool{}
+		for _, id := range online {
+			present[id] = true
+		}
+		for _, pt := range participants {
+			if pt.Role != store.RoleBot && present[pt.UserID.String()] {
+				kinds[pt.UserID] = store.MentionHere
+			}
+		}
+	}
+	for id := range p.users {
+		kinds[id] = store.MentionUser
+	}
+	// authors don't mention themselves
+	delete(kinds, m.AuthorID)
+	// nor anyone who blocked them
+	blockers, err := store.GetBlockerIDs(ctx, n.pool, m.AuthorID)
+	if err != nil {
+		return err
+	}
+	for _, id := range blockers {
+		delete(kinds, id)
+	}
+	if len(kinds) == 0 {
+		return nil
+	}
+
+	if err := store.SaveMentions(ctx, n.pool, m, kinds); err != nil {
+		return err
+	}
+	// muted conversations still fill the inbox but don't notify
+	muted := map[uuid.UUID]bool{}
+	for _, pt := range participants {
+		muted[pt.UserID] = pt.Muted
+	}
+	for id, kind := range kinds {
+		if muted[id] {
+			continue
+		}
+		payload := map[string]any{
+			"type":            EventMentioned,
+			"conversation_id": m.ConversationID,
+			"kind":            kind,
+			"message":         m,
+		}
+		if err := n.publisher.PublishUserEvent(id.String(), payload); err != nil {
+			log.Printf("mentions: publish to user=%s error: %v", id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package mentions
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+// @name (letters, digits, '.', '_', '-') or the explicit <@user-id> form
+var mentionRe = regexp.MustCompile(`<@([0-9a-fA-F-]{36})>|(?:^|[^\w@])@([\p{L}\p{N}._-]+)`)
+
+// parsed mentions of one message body
+type parsed struct {
+	users map[uuid.UUID]bool
+	here  bool
+	all   bool
+}
+
+// handle normalizes a display name for matching: lower case without whitespace.
+func handle(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), ""))
+}
+
+// parse resolves the mentions of body against the conversation's participants. A
+// @name matches a participant whose display name (without spaces) or first name
+// equals it, ignoring case; ambiguous first names and non-participants are dropped.
+// @here/@all are only recognized in group conversations.
+func parse(body string, participants []store.Participant, isGroup bool) parsed {
+	p := parsed{users: map[uuid.UUID]bool{}}
+
+	members := map[uuid.UUID]bool{}
+	full := map[string]uuid.UUID{}
+	first := map[string][]uuid.UUID{}
+	for _, pt := range participants {
+		members[pt.UserID] = true
+		if pt.DisplayName == nil {
+			continue
+		}
+		if h := handle(*pt.DisplayName); h != "" {
+			full[h] = pt.UserID
+		}
+		if f := strings.Fields(*pt.DisplayName); len(f) > 0 {
+			k := strings.ToLower(f[0])
+			first[k] = append(first[k], pt.UserID)
+		}
+	}
+
+	for _, m := range mentionRe.FindAllStringSubmatch(body, -1) {
+		if m[1] != "" {
+			if id, err := uuid.Parse(m[1]); err == nil && members[id] {
+				p.users[id] = true
+			}
+			continue
+		}
+		// trailing punctuation ("@ann.") is not part of the name
+		name := strings.ToLower(strings.TrimRight(m[2], ".-_"))
+		switch {
+		case name == "here" && isGroup:
+			p.here = true
+		case name == "all" && isGroup:
+			p.all = true
+		default:
+			if id, ok := full[name]; ok {
+				p.users[id] = true
+			} else if ids := first[name]; len(ids) == 1 {
+				p.users[ids[0]] = true
+			}
+		}
+	}
+	return p
+}
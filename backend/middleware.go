@@ -3,11 +3,29 @@ package backend
 import (
 	"log"
 	"net/http"
+	"strings"
 )
 
+// secretPathPrefixes are routes whose next path segment is a credential (incoming webhook
+// tokens); it is left out of the access log.
+var secretPathPrefixes = []string{"/api/hooks/"}
+
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("%s %s %s", r.RemoteAddr, r.Method, r.URL.Path)
+		log.Printf("%s %s %s", r.RemoteAddr, r.Method, redactPath(r.URL.Path))
 		next.ServeHTTP(w, r)
 	})
 }
+
+// redactPath replaces the credential segment of secretPathPrefixes routes.
+func redactPath(path string) string {
+	for _, prefix := range secretPathPrefixes {
+		if rest, ok := strings.CutPrefix(path, prefix); ok {
+			if i := strings.IndexByte(rest, '/'); i >= 0 {
+				return prefix + "[redacted]" + rest[i:]
+			}
+			return prefix + "[redacted]"
+		}
+	}
+	return path
+}
@@ -0,0 +1,34 @@
+package backend
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLoggingMiddlewareRedactsWebhookTokens(t *testing.T) {
+	var buf bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&buf)
+
+	h := LoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/hooks/s3cret-token", nil))
+	if strings.Contains(buf.String(), "s3cret-token") || !strings.Contains(buf.String(), "/api/hooks/[redacted]") {
+		t.Fatalf("logged %q", buf.String())
+	}
+
+	tests := map[string]string{
+		"/api/hooks/":                "/api/hooks/[redacted]",
+		"/api/hooks/tok/extra":       "/api/hooks/[redacted]/extra",
+		"/api/hooksmith/tok":         "/api/hooksmith/tok",
+		"/api/conversations/x/hooks": "/api/conversations/x/hooks",
+	}
+	for in, want := range tests {
+		if got := redactPath(in); got != want {
+			t.Errorf("redactPath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package moderation
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Y3rnur/go-realtime-chat/backend"
+	"github.com/Y3rnur/go-realtime-chat/backend/attachments"
+	"github.com/Y3rnur/go-realtime-chat/backend/storage"
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+// EventMessageDeleted is broadcast to a conversation when a moderator removes one of its messages.
+const EventMessageDeleted = "message_deleted"
+
+const (
+	maxLimit      = 100
+	maxDetailsLen = 1000
+	maxNoteLen    = 1000
+)
+
+// Publisher is the part of the hub the moderation handlers need.
+type Publisher interface {
+	PublishEvent(convID string, v interface{}) error
+	DisconnectUser(userID, reason string)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// pageParams parses ?limit= and ?cursor= of the moderation lists.
+func pageParams(w http.ResponseWriter, r *http.Request) (limit int, beforeTime *time.Time, beforeID *uuid.UUID, ok bool) {
+	qs := r.URL.Query()
+	limit = 50
+	if v := qs.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return 0, nil, nil, false
+		}
+		limit = min(n, maxLimit)
+	}
+	if v := qs.Get("cursor"); v != "" {
+		t, id, valid := backend.DecodeCursor(v)
+		if !valid {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return 0, nil, nil, false
+		}
+		beforeTime, beforeID = &t, &id
+	}
+	return limit, beforeTime, beforeID, true
+}
+
+// trimmedOrNil trims s and returns nil when nothing is left.
+func trimmedOrNil(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	t := strings.TrimSpace(*s)
+	if t == "" {
+		return nil
+	}
+	return &t
+}
+
+// ReportHandler files an abuse report about a message or a user:
+// POST /api/reports {"message_id" | "user_id", "reason", "details"}
+func ReportHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uid, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+		if err != nil {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			MessageID *uuid.UUID `json:"message_id"`
+			UserID    *uuid.UUID `json:"user_id"`
+			Reason    string     `json:"reason"`
+			Details   *string    `json:"details"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		if (req.MessageID == nil) == (req.UserID == nil) {
+			http.Error(w, "exactly one of message_id or user_id required", http.StatusBadRequest)
+			return
+		}
+		if !store.IsReportReason(req.Reason) {
+			http.Error(w, "reason must be one of spam, harassment, hate, inappropriate, other", http.StatusBadRequest)
+			return
+		}
+		details := trimmedOrNil(req.Details)
+		if details != nil && utf8.RuneCountInString(*details) > maxDetailsLen {
+			http.Error(w, "details too long", http.StatusBadRequest)
+			return
+		}
+
+		rep, err := store.CreateReport(r.Context(), pool, store.NewReport{
+			ReporterID: uid,
+			MessageID:  req.MessageID,
+			UserID:     req.UserID,
+			Reason:     req.Reason,
+			Details:    details,
+		})
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		case errors.Is(err, store.ErrSelfReport):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, store.ErrDuplicateReport):
+			http.Error(w, "you already reported this", http.StatusConflict)
+			return
+		case err != nil:
+			log.Printf("moderation: report by %s error: %v", uid, err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("moderation: report %s filed by %s (%s)", rep.ID, uid, rep.Reason)
+		// the reporter only gets an acknowledgement, not the moderation view of the report
+		writeJSON(w, http.StatusCreated, map[string]any{
+			"id":         rep.ID,
+			"status":     rep.Status,
+			"created_at": rep.CreatedAt,
+		})
+	})
+}
+
+// QueueHandler is the admins' moderation queue, newest first (behind backend.RequireAdmin):
+// GET /api/moderation/reports?status=open|resolved|dismissed|all&limit=&cursor=
+func QueueHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		status := r.URL.Query().Get("status")
+		switch status {
+		case "":
+			status = store.ReportOpen
+		case "all":
+			status = ""
+		case store.ReportOpen, store.ReportResolved, store.ReportDismissed:
+		default:
+			http.Error(w, "invalid status", http.StatusBadRequest)
+			return
+		}
+		limit, beforeTime, beforeID, ok := pageParams(w, r)
+		if !ok {
+			return
+		}
+		reports, err := store.GetReports(r.Context(), pool, status, beforeTime, beforeID, limit)
+		if err != nil {
+			log.Printf("moderation: list reports error: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		res := map[string]any{"reports": reports}
+		if len(reports) == limit {
+			last := reports[len(reports)-1]
+			res["next_cursor"] = backend.EncodeCursor(last.CreatedAt, last.ID)
+		}
+		writeJSON(w, http.StatusOK, res)
+	})
+}
+
+// ActionHandler applies a moderator action to an open report (behind backend.RequireAdmin):
+// POST /api/moderation/reports/{id}/actions {"action": "delete_message|suspend_user|dismiss", "note"}
+// Deleting a message also deletes its attachments from st.
+func ActionHandler(pool *pgxpool.Pool, st storage.Storage, publisher Publisher) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uid, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+		if err != nil {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+		reportID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid report id", http.StatusBadRequest)
+			return
+		}
+		var req struct {
+			Action string  `json:"action"`
+			Note   *string `json:"note"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		switch req.Action {
+		case store.ActionDeleteMessage, store.ActionSuspendUser, store.ActionDismiss:
+		default:
+			http.Error(w, "action must be one of delete_message, suspend_user, dismiss", http.StatusBadRequest)
+			return
+		}
+		note := trimmedOrNil(req.Note)
+		if note != nil && utf8.RuneCountInString(*note) > maxNoteLen {
+			http.Error(w, "note too long", http.StatusBadRequest)
+			return
+		}
+
+		res, err := store.ApplyModerationAction(r.Context(), pool, reportID, uid, req.Action, note)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			http.Error(w, "report not found", http.StatusNotFound)
+			return
+		case errors.Is(err, store.ErrReportClosed):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, store.ErrActionNotApplicable):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		case err != nil:
+			log.Printf("moderation: %s on report %s by %s error: %v", req.Action, reportID, uid, err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("moderation: %s on report %s by %s (%d report(s) closed)", req.Action, reportID, uid, res.ClosedReports)
+
+		switch req.Action {
+		case store.ActionDeleteMessage:
+			attachments.DeleteBlobs(r.Context(), st, res.Attachments)
+			if m := res.Message; m != nil {
+				payload := map[string]any{
+					"type":            EventMessageDeleted,
+					"conversation_id": m.ConversationID,
+					"message_id":      m.ID,
+				}
+				if err := publisher.PublishEvent(m.ConversationID.String(), payload); err != nil {
+					log.Printf("moderation: publish %s error: %v", EventMessageDeleted, err)
+				}
+			}
+		case store.ActionSuspendUser:
+			if res.Report.UserID != nil {
+				publisher.DisconnectUser(res.Report.UserID.String(), "suspended")
+			}
+		}
+		writeJSON(w, http.StatusOK, res)
+	})
+}
+
+// AuditHandler lists the moderator and admin actions, newest first (behind backend.RequireAdmin): GET /api/moderation/actions?limit=&cursor=
+func AuditHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		limit, beforeTime, beforeID, ok := pageParams(w, r)
+		if !ok {
+			return
+		}
+		actions, err := store.GetModerationActions(r.Context(), pool, beforeTime, beforeID, limit)
+		if err != nil {
+			log.Printf("moderation: list actions error: %v", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		res := map[string]any{"actions": actions}
+		if len(actions) == limit {
+			last := actions[len(actions)-1]
+			res["next_cursor"] = backend.EncodeCursor(last.CreatedAt, last.ID)
+		}
+		writeJSON(w, http.StatusOK, res)
+	})
+}
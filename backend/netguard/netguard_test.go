@@ -0,0 +1,48 @@
+package netguard
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+		{"::1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:93.184.216.34", true},
+	}
+	for _, tt := range tests {
+		if got := PublicAddr(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("PublicAddr(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestControl(t *testing.T) {
+	control := Control(PublicAddr)
+	if err := control("tcp4", "93.184.216.34:443", nil); err != nil {
+		t.Errorf("public address: %v", err)
+	}
+	if err := control("tcp4", "127.0.0.1:80", nil); !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("loopback: err = %v", err)
+	}
+	if err := control("tcp6", "[::1]:80", nil); !errors.Is(err, ErrBlockedAddress) {
+		t.Errorf("ipv6 loopback: err = %v", err)
+	}
+}
@@ -0,0 +1,531 @@
+package backend
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// lifetime of the state cookie between redirect to the IdP and the callback
+const oidcStateTTL = 10 * time.Minute
+
+const oidcStateCookie = "oidc_state"
+
+const tokenTypeOIDCState = "oidc_state"
+
+// OIDCProvider holds the discovered endpoints and client settings of an OpenID Connect identity provider.
+type OIDCProvider struct {
+	// Issuer is exactly as the discovery document has it; ID tokens must carry the same "iss".
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	// HTTPClient is used for discovery, token exchange and JWKS (defaults to a client with timeout).
+	HTTPClient *http.Client
+	// ValidAvatarURL checks the "picture" claim before it becomes a new account's avatar (main
+	// wires attachments.ValidAvatarURL); pictures failing it, or all of them when unset, are dropped.
+	ValidAvatarURL func(string) bool
+
+	authEndpoint  string
+	tokenEndpoint string
+	jwksURI       string
+
+	mu   sync.RWMutex
+	keys map[string]any
+}
+
+// NewOIDCProviderFromEnv configures SSO from OIDC_* variables; it returns nil (and no error) when OIDC_ISSUER is unset.
+func NewOIDCProviderFromEnv(ctx context.Context) (*OIDCProvider, error) {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, nil
+	}
+	redirect := os.Getenv("OIDC_REDIRECT_URL")
+	if redirect == "" {
+		redirect = AppBaseURL() + "/api/oidc/callback"
+	}
+	return NewOIDCProvider(ctx, issuer, os.Getenv("OIDC_CLIENT_ID"), os.Getenv("OIDC_CLIENT_SECRET"), redirect, nil)
+}
+
+// NewOIDCProvider runs discovery against issuer (/.well-known/openid-configuration).
+func NewOIDCProvider(ctx context.Context, issuer, clientID, clientSecret, redirectURL string, client *http.Client) (*OIDCProvider, error) {
+	if clientID == "" {
+		return nil, errors.New("oidc: client id required")
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	p := &OIDCProvider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+		HTTPClient:   client,
+		keys:         map[string]any{},
+	}
+
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	// a configured trailing slash only matters for building the discovery URL
+	base := strings.TrimRight(issuer, "/")
+	if err := p.getJSON(ctx, base+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, fmt.Errorf("oidc: discovery: %w", err)
+	}
+	if strings.TrimRight(doc.Issuer, "/") != base {
+		return nil, fmt.Errorf("oidc: issuer mismatch: got %q want %q", doc.Issuer, issuer)
+	}
+	p.Issuer = doc.Issuer
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("oidc: incomplete discovery document")
+	}
+	p.authEndpoint = doc.AuthorizationEndpoint
+	p.tokenEndpoint = doc.TokenEndpoint
+	p.jwksURI = doc.JWKSURI
+	return p, nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	res, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", u, res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// AuthCodeURL returns the IdP authorization URL for the given state, nonce and PKCE challenge.
+func (p *OIDCProvider) AuthCodeURL(state, nonce, codeChallenge string) string {
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.ClientID)
+	v.Set("redirect_uri", p.RedirectURL)
+	v.Set("scope", strings.Join(p.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", codeChallenge)
+	v.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(p.authEndpoint, "?") {
+		sep = "&"
+	}
+	return p.authEndpoint + sep + v.Encode()
+}
+
+// Exchange trades the authorization code (+ PKCE verifier) for the raw id_token.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("client_id", p.ClientID)
+	form.Set("code_verifier", codeVerifier)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+	res, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("oidc: token response: %w", err)
+	}
+	if res.StatusCode != http.StatusOK || body.Error != "" {
+		return "", fmt.Errorf("oidc: token exchange failed (status %d): %s %s", res.StatusCode, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("oidc: token response without id_token")
+	}
+	return body.IDToken, nil
+}
+
+// OIDCClaims is the subset of id_token claims used for provisioning.
+type OIDCClaims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Picture       string
+}
+
+// VerifyIDToken checks signature (JWKS), issuer, audience, expiry and nonce of an id_token.
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, raw, nonce string) (OIDCClaims, error) {
+	var out OIDCClaims
+	t, err := jwt.Parse(raw, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "ES256", "ES384"}),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return out, fmt.Errorf("oidc: id_token: %w", err)
+	}
+	claims, ok := t.Claims.(jwt.MapClaims)
+	if !ok {
+		return out, errors.New("oidc: invalid claims")
+	}
+	if n, _ := claims["nonce"].(string); n == "" || n != nonce {
+		return out, errors.New("oidc: nonce mismatch")
+	}
+	out.Subject, _ = claims["sub"].(string)
+	out.Email, _ = claims["email"].(string)
+	out.Name, _ = claims["name"].(string)
+	out.Picture, _ = claims["picture"].(string)
+	// some providers send email_verified as a string
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		out.EmailVerified = v
+	case string:
+		out.EmailVerified = v == "true"
+	}
+	if out.Subject == "" {
+		return out, errors.New("oidc: missing sub")
+	}
+	return out, nil
+}
+
+// key returns the verification key for kid, refetching the JWKS once when it's unknown (key rotation).
+func (p *OIDCProvider) key(ctx context.Context, kid string) (any, error) {
+	if k := p.cachedKey(kid); k != nil {
+		return k, nil
+	}
+	if err := p.fetchKeys(ctx); err != nil {
+		return nil, err
+	}
+	if k := p.cachedKey(kid); k != nil {
+		return k, nil
+	}
+	return nil, fmt.Errorf("oidc: unknown key id %q", kid)
+}
+
+func (p *OIDCProvider) cachedKey(kid string) any {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k
+		}
+	}
+	return p.keys[kid]
+}
+
+func (p *OIDCProvider) fetchKeys(ctx context.Context) error {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.jwksURI, &set); err != nil {
+		return fmt.Errorf("oidc: jwks: %w", err)
+	}
+	keys := map[string]any{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err1 := base64.RawURLEncoding.DecodeString(k.N)
+			e, err2 := base64.RawURLEncoding.DecodeString(k.E)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			default:
+				continue
+			}
+			x, err1 := base64.RawURLEncoding.DecodeString(k.X)
+			y, err2 := base64.RawURLEncoding.DecodeString(k.Y)
+			if err1 != nil || err2 != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	return nil
+}
+
+// pkceChallenge derives the S256 code challenge from a verifier.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// signOIDCState packs state/nonce/verifier into a signed, short-lived value kept in a cookie.
+func signOIDCState(state, nonce, verifier string) (string, error) {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		return "", errors.New("JWT_SECRET not configured")
+	}
+	now := time.Now().UTC()
+	claims := jwt.MapClaims{
+		"typ":      tokenTypeOIDCState,
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"iat":      now.Unix(),
+		"exp":      now.Add(oidcStateTTL).Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+}
+
+func parseOIDCState(tok string) (state, nonce, verifier string, err error) {
+	claims, err := parseToken(tok)
+	if err != nil {
+		return "", "", "", err
+	}
+	if typ, _ := claims["typ"].(string); typ != tokenTypeOIDCState {
+		return "", "", "", errors.New("not an oidc state token")
+	}
+	state, _ = claims["state"].(string)
+	nonce, _ = claims["nonce"].(string)
+	verifier, _ = claims["verifier"].(string)
+	if state == "" || nonce == "" || verifier == "" {
+		return "", "", "", errors.New("incomplete oidc state")
+	}
+	return state, nonce, verifier, nil
+}
+
+// linkOIDCUser finds the user for an identity, links an existing account by verified email or provisions a new one.
+func linkOIDCUser(ctx context.Context, pool *pgxpool.Pool, issuer string, c OIDCClaims) (string, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var userID string
+	err = tx.QueryRow(ctx, `
+		SELECT user_id::text FROM user_identities WHERE issuer = $1 AND subject = $2
+	`, issuer, c.Subject).Scan(&userID)
+	if err == nil {
+		if _, err := tx.Exec(ctx, `UPDATE user_identities SET last_login_at = now(), email = $1 WHERE issuer = $2 AND subject = $3`, c.Email, issuer, c.Subject); err != nil {
+			return "", err
+		}
+		return userID, tx.Commit(ctx)
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return "", err
+	}
+
+	// new identity: only a verified email may be linked or provisioned
+	if c.Email == "" || !c.EmailVerified {
+		return "", errors.New("oidc: identity has no verified email")
+	}
+
+	err = tx.QueryRow(ctx, `SELECT id::text FROM users WHERE lower(email) = lower($1)`, c.Email).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// provisioning a new account; the random password can't be used for password login
+		pw, err := generateRandomToken(32)
+		if err != nil {
+			return "", err
+		}
+		pwHash, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
+		if err != nil {
+			return "", err
+		}
+		display := c.Name
+		if display == "" {
+			display = strings.SplitN(c.Email, "@", 2)[0]
+		}
+		var avatar *string
+		if c.Picture != "" {
+			avatar = &c.Picture
+		}
+		err = tx.QueryRow(ctx, `
+			INSERT INTO users (email, password_hash, display_name, avatar_url, is_verified)
+			VALUES ($1, $2, $3, $4, TRUE)
+			RETURNING id::text
+		`, c.Email, string(pwHash), display, avatar).Scan(&userID)
+		if err != nil {
+			return "", err
+		}
+		log.Printf("oidc: provisioned user %s for %s", userID, c.Email)
+	} else if err != nil {
+		return "", err
+	} else {
+		log.Printf("oidc: linked identity %s/%s to existing user %s", issuer, c.Subject, userID)
+	}
+
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO user_identities (user_id, issuer, subject, email, last_login_at)
+		VALUES ($1, $2, $3, $4, now())
+	`, userID, issuer, c.Subject, c.Email); err != nil {
+		return "", err
+	}
+	return userID, tx.Commit(ctx)
+}
+
+// OIDCLoginHandler starts the authorization-code + PKCE flow by redirecting to the IdP.
+func OIDCLoginHandler(p *OIDCProvider) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state, err1 := generateRandomToken(16)
+		nonce, err2 := generateRandomToken(16)
+		verifier, err3 := generateRandomToken(32)
+		if err1 != nil || err2 != nil || err3 != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		signed, err := signOIDCState(state, nonce, verifier)
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     oidcStateCookie,
+			Value:    signed,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+			Path:     "/api/oidc",
+			Expires:  time.Now().Add(oidcStateTTL),
+		})
+		http.Redirect(w, r, p.AuthCodeURL(state, nonce, pkceChallenge(verifier)), http.StatusFound)
+	})
+}
+
+// OIDCCallbackHandler completes the flow, links/provisions the user and issues the usual session cookies.
+func OIDCCallbackHandler(pool *pgxpool.Pool, p *OIDCProvider) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if e := q.Get("error"); e != "" {
+			log.Printf("oidc: idp returned error %q: %s", e, q.Get("error_description"))
+			http.Error(w, "sso login failed", http.StatusUnauthorized)
+			return
+		}
+		c, err := r.Cookie(oidcStateCookie)
+		if err != nil || c.Value == "" {
+			http.Error(w, "missing sso state", http.StatusBadRequest)
+			return
+		}
+		// the state cookie is single-use
+		http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: "", Path: "/api/oidc", MaxAge: -1, HttpOnly: true})
+
+		state, nonce, verifier, err := parseOIDCState(c.Value)
+		if err != nil || q.Get("state") != state {
+			http.Error(w, "invalid sso state", http.StatusBadRequest)
+			return
+		}
+		code := q.Get("code")
+		if code == "" {
+			http.Error(w, "code required", http.StatusBadRequest)
+			return
+		}
+
+		rawID, err := p.Exchange(r.Context(), code, verifier)
+		if err != nil {
+			log.Printf("oidc: exchange error: %v", err)
+			http.Error(w, "sso login failed", http.StatusUnauthorized)
+			return
+		}
+		claims, err := p.VerifyIDToken(r.Context(), rawID, nonce)
+		if err != nil {
+			log.Printf("oidc: verify error: %v", err)
+			http.Error(w, "sso login failed", http.StatusUnauthorized)
+			return
+		}
+		if claims.Picture != "" && (p.ValidAvatarURL == nil || !p.ValidAvatarURL(claims.Picture)) {
+			claims.Picture = ""
+		}
+		userID, err := linkOIDCUser(r.Context(), pool, p.Issuer, claims)
+		if err != nil {
+			log.Printf("oidc: link user error sub=%s: %v", claims.Subject, err)
+			http.Error(w, "sso login failed", http.StatusUnauthorized)
+			return
+		}
+
+		var email string
+		var display sql.NullString
+		var totpEnabled, active bool
+		if err := pool.QueryRow(r.Context(), `SELECT email, display_name, totp_enabled, is_active FROM users WHERE id = $1`, userID).Scan(&email, &display, &totpEnabled, &active); err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		if !active {
+			log.Printf("oidc: rejected suspended user %s", userID)
+			http.Error(w, ErrAccountSuspended.Error(), http.StatusForbidden)
+			return
+		}
+		if totpEnabled {
+			// the local second factor still applies; the frontend completes it via /api/login/2fa
+			challenge, err := generateChallengeToken(r.Context(), pool, userID)
+			if err != nil {
+				http.Error(w, "server error", http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, "/?two_factor_challenge="+url.QueryEscape(challenge), http.StatusFound)
+			return
+		}
+		if _, err := setSessionCookies(w, r, pool, userID, email, display.String); err != nil {
+			log.Printf("oidc: issue session error user=%s: %v", userID, err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusFound)
+	})
+}
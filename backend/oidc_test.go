@@ -0,0 +1,303 @@
+package backend
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Y3rnur/go-realtime-chat/backend/testdb"
+)
+
+// mockIdP is an OpenID provider serving discovery, JWKS and a token endpoint that checks PKCE.
+// Authorization is done by the test calling authorize with the login redirect.
+type mockIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+	// issuer is the discovered issuer and the tokens' "iss" (the server URL unless set)
+	issuer string
+
+	mu    sync.Mutex
+	codes map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &mockIdP{key: key, codes: map[string]mockGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.issuer,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		idp.mu.Lock()
+		grant, ok := idp.codes[r.PostFormValue("code")]
+		delete(idp.codes, r.PostFormValue("code"))
+		idp.mu.Unlock()
+		if !ok || r.PostFormValue("client_id") != "chat" || pkceChallenge(r.PostFormValue("code_verifier")) != grant.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		tok := jwt.NewWithClaims(jwt.SigningMethodRS256, grant.claims)
+		tok.Header["kid"] = "k1"
+		signed, err := tok.SignedString(key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+	})
+	idp.Server = httptest.NewServer(mux)
+	idp.issuer = idp.URL
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+// authorize plays the user approving the login redirect and returns the callback query. The
+// id_token carries the request's nonce unless claims sets one.
+func (idp *mockIdP) authorize(t *testing.T, redirect string, claims jwt.MapClaims) url.Values {
+	t.Helper()
+	u, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("authorization request without PKCE: %s", redirect)
+	}
+	all := jwt.MapClaims{
+		"iss":   idp.issuer,
+		"aud":   "chat",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+		"nonce": q.Get("nonce"),
+	}
+	for k, v := range claims {
+		all[k] = v
+	}
+	idp.mu.Lock()
+	code := "code-" + strconv.Itoa(len(idp.codes)) + "-" + q.Get("state")
+	idp.codes[code] = mockGrant{challenge: q.Get("code_challenge"), claims: all}
+	idp.mu.Unlock()
+	return url.Values{"code": {code}, "state": {q.Get("state")}}
+}
+
+func newTestProvider(t *testing.T, idp *mockIdP) *OIDCProvider {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "test-secret")
+	p, err := NewOIDCProvider(context.Background(), idp.URL, "chat", "", "http://chat.test/api/oidc/callback", idp.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// startLogin runs the login handler and returns the state cookie and the IdP redirect.
+func startLogin(t *testing.T, p *OIDCProvider) (*http.Cookie, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	OIDCLoginHandler(p).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login: status %d", rec.Code)
+	}
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == oidcStateCookie {
+			return c, rec.Header().Get("Location")
+		}
+	}
+	t.Fatal("login: no state cookie")
+	return nil, ""
+}
+
+func callback(pool *pgxpool.Pool, p *OIDCProvider, cookie *http.Cookie, q url.Values) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/oidc/callback?"+q.Encode(), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	OIDCCallbackHandler(pool, p).ServeHTTP(rec, req)
+	return rec
+}
+
+// The checks below happen before the database is used.
+
+func TestOIDCIssuerWithTrailingSlash(t *testing.T) {
+	idp := newMockIdP(t)
+	idp.issuer = idp.URL + "/"
+	p := newTestProvider(t, idp)
+	if p.Issuer != idp.issuer {
+		t.Fatalf("issuer %q, want %q as discovered", p.Issuer, idp.issuer)
+	}
+	sign := func(iss string) string {
+		tok := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss": iss, "aud": "chat", "sub": "s1", "nonce": "n",
+			"exp": time.Now().Add(time.Minute).Unix(),
+		})
+		tok.Header["kid"] = "k1"
+		signed, err := tok.SignedString(idp.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	if _, err := p.VerifyIDToken(context.Background(), sign(idp.issuer), "n"); err != nil {
+		t.Fatalf("token of the discovered issuer: %v", err)
+	}
+	if _, err := p.VerifyIDToken(context.Background(), sign(idp.URL), "n"); err == nil {
+		t.Fatal("token with another issuer accepted")
+	}
+}
+
+func TestOIDCCallbackState(t *testing.T) {
+	idp := newMockIdP(t)
+	p := newTestProvider(t, idp)
+	cookie, redirect := startLogin(t, p)
+	q := idp.authorize(t, redirect, jwt.MapClaims{"sub": "s1"})
+
+	if rec := callback(nil, p, nil, q); rec.Code != http.StatusBadRequest {
+		t.Errorf("no cookie: status %d", rec.Code)
+	}
+	forged := url.Values{"code": q["code"], "state": {"forged"}}
+	if rec := callback(nil, p, cookie, forged); rec.Code != http.StatusBadRequest {
+		t.Errorf("state mismatch: status %d", rec.Code)
+	}
+	other, _ := startLogin(t, p)
+	if rec := callback(nil, p, other, q); rec.Code != http.StatusBadRequest {
+		t.Errorf("cookie of another login: status %d", rec.Code)
+	}
+}
+
+func TestOIDCCallbackNonce(t *testing.T) {
+	idp := newMockIdP(t)
+	p := newTestProvider(t, idp)
+	cookie, redirect := startLogin(t, p)
+	q := idp.authorize(t, redirect, jwt.MapClaims{"sub": "s1", "nonce": "replayed"})
+	if rec := callback(nil, p, cookie, q); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("nonce mismatch: status %d", rec.Code)
+	}
+}
+
+func TestOIDCCallbackPKCE(t *testing.T) {
+	idp := newMockIdP(t)
+	p := newTestProvider(t, idp)
+	_, redirect := startLogin(t, p)
+	q := idp.authorize(t, redirect, jwt.MapClaims{"sub": "s1"})
+
+	// a code intercepted from one login can't be redeemed with another login's verifier
+	attacker, attackerRedirect := startLogin(t, p)
+	u, _ := url.Parse(attackerRedirect)
+	q.Set("state", u.Query().Get("state"))
+	if rec := callback(nil, p, attacker, q); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong verifier: status %d", rec.Code)
+	}
+}
+
+func TestOIDCCallbackLinksAccounts(t *testing.T) {
+	idp := newMockIdP(t)
+	p := newTestProvider(t, idp)
+	pool := testdb.Pool(t)
+	ctx := context.Background()
+	existing := testdb.CreateUser(t, pool, "linked@example.com")
+
+	login := func(claims jwt.MapClaims) *httptest.ResponseRecorder {
+		cookie, redirect := startLogin(t, p)
+		return callback(pool, p, cookie, idp.authorize(t, redirect, claims))
+	}
+	identityOwner := func(sub string) string {
+		var uid string
+		err := pool.QueryRow(ctx, `SELECT user_id::text FROM user_identities WHERE issuer = $1 AND subject = $2`, p.Issuer, sub).Scan(&uid)
+		if err != nil {
+			return ""
+		}
+		return uid
+	}
+
+	// an unverified email doesn't take over the existing account
+	if rec := login(jwt.MapClaims{"sub": "s1", "email": "linked@example.com", "email_verified": false}); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unverified email: status %d", rec.Code)
+	}
+	if owner := identityOwner("s1"); owner != "" {
+		t.Fatalf("unverified email linked to %s", owner)
+	}
+
+	// a verified email is linked to the existing account (case-insensitively)
+	rec := login(jwt.MapClaims{"sub": "s1", "email": "Linked@Example.com", "email_verified": true})
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/" {
+		t.Fatalf("link: status %d location %q", rec.Code, rec.Header().Get("Location"))
+	}
+	session := false
+	for _, c := range rec.Result().Cookies() {
+		session = session || (c.Name != oidcStateCookie && c.Value != "")
+	}
+	if !session {
+		t.Fatal("link: no session cookies")
+	}
+	if owner := identityOwner("s1"); owner != existing.String() {
+		t.Fatalf("s1 linked to %q, want %s", owner, existing)
+	}
+
+	// later logins find the identity by subject, whatever the email says now
+	if rec := login(jwt.MapClaims{"sub": "s1", "email": "renamed@example.com"}); rec.Code != http.StatusFound {
+		t.Fatalf("relogin: status %d", rec.Code)
+	}
+
+	// an unknown verified email provisions a new account
+	// the picture only becomes the avatar when it passes the avatar URL check
+	p.ValidAvatarURL = func(s string) bool { return strings.HasPrefix(s, "https://avatars.example/") }
+	provision := func(sub, email, picture string) (string, *string) {
+		t.Helper()
+		rec := login(jwt.MapClaims{"sub": sub, "email": email, "email_verified": "true", "name": "New User", "picture": picture})
+		if rec.Code != http.StatusFound {
+			t.Fatalf("provision %s: status %d", sub, rec.Code)
+		}
+		var display string
+		var avatar *string
+		if err := pool.QueryRow(ctx, `SELECT display_name, avatar_url FROM users WHERE id::text = $1`, identityOwner(sub)).Scan(&display, &avatar); err != nil {
+			t.Fatal(err)
+		}
+		return display, avatar
+	}
+	if display, avatar := provision("s2", "new@example.com", "http://10.0.0.1/me.png"); display != "New User" || avatar != nil {
+		t.Fatalf("provisioned display name %q, avatar %v", display, avatar)
+	}
+	if _, avatar := provision("s3", "pic@example.com", "https://avatars.example/me.png"); avatar == nil || *avatar != "https://avatars.example/me.png" {
+		t.Fatalf("allowed picture: avatar %v", avatar)
+	}
+}
@@ -0,0 +1,175 @@
+package pins
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Y3rnur/go-realtime-chat/backend"
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+// event types broadcast to the conversation
+const (
+	EventMessagePinned   = "message_pinned"
+	EventMessageUnpinned = "message_unpinned"
+)
+
+// default number of pins per conversation (PINS_MAX_PER_CONVERSATION overrides it)
+const defaultMaxPins = 50
+
+// EventPublisher is the part of the hub the pin handlers need.
+type EventPublisher interface {
+	PublishEvent(convID string, v interface{}) error
+}
+
+// MaxPins returns the maximum number of pinned messages per conversation.
+func MaxPins() int {
+	if v, err := strconv.Atoi(os.Getenv("PINS_MAX_PER_CONVERSATION")); err == nil && v > 0 {
+		return v
+	}
+	return defaultMaxPins
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// Handler pins (POST) and unpins (DELETE) a message. Route: /api/messages/{id}/pin
+func Handler(pool *pgxpool.Pool, publisher EventPublisher) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uid, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+		if err != nil {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+		msgID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid message id", http.StatusBadRequest)
+			return
+		}
+
+		m, err := store.GetMessage(r.Context(), pool, msgID)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				http.Error(w, "message not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		role, isGroup, err := store.GetParticipantRole(r.Context(), pool, m.ConversationID, uid)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				// not revealing messages of other conversations
+				http.Error(w, "message not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		if !store.CanManageConversation(role, isGroup) {
+			http.Error(w, "only conversation owners and admins can pin messages", http.StatusForbidden)
+			return
+		}
+
+		var changed bool
+		if r.Method == http.MethodPost {
+			switch {
+			case m.IsDeleted:
+				http.Error(w, "message was deleted", http.StatusConflict)
+				return
+			case m.ParentMessageID != nil:
+				http.Error(w, "thread replies can't be pinned", http.StatusBadRequest)
+				return
+			}
+			changed, err = store.PinMessage(r.Context(), pool, m, uid, MaxPins())
+			if errors.Is(err, store.ErrPinLimit) {
+				http.Error(w, "pin limit reached for this conversation", http.StatusConflict)
+				return
+			}
+		} else {
+			changed, err = store.UnpinMessage(r.Context(), pool, msgID)
+		}
+		if err != nil {
+			log.Printf("pins: %s msg=%s user=%s error: %v", r.Method, msgID, uid, err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		pinned := r.Method == http.MethodPost
+		if changed && publisher != nil {
+			payload := map[string]any{
+				"type":            EventMessageUnpinned,
+				"conversation_id": m.ConversationID,
+				"message_id":      m.ID,
+				"user_id":         uid,
+				"timestamp":       time.Now().UTC().Format(time.RFC3339),
+			}
+			if pinned {
+				m.Pinned = true
+				payload["type"] = EventMessagePinned
+				payload["message"] = m
+			}
+			if err := publisher.PublishEvent(m.ConversationID.String(), payload); err != nil {
+				log.Printf("pins: publish %s error: %v", payload["type"], err)
+			}
+		}
+
+		status := http.StatusOK
+		if changed && pinned {
+			status = http.StatusCreated
+		}
+		writeJSON(w, status, map[string]any{"message_id": msgID, "pinned": pinned})
+	})
+}
+
+// ListHandler returns a conversation's pinned messages (participants only).
+// Route: GET /api/conversations/{id}/pins
+func ListHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uid, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+		if err != nil {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+		convID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid conversation id", http.StatusBadRequest)
+			return
+		}
+		member, err := store.IsUserInConversation(r.Context(), pool, convID, uid)
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		if !member {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		pinned, err := store.GetPinnedMessages(r.Context(), pool, convID, uid)
+		if err != nil {
+			log.Printf("pins: list conv=%s error: %v", convID, err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"pins": pinned, "limit": MaxPins()})
+	})
+}
@@ -0,0 +1,191 @@
+// Package ratelimit implements token-bucket rate limits (message sends, typing events,
+// conversation creation). Buckets live in Redis when it's configured, so every instance shares
+// them, and in memory otherwise.
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Scopes a rule is keyed by.
+const (
+	ScopeUser         = "user"
+	ScopeConversation = "conversation"
+)
+
+// Rule is a token bucket: up to Burst actions at once, refilled with one token per Every.
+type Rule struct {
+	Name  string
+	Scope string
+	Burst int
+	Every time.Duration
+}
+
+// The limits applied to clients. Per-user buckets stop a single client from flooding;
+// per-conversation buckets cap a conversation as a whole (many clients, or one user's bots).
+var (
+	MessagesPerUser         = Rule{Name: "messages", Scope: ScopeUser, Burst: 20, Every: time.Second}
+	MessagesPerConversation = Rule{Name: "messages", Scope: ScopeConversation, Burst: 60, Every: 200 * time.Millisecond}
+	TypingPerUser           = Rule{Name: "typing", Scope: ScopeUser, Burst: 10, Every: time.Second}
+	TypingPerConversation   = Rule{Name: "typing", Scope: ScopeConversation, Burst: 30, Every: 250 * time.Millisecond}
+	ConversationsPerUser    = Rule{Name: "conversations", Scope: ScopeUser, Burst: 10, Every: 30 * time.Second}
+)
+
+// Exceeded is returned by Allow when a bucket is empty.
+type Exceeded struct {
+	Rule       Rule
+	RetryAfter time.Duration
+}
+
+func (e *Exceeded) Error() string {
+	return fmt.Sprintf("%s rate limit exceeded (per %s), retry after %s", e.Rule.Name, e.Rule.Scope, e.RetryAfter)
+}
+
+// RetryAfterSeconds rounds RetryAfter up to whole seconds (at least 1), as in Retry-After headers.
+func (e *Exceeded) RetryAfterSeconds() int {
+	return max(1, int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// Fields are the details sent to clients, in 429 responses and WebSocket error frames.
+func (e *Exceeded) Fields() map[string]any {
+	return map[string]any{
+		"error":       "rate limit exceeded",
+		"code":        "rate_limited",
+		"limit":       e.Rule.Name,
+		"scope":       e.Rule.Scope,
+		"retry_after": e.RetryAfterSeconds(),
+	}
+}
+
+// WriteHTTP answers a request with 429 Too Many Requests and a Retry-After header.
+func (e *Exceeded) WriteHTTP(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(e.RetryAfterSeconds()))
+	w.WriteHeader(http.StatusTooManyRequests)
+	_ = json.NewEncoder(w).Encode(e.Fields())
+}
+
+// takeScript refills and takes a token from the bucket in KEYS[1] (burst ARGV[1], one token
+// per ARGV[2] ms) using the Redis clock, so instances with skewed clocks agree. It returns 0
+// when a token was taken, or the milliseconds until one is available.
+var takeScript = redis.NewScript(`
+local burst = tonumber(ARGV[1])
+local every = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(b[1])
+local ts = tonumber(b[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - ts) / every)
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) * every)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], burst * every + 1000)
+return wait
+`)
+
+type bucket struct {
+	tokens float64
+	ts     time.Time
+	// time for an empty bucket to fill up
+	refill time.Duration
+}
+
+// Limiter checks rules against Redis, or against in-memory buckets without Redis (and while
+// Redis is failing, so a Redis outage doesn't lift the limits).
+type Limiter struct {
+	redis *redis.Client
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func New(redisClient *redis.Client) *Limiter {
+	return &Limiter{
+		redis:   redisClient,
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from rule's bucket for id (a user or conversation id, per rule.Scope).
+// It returns *Exceeded when the bucket is empty.
+func (l *Limiter) Allow(ctx context.Context, rule Rule, id string) error {
+	key := "ratelimit:" + rule.Name + ":" + rule.Scope + ":" + id
+	var wait time.Duration
+	if l.redis != nil {
+		ms, err := takeScript.Run(ctx, l.redis, []string{key}, rule.Burst, rule.Every.Milliseconds()).Int64()
+		if err == nil {
+			wait = time.Duration(ms) * time.Millisecond
+		} else {
+			log.Printf("ratelimit: redis error for %s, using local bucket: %v", key, err)
+			wait = l.takeLocal(key, rule)
+		}
+	} else {
+		wait = l.takeLocal(key, rule)
+	}
+	if wait > 0 {
+		return &Exceeded{Rule: rule, RetryAfter: wait}
+	}
+	return nil
+}
+
+// AllowMessage applies the message rules to a new message of userID in convID: the sender's
+// bucket first, then the conversation's. Every way of posting a message (REST, attachments,
+// incoming webhooks) goes through it.
+func (l *Limiter) AllowMessage(ctx context.Context, userID, convID string) error {
+	if err := l.Allow(ctx, MessagesPerUser, userID); err != nil {
+		return err
+	}
+	return l.Allow(ctx, MessagesPerConversation, convID)
+}
+
+// takeLocal is the in-memory version of takeScript.
+func (l *Limiter) takeLocal(key string, rule Rule) time.Duration {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// buckets idle long enough to be full again are the same as missing ones
+	if now.Sub(l.lastSweep) > time.Minute {
+		for k, b := range l.buckets {
+			if now.Sub(b.ts) > b.refill {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rule.Burst), ts: now, refill: time.Duration(rule.Burst) * rule.Every}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.ts); elapsed > 0 {
+		b.tokens = math.Min(float64(rule.Burst), b.tokens+float64(elapsed)/float64(rule.Every))
+	}
+	b.ts = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration(math.Ceil((1 - b.tokens) * float64(rule.Every)))
+}
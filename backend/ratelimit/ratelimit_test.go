@@ -0,0 +1,45 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAllowMessage(t *testing.T) {
+	l := New(nil)
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < MessagesPerUser.Burst; i++ {
+		if err := l.AllowMessage(ctx, "u1", "c1"); err != nil {
+			t.Fatalf("message %d: %v", i+1, err)
+		}
+	}
+	var exceeded *Exceeded
+	if err := l.AllowMessage(ctx, "u1", "c1"); !errors.As(err, &exceeded) || exceeded.Rule != MessagesPerUser {
+		t.Fatalf("over the user burst: %v", err)
+	}
+
+	// other senders still fill the conversation's bucket
+	for sent := MessagesPerUser.Burst; sent < MessagesPerConversation.Burst; sent++ {
+		user := fmt.Sprintf("u%d", 1+sent/MessagesPerUser.Burst)
+		if err := l.AllowMessage(ctx, user, "c1"); err != nil {
+			t.Fatalf("message %d: %v", sent+1, err)
+		}
+	}
+	if err := l.AllowMessage(ctx, "u9", "c1"); !errors.As(err, &exceeded) || exceeded.Rule != MessagesPerConversation {
+		t.Fatalf("over the conversation burst: %v", err)
+	}
+	if err := l.AllowMessage(ctx, "u9", "c2"); err != nil {
+		t.Fatalf("other conversation: %v", err)
+	}
+
+	now = now.Add(MessagesPerUser.Every)
+	if err := l.AllowMessage(ctx, "u1", "c2"); err != nil {
+		t.Fatalf("after refill: %v", err)
+	}
+}
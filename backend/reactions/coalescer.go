@@ -0,0 +1,131 @@
+package reactions
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+// event types published for reactions
+const (
+	EventReactionAdded   = "reaction_added"
+	EventReactionRemoved = "reaction_removed"
+)
+
+// how long reaction changes of a conversation are collected before they are published
+const defaultWindow = 300 * time.Millisecond
+
+// EventPublisher is the part of the hub the coalescer needs.
+type EventPublisher interface {
+	PublishEvent(convID string, v interface{}) error
+}
+
+type reactionKey struct {
+	messageID uuid.UUID
+	emoji     string
+}
+
+// pending changes of one conversation: key -> user -> net change (+1 added, -1 removed)
+type batch map[reactionKey]map[uuid.UUID]int
+
+// Coalescer batches reaction changes per conversation. Within a window every
+// (message, emoji) yields at most one reaction_added and one reaction_removed event
+// listing the users and the current total, and an add undone by a remove (or vice
+// versa) is not published at all.
+type Coalescer struct {
+	pool      *pgxpool.Pool
+	publisher EventPublisher
+	window    time.Duration
+
+	mu      sync.Mutex
+	pending map[uuid.UUID]batch
+}
+
+func NewCoalescer(pool *pgxpool.Pool, publisher EventPublisher) *Coalescer {
+	return &Coalescer{
+		pool:      pool,
+		publisher: publisher,
+		window:    defaultWindow,
+		pending:   map[uuid.UUID]batch{},
+	}
+}
+
+// Record queues a change; the first change of a conversation schedules its flush.
+func (c *Coalescer) Record(convID, msgID, userID uuid.UUID, emoji string, added bool) {
+	delta := -1
+	if added {
+		delta = 1
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.pending[convID]
+	if !ok {
+		b = batch{}
+		c.pending[convID] = b
+		time.AfterFunc(c.window, func() { c.flush(convID) })
+	}
+	k := reactionKey{messageID: msgID, emoji: emoji}
+	if b[k] == nil {
+		b[k] = map[uuid.UUID]int{}
+	}
+	b[k][userID] += delta
+	if b[k][userID] == 0 {
+		delete(b[k], userID)
+	}
+}
+
+func (c *Coalescer) flush(convID uuid.UUID) {
+	c.mu.Lock()
+	b := c.pending[convID]
+	delete(c.pending, convID)
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	for k, users := range b {
+		if len(users) == 0 {
+			continue
+		}
+		var added, removed []uuid.UUID
+		for u, d := range users {
+			if d > 0 {
+				added = append(added, u)
+			} else {
+				removed = append(removed, u)
+			}
+		}
+		count, err := store.CountReactions(ctx, c.pool, k.messageID, k.emoji)
+		if err != nil {
+			log.Printf("reactions: count msg=%s error: %v", k.messageID, err)
+			continue
+		}
+		c.publish(convID, EventReactionAdded, k, added, count)
+		c.publish(convID, EventReactionRemoved, k, removed, count)
+	}
+}
+
+func (c *Coalescer) publish(convID uuid.UUID, event string, k reactionKey, users []uuid.UUID, count int) {
+	if len(users) == 0 || c.publisher == nil {
+		return
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].String() < users[j].String() })
+	payload := map[string]any{
+		"type":            event,
+		"conversation_id": convID,
+		"message_id":      k.messageID,
+		"emoji":           k.emoji,
+		"user_ids":        users,
+		"count":           count,
+	}
+	if err := c.publisher.PublishEvent(convID.String(), payload); err != nil {
+		log.Printf("reactions: publish %s error: %v", event, err)
+	}
+}
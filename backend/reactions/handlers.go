@@ -0,0 +1,141 @@
+package reactions
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Y3rnur/go-realtime-chat/backend"
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+// maximum encoded length of a reaction (long enough for ZWJ sequences and skin tones)
+const maxEmojiBytes = 32
+
+var shortcodeRe = regexp.MustCompile(`^:[a-z0-9_+-]{1,30}:$`)
+
+// validEmoji accepts a short emoji sequence (no letters, digits, spaces or control
+// characters) or a ":shortcode:".
+func validEmoji(s string) bool {
+	if s == "" || len(s) > maxEmojiBytes || !utf8.ValidString(s) {
+		return false
+	}
+	if shortcodeRe.MatchString(s) {
+		return true
+	}
+	symbol := false
+	for _, r := range s {
+		switch {
+		case unicode.IsSpace(r), unicode.IsControl(r), unicode.IsLetter(r), unicode.IsDigit(r) && r < 0x80:
+			return false
+		case unicode.Is(unicode.So, r) || unicode.Is(unicode.Sk, r) || r >= 0x1F000 || r == 0x20E3: // U+20E3 makes keycaps (#️⃣)
+			symbol = true
+		}
+	}
+	return symbol
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// Handler adds (POST {"emoji"}) and removes (DELETE ?emoji= or {"emoji"}) the caller's reaction
+// on a message. Route: /api/messages/{id}/reactions (participants only).
+func Handler(pool *pgxpool.Pool, c *Coalescer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uid, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+		if err != nil {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+		msgID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid message id", http.StatusBadRequest)
+			return
+		}
+
+		emoji := r.URL.Query().Get("emoji")
+		if emoji == "" && r.ContentLength != 0 {
+			var req struct {
+				Emoji string `json:"emoji"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "invalid request", http.StatusBadRequest)
+				return
+			}
+			emoji = req.Emoji
+		}
+		emoji = strings.TrimSpace(emoji)
+		if !validEmoji(emoji) {
+			http.Error(w, "invalid emoji", http.StatusBadRequest)
+			return
+		}
+
+		m, err := store.GetMessage(r.Context(), pool, msgID)
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				http.Error(w, "message not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		member, err := store.IsUserInConversation(r.Context(), pool, m.ConversationID, uid)
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		if !member {
+			// not revealing messages of other conversations
+			http.Error(w, "message not found", http.StatusNotFound)
+			return
+		}
+
+		var changed bool
+		if r.Method == http.MethodPost {
+			if m.IsDeleted {
+				http.Error(w, "message was deleted", http.StatusConflict)
+				return
+			}
+			changed, err = store.AddReaction(r.Context(), pool, msgID, uid, emoji)
+		} else {
+			changed, err = store.RemoveReaction(r.Context(), pool, msgID, uid, emoji)
+		}
+		if err != nil {
+			log.Printf("reactions: %s msg=%s user=%s error: %v", r.Method, msgID, uid, err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		if changed && c != nil {
+			c.Record(m.ConversationID, msgID, uid, emoji, r.Method == http.MethodPost)
+		}
+
+		count, err := store.CountReactions(r.Context(), pool, msgID, emoji)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		status := http.StatusOK
+		if changed && r.Method == http.MethodPost {
+			status = http.StatusCreated
+		}
+		writeJSON(w, status, map[string]any{
+			"message_id": msgID,
+			"reaction":   store.Reaction{Emoji: emoji, Count: count, Reacted: r.Method == http.MethodPost},
+		})
+	})
+}
@@ -0,0 +1,188 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password reset token lifetime
+const passwordResetTTL = time.Hour
+
+// minimum accepted password length
+const minPasswordLength = 8
+
+var errInvalidResetToken = errors.New("invalid or expired reset token")
+
+// AppBaseURL returns the public URL used in emailed links and webhook URLs.
+func AppBaseURL() string {
+	if u := os.Getenv("APP_BASE_URL"); u != "" {
+		return strings.TrimRight(u, "/")
+	}
+	return "http://localhost:8080"
+}
+
+// createPasswordResetToken stores a hashed reset token for the user and returns the raw token.
+func createPasswordResetToken(ctx context.Context, pool *pgxpool.Pool, userID string) (string, error) {
+	token, err := generateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	_, err = pool.Exec(ctx, `
+		INSERT INTO password_reset_tokens (user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)
+	`, userID, HashToken(token), time.Now().Add(passwordResetTTL))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumePasswordResetToken marks the token as used, sets the new password and revokes all refresh tokens of the user.
+func consumePasswordResetToken(ctx context.Context, pool *pgxpool.Pool, token, newPassword string) (string, error) {
+	pwHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var id, userID string
+	err = tx.QueryRow(ctx, `
+		SELECT id::text, user_id::text
+		FROM password_reset_tokens
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+		FOR UPDATE
+	`, HashToken(token)).Scan(&id, &userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", errInvalidResetToken
+		}
+		return "", err
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE password_reset_tokens SET used_at = now() WHERE id = $1`, id); err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE users SET password_hash = $1, updated_at = now() WHERE id = $2`, string(pwHash), userID); err != nil {
+		return "", err
+	}
+	// a reset invalidates every existing session of the user
+	if _, err := tx.Exec(ctx, `UPDATE refresh_tokens SET revoked = true WHERE user_id = $1 AND revoked = false`, userID); err != nil {
+		return "", err
+	}
+	// other outstanding reset links are no longer needed
+	if _, err := tx.Exec(ctx, `UPDATE password_reset_tokens SET used_at = now() WHERE user_id = $1 AND used_at IS NULL`, userID); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// PasswordResetRequestHandler emails a single-use reset link. It always answers 202 so emails can't be enumerated.
+func PasswordResetRequestHandler(pool *pgxpool.Pool, mailer Mailer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Email string `json:"email"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		email := strings.TrimSpace(req.Email)
+		if email == "" {
+			http.Error(w, "email required", http.StatusBadRequest)
+			return
+		}
+
+		var userID string
+		err := pool.QueryRow(r.Context(), `SELECT id::text FROM users WHERE email = $1 AND is_active`, email).Scan(&userID)
+		if err == nil {
+			token, err := createPasswordResetToken(r.Context(), pool, userID)
+			if err != nil {
+				log.Printf("password reset: create token error user=%s: %v", userID, err)
+				http.Error(w, "server error", http.StatusInternalServerError)
+				return
+			}
+			link := fmt.Sprintf("%s/?reset_token=%s", AppBaseURL(), token)
+			body := fmt.Sprintf("Someone requested a password reset for your account.\n\nUse the link below within %d minutes to choose a new password:\n%s\n\nIf it wasn't you, ignore this email.", int(passwordResetTTL.Minutes()), link)
+			if err := mailer.Send(r.Context(), email, "Reset your password", body); err != nil {
+				log.Printf("password reset: send mail error user=%s: %v", userID, err)
+			}
+		} else if !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("password reset: lookup error: %v", err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"ok":      true,
+			"message": "if the account exists, a reset link has been sent",
+		})
+	})
+}
+
+// PasswordResetConfirmHandler exchanges a reset token for a new password.
+func PasswordResetConfirmHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Token    string `json:"token"`
+			Password string `json:"password"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		if req.Token == "" {
+			http.Error(w, "token required", http.StatusBadRequest)
+			return
+		}
+		if len(req.Password) < minPasswordLength {
+			http.Error(w, fmt.Sprintf("password must be at least %d characters", minPasswordLength), http.StatusBadRequest)
+			return
+		}
+
+		userID, err := consumePasswordResetToken(r.Context(), pool, req.Token, req.Password)
+		if err != nil {
+			if errors.Is(err, errInvalidResetToken) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Printf("password reset: confirm error: %v", err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("password reset: password changed for user %s", userID)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"ok":      true,
+			"message": "password updated",
+		})
+	})
+}
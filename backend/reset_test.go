@@ -0,0 +1,84 @@
+package backend_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/Y3rnur/go-realtime-chat/backend"
+	"github.com/Y3rnur/go-realtime-chat/backend/testdb"
+)
+
+var resetLink = regexp.MustCompile(`reset_token=([A-Za-z0-9_-]+)`)
+
+func post(h http.Handler, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	return rec
+}
+
+func TestPasswordResetFlow(t *testing.T) {
+	pool := testdb.Pool(t)
+	ctx := context.Background()
+	uid := testdb.CreateUser(t, pool, "reset@example.com")
+	if _, err := pool.Exec(ctx, `INSERT INTO refresh_tokens (user_id, token_hash, expires_at) VALUES ($1, 'h', now() + interval '1 day')`, uid); err != nil {
+		t.Fatal(err)
+	}
+
+	mailer := &backend.MemoryMailer{}
+	request := backend.PasswordResetRequestHandler(pool, mailer)
+	confirm := backend.PasswordResetConfirmHandler(pool)
+
+	// unknown addresses get the same answer and no mail
+	if rec := post(request, `{"email":"nobody@example.com"}`); rec.Code != http.StatusAccepted {
+		t.Fatalf("unknown email: status %d", rec.Code)
+	}
+	if n := len(mailer.Sent()); n != 0 {
+		t.Fatalf("unknown email: %d mails sent", n)
+	}
+
+	if rec := post(request, `{"email":"reset@example.com"}`); rec.Code != http.StatusAccepted {
+		t.Fatalf("request: status %d", rec.Code)
+	}
+	sent := mailer.Sent()
+	if len(sent) != 1 || sent[0].To != "reset@example.com" {
+		t.Fatalf("sent = %+v", sent)
+	}
+	m := resetLink.FindStringSubmatch(sent[0].Body)
+	if m == nil {
+		t.Fatalf("no reset link in %q", sent[0].Body)
+	}
+	token := m[1]
+
+	if rec := post(confirm, `{"token":"`+token+`","password":"short"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("short password: status %d", rec.Code)
+	}
+	if rec := post(confirm, `{"token":"`+token+`","password":"new-password"}`); rec.Code != http.StatusOK {
+		t.Fatalf("confirm: status %d %s", rec.Code, rec.Body)
+	}
+
+	var hash string
+	var revoked bool
+	if err := pool.QueryRow(ctx, `SELECT password_hash FROM users WHERE id = $1`, uid).Scan(&hash); err != nil {
+		t.Fatal(err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte("new-password")) != nil {
+		t.Fatal("password was not changed")
+	}
+	if err := pool.QueryRow(ctx, `SELECT bool_and(revoked) FROM refresh_tokens WHERE user_id = $1`, uid).Scan(&revoked); err != nil {
+		t.Fatal(err)
+	}
+	if !revoked {
+		t.Fatal("sessions were not revoked")
+	}
+
+	// tokens are single-use
+	if rec := post(confirm, `{"token":"`+token+`","password":"another-password"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("reused token: status %d", rec.Code)
+	}
+}
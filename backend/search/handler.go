@@ -0,0 +1,158 @@
+package search
+
+import (
+	"encoding/json"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Y3rnur/go-realtime-chat/backend"
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+const (
+	defaultLimit   = 20
+	maxLimit       = 100
+	maxQueryLength = 256
+)
+
+type result struct {
+	store.SearchResult
+	// SnippetHTML is HTML-escaped message text with matches wrapped in <mark>.
+	SnippetHTML string `json:"snippet_html"`
+}
+
+type response struct {
+	Results    []result `json:"results"`
+	NextCursor string   `json:"next_cursor,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// parseTime accepts RFC 3339 timestamps or plain dates (YYYY-MM-DD, UTC midnight).
+func parseTime(s string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+func snippetHTML(snippet string) string {
+	s := html.EscapeString(snippet)
+	s = strings.ReplaceAll(s, store.HighlightStart, "<mark>")
+	return strings.ReplaceAll(s, store.HighlightStop, "</mark>")
+}
+
+// Handler serves GET /api/search?q=...&author_id=&conversation_id=&from=&to=&limit=&cursor=
+// over the conversations the caller participates in. "to" is exclusive; a plain date "to"
+// includes that whole day.
+func Handler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uid, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, "invalid user")
+			return
+		}
+
+		qs := r.URL.Query()
+		p := store.SearchParams{Query: strings.TrimSpace(qs.Get("q")), Limit: defaultLimit}
+		if p.Query == "" {
+			writeError(w, http.StatusBadRequest, "q required")
+			return
+		}
+		if len(p.Query) > maxQueryLength {
+			writeError(w, http.StatusBadRequest, "q too long")
+			return
+		}
+		if v := qs.Get("author_id"); v != "" {
+			id, err := uuid.Parse(v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid author_id")
+				return
+			}
+			p.AuthorID = &id
+		}
+		if v := qs.Get("conversation_id"); v != "" {
+			id, err := uuid.Parse(v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "invalid conversation_id")
+				return
+			}
+			p.ConversationID = &id
+		}
+		if v := qs.Get("from"); v != "" {
+			t, ok := parseTime(v)
+			if !ok {
+				writeError(w, http.StatusBadRequest, "invalid from (RFC 3339 or YYYY-MM-DD)")
+				return
+			}
+			p.From = &t
+		}
+		if v := qs.Get("to"); v != "" {
+			t, ok := parseTime(v)
+			if !ok {
+				writeError(w, http.StatusBadRequest, "invalid to (RFC 3339 or YYYY-MM-DD)")
+				return
+			}
+			if len(v) == len(time.DateOnly) {
+				t = t.AddDate(0, 0, 1)
+			}
+			p.To = &t
+		}
+		if v := qs.Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeError(w, http.StatusBadRequest, "invalid limit")
+				return
+			}
+			p.Limit = min(n, maxLimit)
+		}
+		if v := qs.Get("cursor"); v != "" {
+			t, id, ok := backend.DecodeCursor(v)
+			if !ok {
+				writeError(w, http.StatusBadRequest, "invalid cursor")
+				return
+			}
+			p.BeforeTime, p.BeforeID = &t, &id
+		}
+
+		// fetching one extra row to know whether there is a next page
+		limit := p.Limit
+		p.Limit++
+		rows, err := store.SearchMessages(r.Context(), pool, uid, p)
+		if err != nil {
+			log.Printf("search: user=%s error: %v", uid, err)
+			writeError(w, http.StatusInternalServerError, "database error")
+			return
+		}
+
+		res := response{Results: make([]result, 0, min(len(rows), limit))}
+		if len(rows) > limit {
+			rows = rows[:limit]
+			last := rows[len(rows)-1]
+			res.NextCursor = backend.EncodeCursor(last.CreatedAt, last.ID)
+		}
+		for _, row := range rows {
+			res.Results = append(res.Results, result{SearchResult: row, SnippetHTML: snippetHTML(row.Snippet)})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(res)
+	})
+}
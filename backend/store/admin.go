@@ -0,0 +1,279 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// user status filters of ListUsersAdmin
+const (
+	UserStatusActive    = "active"
+	UserStatusSuspended = "suspended"
+)
+
+// AdminUser is a user account as shown to instance admins.
+type AdminUser struct {
+	ID          uuid.UUID `json:"id"`
+	Email       string    `json:"email"`
+	DisplayName *string   `json:"display_name,omitempty"`
+	IsActive    bool      `json:"is_active"`
+	IsAdmin     bool      `json:"is_admin"`
+	IsBot       bool      `json:"is_bot"`
+	CreatedAt   time.Time `json:"created_at"`
+	// LastLoginAt is the start of the most recent session (refresh tokens are re-dated on rotation)
+	LastLoginAt       *time.Time `json:"last_login_at,omitempty"`
+	ActiveSessions    int        `json:"active_sessions"`
+	ConversationCount int        `json:"conversation_count"`
+	OpenReports       int        `json:"open_reports"`
+}
+
+// AdminUserFilter filters and pages ListUsersAdmin.
+type AdminUserFilter struct {
+	// Query matches email or display name (case-insensitive substring)
+	Query string
+	// Status is UserStatusActive, UserStatusSuspended or "" for both
+	Status     string
+	BeforeTime *time.Time
+	BeforeID   *uuid.UUID
+	Limit      int
+}
+
+// AdminConversation is conversation metadata for instance admins; it never includes message content.
+type AdminConversation struct {
+	ID               uuid.UUID          `json:"id"`
+	IsGroup          bool               `json:"is_group"`
+	Title            *string            `json:"title,omitempty"`
+	CreatedBy        *uuid.UUID         `json:"created_by,omitempty"`
+	CreatedAt        time.Time          `json:"created_at"`
+	LastMessageAt    *time.Time         `json:"last_message_at,omitempty"`
+	ParticipantCount int                `json:"participant_count"`
+	MessageCount     int                `json:"message_count"`
+	Participants     []AdminParticipant `json:"participants,omitempty"`
+}
+
+// AdminParticipant is a member of a conversation as listed by GetConversationAdmin.
+type AdminParticipant struct {
+	UserID      uuid.UUID `json:"user_id"`
+	DisplayName *string   `json:"display_name,omitempty"`
+	Role        string    `json:"role"`
+	JoinedAt    time.Time `json:"joined_at"`
+}
+
+// ServerStats are instance-wide counters for the admin dashboard.
+type ServerStats struct {
+	Users               int   `json:"users"`
+	ActiveUsers         int   `json:"active_users"`
+	SuspendedUsers      int   `json:"suspended_users"`
+	Admins              int   `json:"admins"`
+	Bots                int   `json:"bots"`
+	SignupsLast7Days    int   `json:"signups_last_7_days"`
+	ActiveAuthors7Days  int   `json:"active_authors_last_7_days"`
+	Conversations       int   `json:"conversations"`
+	GroupConversations  int   `json:"group_conversations"`
+	Messages            int   `json:"messages"`
+	MessagesLast24Hours int   `json:"messages_last_24_hours"`
+	AttachmentBytes     int64 `json:"attachment_bytes"`
+	ActiveSessions      int   `json:"active_sessions"`
+	OpenReports         int   `json:"open_reports"`
+}
+
+const adminUserColumns = `u.id, u.email, u.display_name, u.is_active, u.is_admin, u.is_bot, u.created_at,
+	(SELECT max(rt.created_at) FROM refresh_tokens rt WHERE rt.user_id = u.id),
+	(SELECT count(*) FROM refresh_tokens rt WHERE rt.user_id = u.id AND NOT rt.revoked AND rt.expires_at > now()),
+	(SELECT count(*) FROM conversation_participants cp WHERE cp.user_id = u.id),
+	(SELECT count(*) FROM reports rp WHERE rp.user_id = u.id AND rp.status = 'open')`
+
+func scanAdminUser(row pgx.Row) (AdminUser, error) {
+	var u AdminUser
+	err := row.Scan(&u.ID, &u.Email, &u.DisplayName, &u.IsActive, &u.IsAdmin, &u.IsBot, &u.CreatedAt,
+		&u.LastLoginAt, &u.ActiveSessions, &u.ConversationCount, &u.OpenReports)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return u, ErrNotFound
+	}
+	return u, err
+}
+
+// ListUsersAdmin lists accounts, newest first, keyset-paged by (created_at, id).
+func ListUsersAdmin(ctx context.Context, pool *pgxpool.Pool, f AdminUserFilter) ([]AdminUser, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT `+adminUserColumns+`
+		FROM users u
+		WHERE ($1 = '' OR u.email ILIKE '%' || $1 || '%' OR u.display_name ILIKE '%' || $1 || '%')
+			AND ($2 = '' OR u.is_active = ($2 = 'active'))
+			AND ($3::timestamptz IS NULL OR (u.created_at, u.id) < ($3, $4))
+		ORDER BY u.created_at DESC, u.id DESC
+		LIMIT $5
+	`, f.Query, f.Status, f.BeforeTime, f.BeforeID, f.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []AdminUser{}
+	for rows.Next() {
+		u, err := scanAdminUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+// GetUserAdmin returns a single account; ErrNotFound for unknown users.
+func GetUserAdmin(ctx context.Context, pool *pgxpool.Pool, userID uuid.UUID) (AdminUser, error) {
+	return scanAdminUser(pool.QueryRow(ctx, `SELECT `+adminUserColumns+` FROM users u WHERE u.id = $1`, userID))
+}
+
+// SetUserActive deactivates or reactivates an account and reports whether it changed.
+// Deactivation also revokes the user's refresh tokens.
+func SetUserActive(ctx context.Context, pool *pgxpool.Pool, userID uuid.UUID, active bool) (bool, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	tag, err := tx.Exec(ctx, `UPDATE users SET is_active = $2, updated_at = now() WHERE id = $1 AND is_active <> $2`, userID, active)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+	if !active {
+		if _, err := tx.Exec(ctx, `UPDATE refresh_tokens SET revoked = true WHERE user_id = $1 AND NOT revoked`, userID); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit(ctx)
+}
+
+// SetUserAdmin grants or removes the admin role and reports whether it changed.
+func SetUserAdmin(ctx context.Context, pool *pgxpool.Pool, userID uuid.UUID, admin bool) (bool, error) {
+	tag, err := pool.Exec(ctx, `UPDATE users SET is_admin = $2, updated_at = now() WHERE id = $1 AND is_admin <> $2`, userID, admin)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RevokeSessions revokes every refresh token of the user and returns how many were still valid.
+func RevokeSessions(ctx context.Context, pool *pgxpool.Pool, userID uuid.UUID) (int, error) {
+	tag, err := pool.Exec(ctx, `
+		UPDATE refresh_tokens SET revoked = true
+		WHERE user_id = $1 AND NOT revoked AND expires_at > now()
+	`, userID)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+// RecordModerationAction appends an admin action that isn't tied to a report to the audit trail.
+func RecordModerationAction(ctx context.Context, pool *pgxpool.Pool, a ModerationAction) (ModerationAction, error) {
+	err := pool.QueryRow(ctx, `
+		INSERT INTO moderation_actions (moderator_id, action, report_id, target_user_id, message_id, note)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`, a.ModeratorID, a.Action, a.ReportID, a.TargetUserID, a.MessageID, a.Note).Scan(&a.ID, &a.CreatedAt)
+	return a, err
+}
+
+const adminConversationColumns = `c.id, c.is_group, c.title, c.created_by, c.created_at, c.last_message_at,
+	(SELECT count(*) FROM conversation_participants cp WHERE cp.conversation_id = c.id),
+	(SELECT count(*) FROM messages m WHERE m.conversation_id = c.id)`
+
+func scanAdminConversation(row pgx.Row) (AdminConversation, error) {
+	var c AdminConversation
+	err := row.Scan(&c.ID, &c.IsGroup, &c.Title, &c.CreatedBy, &c.CreatedAt, &c.LastMessageAt,
+		&c.ParticipantCount, &c.MessageCount)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c, ErrNotFound
+	}
+	return c, err
+}
+
+// ListConversationsAdmin lists conversation metadata, newest first, keyset-paged by (created_at, id).
+// A non-nil memberID limits the list to that user's conversations.
+func ListConversationsAdmin(ctx context.Context, pool *pgxpool.Pool, memberID *uuid.UUID, beforeTime *time.Time, beforeID *uuid.UUID, limit int) ([]AdminConversation, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT `+adminConversationColumns+`
+		FROM conversations c
+		WHERE ($1::uuid IS NULL OR EXISTS (
+				SELECT 1 FROM conversation_participants cp WHERE cp.conversation_id = c.id AND cp.user_id = $1))
+			AND ($2::timestamptz IS NULL OR (c.created_at, c.id) < ($2, $3))
+		ORDER BY c.created_at DESC, c.id DESC
+		LIMIT $4
+	`, memberID, beforeTime, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []AdminConversation{}
+	for rows.Next() {
+		c, err := scanAdminConversation(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// GetConversationAdmin returns a conversation's metadata with its participants.
+func GetConversationAdmin(ctx context.Context, pool *pgxpool.Pool, convID uuid.UUID) (AdminConversation, error) {
+	c, err := scanAdminConversation(pool.QueryRow(ctx, `SELECT `+adminConversationColumns+` FROM conversations c WHERE c.id = $1`, convID))
+	if err != nil {
+		return c, err
+	}
+	rows, err := pool.Query(ctx, `
+		SELECT cp.user_id, u.display_name, coalesce(cp.role, 'member'), cp.joined_at
+		FROM conversation_participants cp
+		JOIN users u ON u.id = cp.user_id
+		WHERE cp.conversation_id = $1
+		ORDER BY cp.joined_at
+	`, convID)
+	if err != nil {
+		return c, err
+	}
+	defer rows.Close()
+	c.Participants = []AdminParticipant{}
+	for rows.Next() {
+		var p AdminParticipant
+		if err := rows.Scan(&p.UserID, &p.DisplayName, &p.Role, &p.JoinedAt); err != nil {
+			return c, err
+		}
+		c.Participants = append(c.Participants, p)
+	}
+	return c, rows.Err()
+}
+
+// GetServerStats collects the instance-wide counters in one round trip.
+func GetServerStats(ctx context.Context, pool *pgxpool.Pool) (ServerStats, error) {
+	var s ServerStats
+	err := pool.QueryRow(ctx, `
+		SELECT
+			(SELECT count(*) FROM users),
+			(SELECT count(*) FROM users WHERE is_active),
+			(SELECT count(*) FROM users WHERE NOT is_active),
+			(SELECT count(*) FROM users WHERE is_admin),
+			(SELECT count(*) FROM users WHERE is_bot),
+			(SELECT count(*) FROM users WHERE created_at > now() - interval '7 days'),
+			(SELECT count(DISTINCT author_id) FROM messages WHERE created_at > now() - interval '7 days'),
+			(SELECT count(*) FROM conversations),
+			(SELECT count(*) FROM conversations WHERE is_group),
+			(SELECT count(*) FROM messages),
+			(SELECT count(*) FROM messages WHERE created_at > now() - interval '24 hours'),
+			(SELECT coalesce(sum(size_bytes), 0)::bigint FROM attachments),
+			(SELECT count(*) FROM refresh_tokens WHERE NOT revoked AND expires_at > now()),
+			(SELECT count(*) FROM reports WHERE status = 'open')
+	`).Scan(&s.Users, &s.ActiveUsers, &s.SuspendedUsers, &s.Admins, &s.Bots,
+		&s.SignupsLast7Days, &s.ActiveAuthors7Days, &s.Conversations, &s.GroupConversations,
+		&s.Messages, &s.MessagesLast24Hours, &s.AttachmentBytes, &s.ActiveSessions, &s.OpenReports)
+	return s, err
+}
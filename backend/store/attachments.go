@@ -0,0 +1,259 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Attachment struct {
+	ID             uuid.UUID  `json:"id"`
+	MessageID      uuid.UUID  `json:"message_id"`
+	ConversationID uuid.UUID  `json:"conversation_id"`
+	UploaderID     *uuid.UUID `json:"uploader_id,omitempty"`
+	Filename       string     `json:"filename"`
+	ContentType    string     `json:"content_type"`
+	SizeBytes      int64      `json:"size_bytes"`
+	StorageKey     string     `json:"-"`
+	Status         string     `json:"status"`
+	Width          *int       `json:"width,omitempty"`
+	Height         *int       `json:"height,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+
+	Thumbnails []Thumbnail `json:"thumbnails,omitempty"`
+}
+
+// attachment processing states
+const (
+	AttachmentPending = "pending"
+	AttachmentReady   = "ready"
+	AttachmentFailed  = "failed"
+)
+
+// Thumbnail is a downscaled rendition of an image attachment; Size is the bounding box edge.
+type Thumbnail struct {
+	Size        int    `json:"size"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	ContentType string `json:"content_type"`
+	SizeBytes   int64  `json:"size_bytes"`
+	StorageKey  string `json:"-"`
+}
+
+const attachmentColumns = `id, message_id, conversation_id, uploader_id, filename, content_type, size_bytes, storage_key, status, width, height, created_at`
+
+func scanAttachment(row pgx.Row) (Attachment, error) {
+	var a Attachment
+	err := row.Scan(&a.ID, &a.MessageID, &a.ConversationID, &a.UploaderID, &a.Filename, &a.ContentType, &a.SizeBytes, &a.StorageKey, &a.Status, &a.Width, &a.Height, &a.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return a, ErrNotFound
+	}
+	return a, err
+}
+
+// SaveAttachmentMessage inserts an attachment message (body is an optional caption) and its
+// attachment row atomically. a.ID, a.StorageKey and a.Status are chosen by the caller (the blob is already stored).
+func SaveAttachmentMessage(ctx context.Context, pool *pgxpool.Pool, convID, authorID uuid.UUID, body string, a Attachment) (Message, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return Message{}, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var bodyArg *string
+	if body != "" {
+		bodyArg = &body
+	}
+	var msgID uuid.UUID
+	err = tx.QueryRow(ctx, `
+		INSERT INTO messages (id, conversation_id, author_id, body, message_type, created_at)
+		VALUES (gen_random_uuid(), $1, $2, $3, $4, now())
+		RETURNING id
+	`, convID, authorID, bodyArg, MessageTypeAttachment).Scan(&msgID)
+	if err != nil {
+		return Message{}, err
+	}
+
+	if a.Status == "" {
+		a.Status = AttachmentReady
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO attachments (id, message_id, conversation_id, uploader_id, filename, content_type, size_bytes, storage_key, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, a.ID, msgID, convID, authorID, a.Filename, a.ContentType, a.SizeBytes, a.StorageKey, a.Status); err != nil {
+		return Message{}, err
+	}
+	if err := touchConversation(ctx, tx, msgID); err != nil {
+		return Message{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Message{}, err
+	}
+	return GetMessage(ctx, pool, msgID)
+}
+
+// GetAttachment returns attachment metadata (with thumbnails) by id. Attachments of deleted
+// messages are reported as ErrNotFound.
+func GetAttachment(ctx context.Context, pool *pgxpool.Pool, id uuid.UUID) (Attachment, error) {
+	a, err := scanAttachment(pool.QueryRow(ctx, `
+		SELECT `+attachmentColumns+` FROM attachments
+		WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM messages m WHERE m.id = message_id AND m.is_deleted)
+	`, id))
+	if err != nil {
+		return a, err
+	}
+	thumbs, err := getThumbnails(ctx, pool, []uuid.UUID{a.ID})
+	if err != nil {
+		return a, err
+	}
+	a.Thumbnails = thumbs[a.ID]
+	return a, nil
+}
+
+// GetPendingAttachmentIDs returns attachments still waiting for processing (oldest first).
+func GetPendingAttachmentIDs(ctx context.Context, pool *pgxpool.Pool, limit int) ([]uuid.UUID, error) {
+	if limit <= 0 {
+		limit = 500
+	}
+	rows, err := pool.Query(ctx, `
+		SELECT id FROM attachments
+		WHERE status = 'pending'
+		ORDER BY created_at
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
+
+// MarkAttachmentReady records the processing result (the sanitized blob size, dimensions and thumbnails).
+func MarkAttachmentReady(ctx context.Context, pool *pgxpool.Pool, id uuid.UUID, sizeBytes int64, width, height *int, thumbs []Thumbnail) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE attachments
+		SET status = 'ready', size_bytes = $2, width = $3, height = $4, processed_at = now()
+		WHERE id = $1
+	`, id, sizeBytes, width, height)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		// removed (with its message) while it was being processed
+		return ErrNotFound
+	}
+	for _, t := range thumbs {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO attachment_thumbnails (attachment_id, size, width, height, content_type, size_bytes, storage_key)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (attachment_id, size) DO UPDATE
+			SET width = EXCLUDED.width, height = EXCLUDED.height, content_type = EXCLUDED.content_type,
+				size_bytes = EXCLUDED.size_bytes, storage_key = EXCLUDED.storage_key
+		`, id, t.Size, t.Width, t.Height, t.ContentType, t.SizeBytes, t.StorageKey); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// MarkAttachmentFailed flags an attachment that could not be processed (it is never served).
+func MarkAttachmentFailed(ctx context.Context, pool *pgxpool.Pool, id uuid.UUID) error {
+	_, err := pool.Exec(ctx, `UPDATE attachments SET status = 'failed', processed_at = now() WHERE id = $1`, id)
+	return err
+}
+
+// getThumbnails loads the thumbnails of the given attachments (smallest first).
+func getThumbnails(ctx context.Context, pool *pgxpool.Pool, ids []uuid.UUID) (map[uuid.UUID][]Thumbnail, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT attachment_id, size, width, height, content_type, size_bytes, storage_key
+		FROM attachment_thumbnails
+		WHERE attachment_id = ANY($1)
+		ORDER BY size
+	`, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[uuid.UUID][]Thumbnail{}
+	for rows.Next() {
+		var id uuid.UUID
+		var t Thumbnail
+		if err := rows.Scan(&id, &t.Size, &t.Width, &t.Height, &t.ContentType, &t.SizeBytes, &t.StorageKey); err != nil {
+			return nil, err
+		}
+		out[id] = append(out[id], t)
+	}
+	return out, rows.Err()
+}
+
+// attachAttachments loads the attachments of the given messages in one query.
+func attachAttachments(ctx context.Context, pool *pgxpool.Pool, msgs []Message) error {
+	idx := map[uuid.UUID]int{}
+	var ids []uuid.UUID
+	for i, m := range msgs {
+		if m.MessageType == MessageTypeAttachment && !m.IsDeleted {
+			idx[m.ID] = i
+			ids = append(ids, m.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	rows, err := pool.Query(ctx, `
+		SELECT `+attachmentColumns+`
+		FROM attachments
+		WHERE message_id = ANY($1)
+		ORDER BY created_at
+	`, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return err
+		}
+		i := idx[a.MessageID]
+		msgs[i].Attachments = append(msgs[i].Attachments, a)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	var attIDs []uuid.UUID
+	for _, m := range msgs {
+		for _, a := range m.Attachments {
+			attIDs = append(attIDs, a.ID)
+		}
+	}
+	thumbs, err := getThumbnails(ctx, pool, attIDs)
+	if err != nil {
+		return err
+	}
+	for i := range msgs {
+		for j := range msgs[i].Attachments {
+			msgs[i].Attachments[j].Thumbnails = thumbs[msgs[i].Attachments[j].ID]
+		}
+	}
+	return nil
+}
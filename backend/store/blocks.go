@@ -0,0 +1,125 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrBlocked is returned when the other user has blocked the caller.
+var ErrBlocked = errors.New("blocked by user")
+
+// notBlockedBy is a query condition dropping rows whose authorColumn is on the block list of
+// the viewer passed as viewerParam (a placeholder such as "$3").
+func notBlockedBy(viewerParam, authorColumn string) string {
+	return `NOT EXISTS (SELECT 1 FROM user_blocks ub WHERE ub.blocker_id = ` + viewerParam + ` AND ub.blocked_id = ` + authorColumn + `)`
+}
+
+// BlockedUser is an entry of a user's block list.
+type BlockedUser struct {
+	User
+	BlockedAt time.Time `json:"blocked_at"`
+}
+
+// BlockUser adds blockedID to blockerID's block list; changed is false when it already was.
+func BlockUser(ctx context.Context, pool *pgxpool.Pool, blockerID, blockedID uuid.UUID) (bool, error) {
+	tag, err := pool.Exec(ctx, `
+		INSERT INTO user_blocks (blocker_id, blocked_id, created_at)
+		SELECT $1, id, now() FROM users WHERE id = $2
+		ON CONFLICT DO NOTHING
+	`, blockerID, blockedID)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		var exists bool
+		if err := pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, blockedID).Scan(&exists); err != nil {
+			return false, err
+		}
+		if !exists {
+			return false, ErrNotFound
+		}
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// UnblockUser removes blockedID from blockerID's block list; changed is false when it wasn't on it.
+func UnblockUser(ctx context.Context, pool *pgxpool.Pool, blockerID, blockedID uuid.UUID) (bool, error) {
+	tag, err := pool.Exec(ctx, `DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2`, blockerID, blockedID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetBlockedUsers lists blockerID's block list, most recent first.
+func GetBlockedUsers(ctx context.Context, pool *pgxpool.Pool, blockerID uuid.UUID) ([]BlockedUser, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT u.id, u.display_name, ub.created_at
+		FROM user_blocks ub
+		JOIN users u ON u.id = ub.blocked_id
+		WHERE ub.blocker_id = $1
+		ORDER BY ub.created_at DESC
+	`, blockerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []BlockedUser{}
+	for rows.Next() {
+		var b BlockedUser
+		if err := rows.Scan(&b.ID, &b.DisplayName, &b.BlockedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// GetBlockedIDs returns the ids on blockerID's block list.
+func GetBlockedIDs(ctx context.Context, pool *pgxpool.Pool, blockerID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := pool.Query(ctx, `SELECT blocked_id FROM user_blocks WHERE blocker_id = $1`, blockerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
+
+// GetBlockerIDs returns the users who have blocked userID.
+func GetBlockerIDs(ctx context.Context, pool *pgxpool.Pool, userID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := pool.Query(ctx, `SELECT blocker_id FROM user_blocks WHERE blocked_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
+
+// HasBlocked reports whether blockerID has blocked userID.
+func HasBlocked(ctx context.Context, pool *pgxpool.Pool, blockerID, userID uuid.UUID) (bool, error) {
+	var blocked bool
+	err := pool.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2)
+	`, blockerID, userID).Scan(&blocked)
+	return blocked, err
+}
@@ -0,0 +1,348 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrNotFound = errors.New("not found")
+
+type Bot struct {
+	ID          uuid.UUID `json:"id"`
+	DisplayName *string   `json:"display_name,omitempty"`
+	OwnerID     uuid.UUID `json:"owner_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type OutgoingWebhook struct {
+	ID             uuid.UUID  `json:"id"`
+	ConversationID uuid.UUID  `json:"conversation_id"`
+	BotUserID      *uuid.UUID `json:"bot_user_id,omitempty"`
+	CreatedBy      *uuid.UUID `json:"created_by,omitempty"`
+	URL            string     `json:"url"`
+	Secret         string     `json:"-"`
+	Commands       []string   `json:"commands"`
+	IsActive       bool       `json:"is_active"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+type WebhookDelivery struct {
+	ID         uuid.UUID `json:"id"`
+	WebhookID  uuid.UUID `json:"webhook_id"`
+	EventType  string    `json:"event_type"`
+	Attempt    int       `json:"attempt"`
+	StatusCode *int      `json:"status_code,omitempty"`
+	Error      *string   `json:"error,omitempty"`
+	DurationMS int       `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// CreateBotUser inserts a bot account owned by ownerID. Bots have no usable password.
+func CreateBotUser(ctx context.Context, pool *pgxpool.Pool, ownerID uuid.UUID, displayName string) (Bot, error) {
+	b := Bot{OwnerID: ownerID}
+	id := uuid.New()
+	err := pool.QueryRow(ctx, `
+		INSERT INTO users (id, email, password_hash, display_name, is_bot, bot_owner_id, is_verified)
+		VALUES ($1, $2, '!', $3, TRUE, $4, TRUE)
+		RETURNING id, display_name, created_at
+	`, id, id.String()+"@bots.invalid", displayName, ownerID).Scan(&b.ID, &b.DisplayName, &b.CreatedAt)
+	return b, err
+}
+
+// GetBotsForOwner lists the bots owned by a user.
+func GetBotsForOwner(ctx context.Context, pool *pgxpool.Pool, ownerID uuid.UUID) ([]Bot, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT id, display_name, bot_owner_id, created_at
+		FROM users
+		WHERE is_bot AND bot_owner_id = $1
+		ORDER BY created_at
+	`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Bot{}
+	for rows.Next() {
+		var b Bot
+		if err := rows.Scan(&b.ID, &b.DisplayName, &b.OwnerID, &b.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// IsBotOwnedBy returns true when botID is a bot account owned by ownerID.
+func IsBotOwnedBy(ctx context.Context, pool *pgxpool.Pool, botID, ownerID uuid.UUID) (bool, error) {
+	var ok bool
+	err := pool.QueryRow(ctx, `
+		SELECT EXISTS(SELECT 1 FROM users WHERE id = $1 AND is_bot AND bot_owner_id = $2)
+	`, botID, ownerID).Scan(&ok)
+	return ok, err
+}
+
+// IsBotUser returns true when id is a bot account.
+func IsBotUser(ctx context.Context, pool *pgxpool.Pool, id uuid.UUID) (bool, error) {
+	var ok bool
+	err := pool.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM users WHERE id = $1 AND is_bot)`, id).Scan(&ok)
+	return ok, err
+}
+
+// AddParticipant adds a user to a conversation (no-op when already a participant).
+func AddParticipant(ctx context.Context, pool *pgxpool.Pool, convID, userID uuid.UUID, role string) error {
+	_, err := pool.Exec(ctx, `
+		INSERT INTO conversation_participants (conversation_id, user_id, joined_at, role)
+		VALUES ($1, $2, now(), $3)
+		ON CONFLICT DO NOTHING
+	`, convID, userID, role)
+	return err
+}
+
+// CreateOutgoingWebhook registers a webhook receiving events of a conversation.
+func CreateOutgoingWebhook(ctx context.Context, pool *pgxpool.Pool, convID uuid.UUID, botID *uuid.UUID, createdBy uuid.UUID, url, secret string, commands []string) (OutgoingWebhook, error) {
+	if commands == nil {
+		commands = []string{}
+	}
+	var h OutgoingWebhook
+	err := pool.QueryRow(ctx, `
+		INSERT INTO outgoing_webhooks (conversation_id, bot_user_id, created_by, url, secret, commands)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, conversation_id, bot_user_id, created_by, url, secret, commands, is_active, created_at
+	`, convID, botID, createdBy, url, secret, commands).Scan(&h.ID, &h.ConversationID, &h.BotUserID, &h.CreatedBy, &h.URL, &h.Secret, &h.Commands, &h.IsActive, &h.CreatedAt)
+	return h, err
+}
+
+func scanOutgoingWebhooks(rows pgx.Rows) ([]OutgoingWebhook, error) {
+	defer rows.Close()
+	out := []OutgoingWebhook{}
+	for rows.Next() {
+		var h OutgoingWebhook
+		if err := rows.Scan(&h.ID, &h.ConversationID, &h.BotUserID, &h.CreatedBy, &h.URL, &h.Secret, &h.Commands, &h.IsActive, &h.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, h)
+	}
+	return out, rows.Err()
+}
+
+// GetOutgoingWebhooks lists all webhooks of a conversation (active and disabled).
+func GetOutgoingWebhooks(ctx context.Context, pool *pgxpool.Pool, convID uuid.UUID) ([]OutgoingWebhook, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT id, conversation_id, bot_user_id, created_by, url, secret, commands, is_active, created_at
+		FROM outgoing_webhooks
+		WHERE conversation_id = $1
+		ORDER BY created_at
+	`, convID)
+	if err != nil {
+		return nil, err
+	}
+	return scanOutgoingWebhooks(rows)
+}
+
+// GetActiveOutgoingWebhooks lists the webhooks events of a conversation are delivered to.
+func GetActiveOutgoingWebhooks(ctx context.Context, pool *pgxpool.Pool, convID uuid.UUID) ([]OutgoingWebhook, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT id, conversation_id, bot_user_id, created_by, url, secret, commands, is_active, created_at
+		FROM outgoing_webhooks
+		WHERE conversation_id = $1 AND is_active
+	`, convID)
+	if err != nil {
+		return nil, err
+	}
+	return scanOutgoingWebhooks(rows)
+}
+
+// GetOutgoingWebhook returns a single webhook by id.
+func GetOutgoingWebhook(ctx context.Context, pool *pgxpool.Pool, id uuid.UUID) (OutgoingWebhook, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT id, conversation_id, bot_user_id, created_by, url, secret, commands, is_active, created_at
+		FROM outgoing_webhooks
+		WHERE id = $1
+	`, id)
+	if err != nil {
+		return OutgoingWebhook{}, err
+	}
+	hooks, err := scanOutgoingWebhooks(rows)
+	if err != nil {
+		return OutgoingWebhook{}, err
+	}
+	if len(hooks) == 0 {
+		return OutgoingWebhook{}, ErrNotFound
+	}
+	return hooks[0], nil
+}
+
+// DeleteOutgoingWebhook removes a webhook (and its delivery log).
+func DeleteOutgoingWebhook(ctx context.Context, pool *pgxpool.Pool, id uuid.UUID) error {
+	tag, err := pool.Exec(ctx, `DELETE FROM outgoing_webhooks WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// LogWebhookDelivery records a single delivery attempt.
+func LogWebhookDelivery(ctx context.Context, pool *pgxpool.Pool, webhookID uuid.UUID, eventType string, attempt int, statusCode *int, errMsg *string, duration time.Duration) error {
+	_, err := pool.Exec(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event_type, attempt, status_code, error, duration_ms)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, webhookID, eventType, attempt, statusCode, errMsg, int(duration.Milliseconds()))
+	return err
+}
+
+// GetWebhookDeliveries returns the most recent delivery attempts of a webhook.
+func GetWebhookDeliveries(ctx context.Context, pool *pgxpool.Pool, webhookID uuid.UUID, limit int) ([]WebhookDelivery, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	rows, err := pool.Query(ctx, `
+		SELECT id, webhook_id, event_type, attempt, status_code, error, duration_ms, created_at
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []WebhookDelivery{}
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.EventType, &d.Attempt, &d.StatusCode, &d.Error, &d.DurationMS, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// GetUserDisplayName returns the display name of a user (nil when unset).
+func GetUserDisplayName(ctx context.Context, pool *pgxpool.Pool, userID uuid.UUID) (*string, error) {
+	var dn *string
+	err := pool.QueryRow(ctx, `SELECT display_name FROM users WHERE id = $1`, userID).Scan(&dn)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("user %s: %w", userID, ErrNotFound)
+	}
+	return dn, err
+}
+
+type IncomingWebhook struct {
+	ID             uuid.UUID  `json:"id"`
+	ConversationID uuid.UUID  `json:"conversation_id"`
+	BotUserID      uuid.UUID  `json:"bot_user_id"`
+	CreatedBy      *uuid.UUID `json:"created_by,omitempty"`
+	Name           string     `json:"name"`
+	IsActive       bool       `json:"is_active"`
+	CreatedAt      time.Time  `json:"created_at"`
+	RotatedAt      *time.Time `json:"rotated_at,omitempty"`
+	LastUsedAt     *time.Time `json:"last_used_at,omitempty"`
+}
+
+const incomingWebhookColumns = `id, conversation_id, bot_user_id, created_by, name, is_active, created_at, rotated_at, last_used_at`
+
+func scanIncomingWebhook(row pgx.Row) (IncomingWebhook, error) {
+	var h IncomingWebhook
+	err := row.Scan(&h.ID, &h.ConversationID, &h.BotUserID, &h.CreatedBy, &h.Name, &h.IsActive, &h.CreatedAt, &h.RotatedAt, &h.LastUsedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return h, ErrNotFound
+	}
+	return h, err
+}
+
+// CreateIncomingWebhook creates the hook together with the bot user its messages are attributed to.
+func CreateIncomingWebhook(ctx context.Context, pool *pgxpool.Pool, convID, createdBy uuid.UUID, name, tokenHash string) (IncomingWebhook, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return IncomingWebhook{}, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	botID := uuid.New()
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO users (id, email, password_hash, display_name, is_bot, bot_owner_id, is_verified)
+		VALUES ($1, $2, '!', $3, TRUE, $4, TRUE)
+	`, botID, botID.String()+"@bots.invalid", name, createdBy); err != nil {
+		return IncomingWebhook{}, err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO conversation_participants (conversation_id, user_id, joined_at, role)
+		VALUES ($1, $2, now(), $3)
+	`, convID, botID, RoleBot); err != nil {
+		return IncomingWebhook{}, err
+	}
+	h, err := scanIncomingWebhook(tx.QueryRow(ctx, `
+		INSERT INTO incoming_webhooks (conversation_id, bot_user_id, created_by, name, token_hash)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+incomingWebhookColumns, convID, botID, createdBy, name, tokenHash))
+	if err != nil {
+		return h, err
+	}
+	return h, tx.Commit(ctx)
+}
+
+// GetIncomingWebhooks lists the incoming webhooks of a conversation.
+func GetIncomingWebhooks(ctx context.Context, pool *pgxpool.Pool, convID uuid.UUID) ([]IncomingWebhook, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT `+incomingWebhookColumns+`
+		FROM incoming_webhooks
+		WHERE conversation_id = $1
+		ORDER BY created_at
+	`, convID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []IncomingWebhook{}
+	for rows.Next() {
+		h, err := scanIncomingWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, h)
+	}
+	return out, rows.Err()
+}
+
+// GetIncomingWebhook returns an incoming webhook by id.
+func GetIncomingWebhook(ctx context.Context, pool *pgxpool.Pool, id uuid.UUID) (IncomingWebhook, error) {
+	return scanIncomingWebhook(pool.QueryRow(ctx, `
+		SELECT `+incomingWebhookColumns+` FROM incoming_webhooks WHERE id = $1
+	`, id))
+}
+
+// GetActiveIncomingWebhookByTokenHash resolves the hook a request is posted to and marks it used.
+func GetActiveIncomingWebhookByTokenHash(ctx context.Context, pool *pgxpool.Pool, tokenHash string) (IncomingWebhook, error) {
+	return scanIncomingWebhook(pool.QueryRow(ctx, `
+		UPDATE incoming_webhooks SET last_used_at = now()
+		WHERE token_hash = $1 AND is_active
+		RETURNING `+incomingWebhookColumns, tokenHash))
+}
+
+// RotateIncomingWebhookToken replaces the token hash (the old URL stops working immediately).
+func RotateIncomingWebhookToken(ctx context.Context, pool *pgxpool.Pool, id uuid.UUID, tokenHash string) (IncomingWebhook, error) {
+	return scanIncomingWebhook(pool.QueryRow(ctx, `
+		UPDATE incoming_webhooks SET token_hash = $2, rotated_at = now()
+		WHERE id = $1
+		RETURNING `+incomingWebhookColumns, id, tokenHash))
+}
+
+// SetIncomingWebhookActive enables or disables a hook.
+func SetIncomingWebhookActive(ctx context.Context, pool *pgxpool.Pool, id uuid.UUID, active bool) (IncomingWebhook, error) {
+	return scanIncomingWebhook(pool.QueryRow(ctx, `
+		UPDATE incoming_webhooks SET is_active = $2
+		WHERE id = $1
+		RETURNING `+incomingWebhookColumns, id, active))
+}
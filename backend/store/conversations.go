@@ -0,0 +1,140 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ConversationUpdate changes the non-nil fields; an empty AvatarURL or Description clears it.
+type ConversationUpdate struct {
+	Title       *string
+	AvatarURL   *string
+	Description *string
+}
+
+// GetConversation returns a conversation's shared details (no per-user fields).
+func GetConversation(ctx context.Context, pool *pgxpool.Pool, convID uuid.UUID) (Conversation, error) {
+	var c Conversation
+	err := pool.QueryRow(ctx, `
+		SELECT id, title, is_group, created_at, avatar_url, description
+		FROM conversations WHERE id = $1
+	`, convID).Scan(&c.ID, &c.Title, &c.IsGroup, &c.CreatedAt, &c.AvatarURL, &c.Description)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c, ErrNotFound
+	}
+	return c, err
+}
+
+// UpdateConversation applies upd on behalf of actorID and writes one system message per changed
+// field ("Alice renamed the group to …"). Fields equal to their current value are ignored, so the
+// returned changes (the names of updated fields) and messages may be empty.
+func UpdateConversation(ctx context.Context, pool *pgxpool.Pool, convID, actorID uuid.UUID, upd ConversationUpdate) (Conversation, []string, []Message, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return Conversation{}, nil, nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var c Conversation
+	err = tx.QueryRow(ctx, `
+		SELECT id, title, is_group, created_at, avatar_url, description
+		FROM conversations WHERE id = $1
+		FOR UPDATE
+	`, convID).Scan(&c.ID, &c.Title, &c.IsGroup, &c.CreatedAt, &c.AvatarURL, &c.Description)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c, nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return c, nil, nil, err
+	}
+
+	var actor string
+	if err := tx.QueryRow(ctx, `SELECT coalesce(display_name, email) FROM users WHERE id = $1`, actorID).Scan(&actor); err != nil {
+		return c, nil, nil, err
+	}
+
+	type change struct {
+		field, text string
+	}
+	var changes []change
+	if upd.Title != nil && !sameString(c.Title, upd.Title) {
+		c.Title = nullIfEmpty(*upd.Title)
+		changes = append(changes, change{"title", fmt.Sprintf("%s renamed the group to “%s”", actor, *upd.Title)})
+	}
+	if upd.AvatarURL != nil && !sameString(c.AvatarURL, nullIfEmpty(*upd.AvatarURL)) {
+		c.AvatarURL = nullIfEmpty(*upd.AvatarURL)
+		text := actor + " changed the group photo"
+		if c.AvatarURL == nil {
+			text = actor + " removed the group photo"
+		}
+		changes = append(changes, change{"avatar_url", text})
+	}
+	if upd.Description != nil && !sameString(c.Description, nullIfEmpty(*upd.Description)) {
+		c.Description = nullIfEmpty(*upd.Description)
+		text := actor + " changed the group description"
+		if c.Description == nil {
+			text = actor + " removed the group description"
+		}
+		changes = append(changes, change{"description", text})
+	}
+	if len(changes) == 0 {
+		return c, nil, nil, nil
+	}
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE conversations SET title = $2, avatar_url = $3, description = $4, updated_at = now()
+		WHERE id = $1
+	`, c.ID, c.Title, c.AvatarURL, c.Description); err != nil {
+		return c, nil, nil, err
+	}
+
+	fields := make([]string, 0, len(changes))
+	ids := make([]uuid.UUID, 0, len(changes))
+	for _, ch := range changes {
+		var id uuid.UUID
+		if err := tx.QueryRow(ctx, `
+			INSERT INTO messages (id, conversation_id, author_id, body, message_type, metadata, created_at)
+			VALUES (gen_random_uuid(), $1, $2, $3, $4, jsonb_build_object('event', 'conversation_updated', 'field', $5::text), clock_timestamp())
+			RETURNING id
+		`, c.ID, actorID, ch.text, MessageTypeSystem, ch.field).Scan(&id); err != nil {
+			return c, nil, nil, err
+		}
+		if err := touchConversation(ctx, tx, id); err != nil {
+			return c, nil, nil, err
+		}
+		fields = append(fields, ch.field)
+		ids = append(ids, id)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return c, nil, nil, err
+	}
+
+	msgs := make([]Message, 0, len(ids))
+	for _, id := range ids {
+		m, err := GetMessage(ctx, pool, id)
+		if err != nil {
+			return c, fields, msgs, err
+		}
+		msgs = append(msgs, m)
+	}
+	return c, fields, msgs, nil
+}
+
+func nullIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func sameString(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
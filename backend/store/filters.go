@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// content filter modes: what a filter does with a message that matches it
+const (
+	FilterOff     = "off"
+	FilterFlag    = "flag"
+	FilterRewrite = "rewrite"
+	FilterReject  = "reject"
+)
+
+// ContentFilterSettings configure the outgoing message filters of a conversation.
+type ContentFilterSettings struct {
+	Profanity string `json:"profanity"`
+	Links     string `json:"links"`
+	Spam      string `json:"spam"`
+	// ExtraWords are added to the built-in profanity list
+	ExtraWords []string `json:"extra_words"`
+	// BlockedDomains are added to the instance-wide link blocklist
+	BlockedDomains []string   `json:"blocked_domains"`
+	UpdatedBy      *uuid.UUID `json:"updated_by,omitempty"`
+	UpdatedAt      *time.Time `json:"updated_at,omitempty"`
+}
+
+// DefaultContentFilterSettings apply to conversations nobody configured.
+func DefaultContentFilterSettings() ContentFilterSettings {
+	return ContentFilterSettings{
+		Profanity:      FilterOff,
+		Links:          FilterReject,
+		Spam:           FilterFlag,
+		ExtraWords:     []string{},
+		BlockedDomains: []string{},
+	}
+}
+
+// ContentFlag records why a filter flagged a message (kept in messages.metadata "flags").
+type ContentFlag struct {
+	Filter string `json:"filter"`
+	Reason string `json:"reason"`
+}
+
+// GetContentFilterSettings returns the conversation's filter settings (the defaults when unset).
+func GetContentFilterSettings(ctx context.Context, pool *pgxpool.Pool, convID uuid.UUID) (ContentFilterSettings, error) {
+	s := DefaultContentFilterSettings()
+	rows, err := pool.Query(ctx, `
+		SELECT profanity, links, spam, extra_words, blocked_domains, updated_by, updated_at
+		FROM conversation_content_filters
+		WHERE conversation_id = $1
+	`, convID)
+	if err != nil {
+		return s, err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		if err := rows.Scan(&s.Profanity, &s.Links, &s.Spam, &s.ExtraWords, &s.BlockedDomains, &s.UpdatedBy, &s.UpdatedAt); err != nil {
+			return s, err
+		}
+	}
+	return s, rows.Err()
+}
+
+// SaveContentFilterSettings stores the conversation's filter settings and returns them.
+func SaveContentFilterSettings(ctx context.Context, pool *pgxpool.Pool, convID, userID uuid.UUID, s ContentFilterSettings) (ContentFilterSettings, error) {
+	err := pool.QueryRow(ctx, `
+		INSERT INTO conversation_content_filters (conversation_id, profanity, links, spam, extra_words, blocked_domains, updated_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (conversation_id) DO UPDATE
+		SET profanity = EXCLUDED.profanity, links = EXCLUDED.links, spam = EXCLUDED.spam,
+			extra_words = EXCLUDED.extra_words, blocked_domains = EXCLUDED.blocked_domains,
+			updated_by = EXCLUDED.updated_by, updated_at = now()
+		RETURNING updated_by, updated_at
+	`, convID, s.Profanity, s.Links, s.Spam, s.ExtraWords, s.BlockedDomains, userID).Scan(&s.UpdatedBy, &s.UpdatedAt)
+	return s, err
+}
+
+// FlagMessage records content flags on a saved message and files a report about it (without a
+// reporter) so it shows up in the moderation queue.
+func FlagMessage(ctx context.Context, pool *pgxpool.Pool, m Message, reason string, flags []ContentFlag) error {
+	b, err := json.Marshal(flags)
+	if err != nil {
+		return err
+	}
+	parts := make([]string, len(flags))
+	for i, f := range flags {
+		parts[i] = f.Filter + ": " + f.Reason
+	}
+	details := strings.Join(parts, "; ")
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := tx.Exec(ctx, `
+		UPDATE messages SET metadata = jsonb_set(coalesce(metadata, '{}'), '{flags}', $2::jsonb)
+		WHERE id = $1
+	`, m.ID, string(b)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO reports (user_id, message_id, conversation_id, message_body, reason, details)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, m.AuthorID, m.ID, m.ConversationID, m.Body, reason, details); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
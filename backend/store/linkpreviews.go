@@ -0,0 +1,89 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// LinkPreview is the unfurled OpenGraph metadata of a URL in a message (kept in messages.metadata
+// "link_previews").
+type LinkPreview struct {
+	URL         string  `json:"url"`
+	Title       *string `json:"title,omitempty"`
+	Description *string `json:"description,omitempty"`
+	ImageURL    *string `json:"image_url,omitempty"`
+	SiteName    *string `json:"site_name,omitempty"`
+}
+
+// GetCachedLinkPreview returns the cached preview of url and whether the fetch succeeded.
+// Successful fetches are used for maxAge, failed ones for failedMaxAge; after that (or if url
+// was never fetched) it returns ErrNotFound and url has to be fetched again.
+func GetCachedLinkPreview(ctx context.Context, pool *pgxpool.Pool, url string, maxAge, failedMaxAge time.Duration) (LinkPreview, bool, error) {
+	p := LinkPreview{URL: url}
+	var ok bool
+	now := time.Now()
+	err := pool.QueryRow(ctx, `
+		SELECT ok, title, description, image_url, site_name
+		FROM link_previews
+		WHERE url = $1 AND fetched_at > CASE WHEN ok THEN $2::timestamptz ELSE $3::timestamptz END
+	`, url, now.Add(-maxAge), now.Add(-failedMaxAge)).Scan(&ok, &p.Title, &p.Description, &p.ImageURL, &p.SiteName)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return p, false, ErrNotFound
+	}
+	return p, ok, err
+}
+
+// SaveLinkPreview caches the result of fetching p.URL; ok = false records a failed fetch.
+func SaveLinkPreview(ctx context.Context, pool *pgxpool.Pool, p LinkPreview, ok bool) error {
+	_, err := pool.Exec(ctx, `
+		INSERT INTO link_previews (url, ok, title, description, image_url, site_name, fetched_at)
+		VALUES ($1, $2, $3, $4, $5, $6, now())
+		ON CONFLICT (url) DO UPDATE
+		SET ok = EXCLUDED.ok, title = EXCLUDED.title, description = EXCLUDED.description,
+			image_url = EXCLUDED.image_url, site_name = EXCLUDED.site_name, fetched_at = EXCLUDED.fetched_at
+	`, p.URL, ok, p.Title, p.Description, p.ImageURL, p.SiteName)
+	return err
+}
+
+// PruneLinkPreviews drops cache entries fetched before the cutoff.
+func PruneLinkPreviews(ctx context.Context, pool *pgxpool.Pool, before time.Time) (int64, error) {
+	tag, err := pool.Exec(ctx, `DELETE FROM link_previews WHERE fetched_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// SetMessageLinkPreviews stores the previews of a message (none removes them) and returns the
+// updated message. Deleted messages are left alone and reported as ErrNotFound.
+func SetMessageLinkPreviews(ctx context.Context, pool *pgxpool.Pool, messageID uuid.UUID, previews []LinkPreview) (Message, error) {
+	var value *string
+	if len(previews) > 0 {
+		b, err := json.Marshal(previews)
+		if err != nil {
+			return Message{}, err
+		}
+		v := string(b)
+		value = &v
+	}
+	tag, err := pool.Exec(ctx, `
+		UPDATE messages SET metadata = CASE
+			WHEN $2::jsonb IS NULL THEN coalesce(metadata, '{}') - 'link_previews'
+			ELSE jsonb_set(coalesce(metadata, '{}'), '{link_previews}', $2::jsonb)
+		END
+		WHERE id = $1 AND NOT is_deleted
+	`, messageID, value)
+	if err != nil {
+		return Message{}, err
+	}
+	if tag.RowsAffected() == 0 {
+		return Message{}, ErrNotFound
+	}
+	return GetMessage(ctx, pool, messageID)
+}
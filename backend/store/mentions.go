@@ -0,0 +1,134 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// mention kinds
+const (
+	MentionUser = "user"
+	MentionHere = "here"
+	MentionAll  = "all"
+)
+
+// Participant is a conversation member as needed to resolve mentions.
+type Participant struct {
+	UserID      uuid.UUID `json:"user_id"`
+	DisplayName *string   `json:"display_name,omitempty"`
+	Role        string    `json:"role"`
+	// Muted is true while the participant has the conversation muted
+	Muted bool `json:"muted"`
+}
+
+// Mention is an entry of a user's mention inbox.
+type Mention struct {
+	Message           Message    `json:"message"`
+	Kind              string     `json:"kind"`
+	ConversationTitle *string    `json:"conversation_title,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+	ReadAt            *time.Time `json:"read_at,omitempty"`
+}
+
+// GetParticipants lists the members of a conversation and whether it is a group.
+func GetParticipants(ctx context.Context, pool *pgxpool.Pool, convID uuid.UUID) ([]Participant, bool, error) {
+	var isGroup bool
+	if err := pool.QueryRow(ctx, `SELECT is_group FROM conversations WHERE id = $1`, convID).Scan(&isGroup); err != nil {
+		return nil, false, err
+	}
+	rows, err := pool.Query(ctx, `
+		SELECT cp.user_id, u.display_name, coalesce(cp.role, 'member'), `+mutedExpr+`
+		FROM conversation_participants cp
+		JOIN users u ON u.id = cp.user_id
+		WHERE cp.conversation_id = $1
+		ORDER BY cp.joined_at
+	`, convID)
+	if err != nil {
+		return nil, isGroup, err
+	}
+	defer rows.Close()
+	var out []Participant
+	for rows.Next() {
+		var p Participant
+		if err := rows.Scan(&p.UserID, &p.DisplayName, &p.Role, &p.Muted); err != nil {
+			return nil, isGroup, err
+		}
+		out = append(out, p)
+	}
+	return out, isGroup, rows.Err()
+}
+
+// SaveMentions stores the mentions of a message (user id -> kind); existing rows are kept.
+func SaveMentions(ctx context.Context, pool *pgxpool.Pool, m Message, kinds map[uuid.UUID]string) error {
+	if len(kinds) == 0 {
+		return nil
+	}
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	for uid, kind := range kinds {
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO message_mentions (message_id, user_id, conversation_id, kind, created_at)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT DO NOTHING
+		`, m.ID, uid, m.ConversationID, kind, m.CreatedAt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// GetMentions returns a user's mentions, newest first. before is the keyset cursor (exclusive).
+func GetMentions(ctx context.Context, pool *pgxpool.Pool, userID uuid.UUID, unreadOnly bool, beforeTime *time.Time, beforeID *uuid.UUID, limit int) ([]Mention, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	rows, err := pool.Query(ctx, `
+		SELECT `+messageColumns+`, mm.kind, c.title, mm.created_at, mm.read_at
+		FROM message_mentions mm
+		JOIN messages m ON m.id = mm.message_id
+		JOIN conversations c ON c.id = mm.conversation_id
+		JOIN conversation_participants cp ON cp.conversation_id = mm.conversation_id AND cp.user_id = mm.user_id
+		`+messageJoins+`
+		WHERE mm.user_id = $1
+			AND (NOT $2 OR mm.read_at IS NULL)
+			AND `+notBlockedBy("$1", "m.author_id")+`
+			AND ($3::timestamptz IS NULL OR (mm.created_at, mm.message_id) < ($3, $4::uuid))
+		ORDER BY mm.created_at DESC, mm.message_id DESC
+		LIMIT $5
+	`, userID, unreadOnly, beforeTime, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Mention{}
+	for rows.Next() {
+		var mn Mention
+		m, err := scanMessage(rows, &mn.Kind, &mn.ConversationTitle, &mn.CreatedAt, &mn.ReadAt)
+		if err != nil {
+			return nil, err
+		}
+		mn.Message = m
+		out = append(out, mn)
+	}
+	return out, rows.Err()
+}
+
+// MarkMentionsRead marks the given mentions (or all of them when messageIDs is empty) as read.
+func MarkMentionsRead(ctx context.Context, pool *pgxpool.Pool, userID uuid.UUID, messageIDs []uuid.UUID) (int64, error) {
+	tag, err := pool.Exec(ctx, `
+		UPDATE message_mentions SET read_at = now()
+		WHERE user_id = $1 AND read_at IS NULL
+			AND (cardinality($2::uuid[]) = 0 OR message_id = ANY($2))
+	`, userID, messageIDs)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,232 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// validation errors for replies
+var (
+	ErrInvalidParent  = errors.New("parent message not found in this conversation")
+	ErrInvalidReplyTo = errors.New("quoted message not found in this conversation")
+)
+
+// length (in characters) of the quoted body in a MessagePreview
+const previewLength = 200
+
+// MessagePreview is the embedded summary of a quoted message. Body is empty once the message is deleted.
+type MessagePreview struct {
+	ID         uuid.UUID  `json:"id"`
+	AuthorID   *uuid.UUID `json:"author_id,omitempty"`
+	AuthorName *string    `json:"author_name,omitempty"`
+	Body       *string    `json:"body,omitempty"`
+	IsDeleted  bool       `json:"is_deleted"`
+	EditedAt   *time.Time `json:"edited_at,omitempty"`
+}
+
+// messageColumns selects a Message from "messages m" + messageJoins. The quoted body is
+// fetched with one character more than previewLength so truncation can be detected.
+var messageColumns = `m.id, m.conversation_id, m.author_id, m.body, m.message_type,
+	m.parent_message_id, m.reply_count, m.last_reply_at, m.edited_at, m.is_deleted, m.created_at,
+	u.display_name, u.avatar_url,
+	m.reply_to_id, q.author_id, qu.display_name, CASE WHEN q.is_deleted THEN NULL ELSE left(q.body, ` + strconv.Itoa(previewLength+1) + `) END,
+	q.is_deleted, q.edited_at,
+	EXISTS (SELECT 1 FROM pinned_messages pin WHERE pin.message_id = m.id),
+	CASE WHEN m.is_deleted THEN NULL ELSE m.metadata->'link_previews' END`
+
+// messageJoins adds the author and the quoted message (with its author) to "messages m".
+const messageJoins = `LEFT JOIN users u ON u.id = m.author_id
+	LEFT JOIN messages q ON q.id = m.reply_to_id
+	LEFT JOIN users qu ON qu.id = q.author_id`
+
+// scanMessage scans messageColumns followed by any extra columns into extra.
+func scanMessage(row pgx.Row, extra ...any) (Message, error) {
+	var m Message
+	var p MessagePreview
+	var qDeleted *bool
+	dest := []any{&m.ID, &m.ConversationID, &m.AuthorID, &m.Body, &m.MessageType,
+		&m.ParentMessageID, &m.ReplyCount, &m.LastReplyAt, &m.EditedAt, &m.IsDeleted, &m.CreatedAt,
+		&m.AuthorName, &m.AuthorAvatar,
+		&m.ReplyToID, &p.AuthorID, &p.AuthorName, &p.Body, &qDeleted, &p.EditedAt,
+		&m.Pinned, &m.LinkPreviews}
+	err := row.Scan(append(dest, extra...)...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return m, ErrNotFound
+	}
+	if err != nil {
+		return m, err
+	}
+	if m.ReplyToID != nil && qDeleted != nil {
+		p.ID = *m.ReplyToID
+		p.IsDeleted = *qDeleted
+		if p.Body != nil {
+			if r := []rune(*p.Body); len(r) > previewLength {
+				b := string(r[:previewLength]) + "…"
+				p.Body = &b
+			}
+		}
+		m.ReplyTo = &p
+	}
+	return m, nil
+}
+
+// GetMessage returns a single message (with author info and attachments).
+func GetMessage(ctx context.Context, pool *pgxpool.Pool, id uuid.UUID) (Message, error) {
+	m, err := scanMessage(pool.QueryRow(ctx, `
+		SELECT `+messageColumns+`
+		FROM messages m
+		`+messageJoins+`
+		WHERE m.id = $1
+	`, id))
+	if err != nil {
+		return m, err
+	}
+	msgs := []Message{m}
+	if err := attachAttachments(ctx, pool, msgs); err != nil {
+		return m, err
+	}
+	return msgs[0], nil
+}
+
+// SaveQuotedMessage inserts a message quoting replyToID, which must belong to the same conversation.
+func SaveQuotedMessage(ctx context.Context, pool *pgxpool.Pool, convID, authorID, replyToID uuid.UUID, body string) (Message, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return Message{}, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var id uuid.UUID
+	err = tx.QueryRow(ctx, `
+		INSERT INTO messages (id, conversation_id, author_id, body, message_type, reply_to_id, created_at)
+		SELECT gen_random_uuid(), $1, $2, $3, 'text', $4, now()
+		WHERE EXISTS (SELECT 1 FROM messages WHERE id = $4 AND conversation_id = $1)
+		RETURNING id
+	`, convID, authorID, body, replyToID).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Message{}, ErrInvalidReplyTo
+	}
+	if err != nil {
+		return Message{}, err
+	}
+	if err := touchConversation(ctx, tx, id); err != nil {
+		return Message{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Message{}, err
+	}
+	return GetMessage(ctx, pool, id)
+}
+
+// SaveThreadReply inserts a reply to parentID and bumps the parent's reply counters atomically.
+// Replies stay out of the timeline, so they don't change the conversation's last message.
+// Replies to a reply are attached to the thread's root. replyToID optionally quotes a message of
+// the same conversation. Returns the reply and the updated root.
+func SaveThreadReply(ctx context.Context, pool *pgxpool.Pool, convID, authorID, parentID uuid.UUID, replyToID *uuid.UUID, body string) (Message, Message, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return Message{}, Message{}, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// locking the root so concurrent replies don't race on the counters
+	var rootID uuid.UUID
+	err = tx.QueryRow(ctx, `
+		SELECT coalesce(p.parent_message_id, p.id)
+		FROM messages p
+		WHERE p.id = $1 AND p.conversation_id = $2
+	`, parentID, convID).Scan(&rootID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Message{}, Message{}, ErrInvalidParent
+	}
+	if err != nil {
+		return Message{}, Message{}, err
+	}
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM messages WHERE id = $1 FOR UPDATE`, rootID); err != nil {
+		return Message{}, Message{}, err
+	}
+
+	if replyToID != nil {
+		var ok bool
+		if err := tx.QueryRow(ctx, `
+			SELECT EXISTS (SELECT 1 FROM messages WHERE id = $1 AND conversation_id = $2)
+		`, *replyToID, convID).Scan(&ok); err != nil {
+			return Message{}, Message{}, err
+		}
+		if !ok {
+			return Message{}, Message{}, ErrInvalidReplyTo
+		}
+	}
+
+	var replyID uuid.UUID
+	var createdAt time.Time
+	if err := tx.QueryRow(ctx, `
+		INSERT INTO messages (id, conversation_id, author_id, body, message_type, parent_message_id, reply_to_id, created_at)
+		VALUES (gen_random_uuid(), $1, $2, $3, 'text', $4, $5, now())
+		RETURNING id, created_at
+	`, convID, authorID, body, rootID, replyToID).Scan(&replyID, &createdAt); err != nil {
+		return Message{}, Message{}, err
+	}
+	if _, err := tx.Exec(ctx, `
+		UPDATE messages SET reply_count = reply_count + 1, last_reply_at = $2 WHERE id = $1
+	`, rootID, createdAt); err != nil {
+		return Message{}, Message{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Message{}, Message{}, err
+	}
+
+	reply, err := GetMessage(ctx, pool, replyID)
+	if err != nil {
+		return reply, Message{}, err
+	}
+	root, err := GetMessage(ctx, pool, rootID)
+	return reply, root, err
+}
+
+// GetThreadReplies returns the replies of a thread root (oldest first), with reactions as seen by viewerID.
+// Replies by users viewerID has blocked are left out.
+func GetThreadReplies(ctx context.Context, pool *pgxpool.Pool, rootID, viewerID uuid.UUID, limit int) ([]Message, error) {
+	if limit <= 0 {
+		limit = 200
+	}
+	rows, err := pool.Query(ctx, `
+		SELECT `+messageColumns+`
+		FROM messages m
+		`+messageJoins+`
+		WHERE m.parent_message_id = $1 AND `+notBlockedBy("$3", "m.author_id")+`
+		ORDER BY m.created_at ASC, m.id ASC
+		LIMIT $2
+	`, rootID, limit, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Message{}
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := attachAttachments(ctx, pool, out); err != nil {
+		return nil, err
+	}
+	if err := AttachReactions(ctx, pool, out, viewerID); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,387 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// report reasons
+const (
+	ReportSpam          = "spam"
+	ReportHarassment    = "harassment"
+	ReportHate          = "hate"
+	ReportInappropriate = "inappropriate"
+	ReportOther         = "other"
+)
+
+// report statuses
+const (
+	ReportOpen      = "open"
+	ReportResolved  = "resolved"
+	ReportDismissed = "dismissed"
+)
+
+// moderator actions taken on a report
+const (
+	ActionDeleteMessage = "delete_message"
+	ActionSuspendUser   = "suspend_user"
+	ActionDismiss       = "dismiss"
+)
+
+// admin actions on accounts, recorded in the same audit trail
+const (
+	ActionDeactivateUser = "deactivate_user"
+	ActionReactivateUser = "reactivate_user"
+	ActionRevokeSessions = "revoke_sessions"
+	ActionGrantAdmin     = "grant_admin"
+	ActionRevokeAdmin    = "revoke_admin"
+)
+
+var (
+	ErrDuplicateReport = errors.New("already reported")
+	ErrSelfReport      = errors.New("you can't report yourself")
+	ErrReportClosed    = errors.New("report is already closed")
+	// ErrActionNotApplicable is returned for delete_message on a user report (or a message that is gone)
+	ErrActionNotApplicable = errors.New("action does not apply to this report")
+)
+
+// IsReportReason reports whether reason is one of the known report reasons.
+func IsReportReason(reason string) bool {
+	switch reason {
+	case ReportSpam, ReportHarassment, ReportHate, ReportInappropriate, ReportOther:
+		return true
+	}
+	return false
+}
+
+// Report is an abuse report about a message (MessageID set) or a user.
+type Report struct {
+	ID             uuid.UUID  `json:"id"`
+	ReporterID     *uuid.UUID `json:"reporter_id,omitempty"`
+	ReporterName   *string    `json:"reporter_name,omitempty"`
+	UserID         *uuid.UUID `json:"user_id,omitempty"`
+	UserName       *string    `json:"user_name,omitempty"`
+	UserActive     bool       `json:"user_active"`
+	MessageID      *uuid.UUID `json:"message_id,omitempty"`
+	ConversationID *uuid.UUID `json:"conversation_id,omitempty"`
+	// MessageBody is the message as it was when reported
+	MessageBody *string    `json:"message_body,omitempty"`
+	Reason      string     `json:"reason"`
+	Details     *string    `json:"details,omitempty"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
+	ResolvedBy  *uuid.UUID `json:"resolved_by,omitempty"`
+}
+
+// NewReport is the input of CreateReport: either MessageID or UserID is set.
+type NewReport struct {
+	ReporterID uuid.UUID
+	MessageID  *uuid.UUID
+	UserID     *uuid.UUID
+	Reason     string
+	Details    *string
+}
+
+// ModerationAction is an entry of the moderators' audit trail.
+type ModerationAction struct {
+	ID            uuid.UUID  `json:"id"`
+	ModeratorID   *uuid.UUID `json:"moderator_id,omitempty"`
+	ModeratorName *string    `json:"moderator_name,omitempty"`
+	Action        string     `json:"action"`
+	ReportID      *uuid.UUID `json:"report_id,omitempty"`
+	TargetUserID  *uuid.UUID `json:"target_user_id,omitempty"`
+	MessageID     *uuid.UUID `json:"message_id,omitempty"`
+	Note          *string    `json:"note,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// ModerationResult is what an action changed: the acted-on report, every report it closed,
+// and the deleted message when the action was delete_message.
+type ModerationResult struct {
+	Report        Report           `json:"report"`
+	Action        ModerationAction `json:"action"`
+	ClosedReports int              `json:"closed_reports"`
+	Message       *Message         `json:"-"`
+	// Attachments were removed with a deleted message; the caller deletes their blobs.
+	Attachments []Attachment `json:"-"`
+}
+
+const reportColumns = `r.id, r.reporter_id, ru.display_name, r.user_id, tu.display_name, coalesce(tu.is_active, false),
+	r.message_id, r.conversation_id, r.message_body, r.reason, r.details, r.status,
+	r.created_at, r.resolved_at, r.resolved_by`
+
+const reportJoins = `LEFT JOIN users ru ON ru.id = r.reporter_id
+	LEFT JOIN users tu ON tu.id = r.user_id`
+
+func scanReport(row pgx.Row) (Report, error) {
+	var r Report
+	err := row.Scan(&r.ID, &r.ReporterID, &r.ReporterName, &r.UserID, &r.UserName, &r.UserActive,
+		&r.MessageID, &r.ConversationID, &r.MessageBody, &r.Reason, &r.Details, &r.Status,
+		&r.CreatedAt, &r.ResolvedAt, &r.ResolvedBy)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return r, ErrNotFound
+	}
+	return r, err
+}
+
+// IsUserActive reports whether the user's account is active (not suspended); ErrNotFound for unknown users.
+func IsUserActive(ctx context.Context, pool *pgxpool.Pool, userID uuid.UUID) (bool, error) {
+	var active bool
+	err := pool.QueryRow(ctx, `SELECT is_active FROM users WHERE id = $1`, userID).Scan(&active)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, ErrNotFound
+	}
+	return active, err
+}
+
+// CreateReport files a report. A reported message must be visible to the reporter (ErrNotFound
+// otherwise) and its author becomes the reported user. Reports about oneself fail with
+// ErrSelfReport, and ErrDuplicateReport is returned while the reporter already has an open
+// report about the same target.
+func CreateReport(ctx context.Context, pool *pgxpool.Pool, in NewReport) (Report, error) {
+	var userID uuid.UUID
+	var convID *uuid.UUID
+	var body *string
+	if in.MessageID != nil {
+		var author *uuid.UUID
+		var cid uuid.UUID
+		err := pool.QueryRow(ctx, `
+			SELECT m.author_id, m.conversation_id, m.body
+			FROM messages m
+			JOIN conversation_participants cp ON cp.conversation_id = m.conversation_id AND cp.user_id = $2
+			WHERE m.id = $1 AND NOT m.is_deleted
+		`, *in.MessageID, in.ReporterID).Scan(&author, &cid, &body)
+		if errors.Is(err, pgx.ErrNoRows) || (err == nil && author == nil) {
+			return Report{}, ErrNotFound
+		}
+		if err != nil {
+			return Report{}, err
+		}
+		userID, convID = *author, &cid
+	} else if in.UserID != nil {
+		var exists bool
+		if err := pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, *in.UserID).Scan(&exists); err != nil {
+			return Report{}, err
+		}
+		if !exists {
+			return Report{}, ErrNotFound
+		}
+		userID = *in.UserID
+	} else {
+		return Report{}, ErrNotFound
+	}
+	if userID == in.ReporterID {
+		return Report{}, ErrSelfReport
+	}
+
+	var id uuid.UUID
+	err := pool.QueryRow(ctx, `
+		INSERT INTO reports (reporter_id, user_id, message_id, conversation_id, message_body, reason, details)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (reporter_id, user_id, coalesce(message_id, '00000000-0000-0000-0000-000000000000'::uuid))
+			WHERE status = 'open' DO NOTHING
+		RETURNING id
+	`, in.ReporterID, userID, in.MessageID, convID, body, in.Reason, in.Details).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Report{}, ErrDuplicateReport
+	}
+	if err != nil {
+		return Report{}, err
+	}
+	return GetReport(ctx, pool, id)
+}
+
+// GetReport returns a single report.
+func GetReport(ctx context.Context, pool *pgxpool.Pool, id uuid.UUID) (Report, error) {
+	return scanReport(pool.QueryRow(ctx, `SELECT `+reportColumns+` FROM reports r `+reportJoins+` WHERE r.id = $1`, id))
+}
+
+// GetReports lists reports with the given status ("" for all), newest first, keyset-paged
+// by (created_at, id).
+func GetReports(ctx context.Context, pool *pgxpool.Pool, status string, beforeTime *time.Time, beforeID *uuid.UUID, limit int) ([]Report, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT `+reportColumns+`
+		FROM reports r
+		`+reportJoins+`
+		WHERE ($1 = '' OR r.status = $1)
+			AND ($2::timestamptz IS NULL OR (r.created_at, r.id) < ($2, $3))
+		ORDER BY r.created_at DESC, r.id DESC
+		LIMIT $4
+	`, status, beforeTime, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Report{}
+	for rows.Next() {
+		r, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// ApplyModerationAction takes action on an open report and records it in the audit trail:
+//   - delete_message soft-deletes the reported message and closes every open report about it;
+//   - suspend_user deactivates the reported user, revokes their refresh tokens and closes
+//     every open report about them;
+//   - dismiss closes only this report.
+func ApplyModerationAction(ctx context.Context, pool *pgxpool.Pool, reportID, moderatorID uuid.UUID, action string, note *string) (ModerationResult, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return ModerationResult{}, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var status string
+	var userID, messageID *uuid.UUID
+	err = tx.QueryRow(ctx, `SELECT status, user_id, message_id FROM reports WHERE id = $1 FOR UPDATE`, reportID).
+		Scan(&status, &userID, &messageID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ModerationResult{}, ErrNotFound
+	}
+	if err != nil {
+		return ModerationResult{}, err
+	}
+	if status != ReportOpen {
+		return ModerationResult{}, ErrReportClosed
+	}
+
+	var res ModerationResult
+	var closed int64
+	switch action {
+	case ActionDeleteMessage:
+		if messageID == nil {
+			return ModerationResult{}, ErrActionNotApplicable
+		}
+		tag, err := tx.Exec(ctx, `UPDATE messages SET is_deleted = true, body = NULL WHERE id = $1 AND NOT is_deleted`, *messageID)
+		if err != nil {
+			return ModerationResult{}, err
+		}
+		if tag.RowsAffected() == 0 {
+			return ModerationResult{}, ErrActionNotApplicable
+		}
+		// the files go with the text (thumbnails cascade)
+		rows, err := tx.Query(ctx, `DELETE FROM attachments WHERE message_id = $1 RETURNING `+attachmentColumns, *messageID)
+		if err != nil {
+			return ModerationResult{}, err
+		}
+		for rows.Next() {
+			a, err := scanAttachment(rows)
+			if err != nil {
+				rows.Close()
+				return ModerationResult{}, err
+			}
+			res.Attachments = append(res.Attachments, a)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return ModerationResult{}, err
+		}
+		// the conversation list must not keep showing the removed text
+		if _, err := tx.Exec(ctx, `UPDATE conversations SET last_message_preview = NULL WHERE last_message_id = $1`, *messageID); err != nil {
+			return ModerationResult{}, err
+		}
+		tag, err = tx.Exec(ctx, `
+			UPDATE reports SET status = 'resolved', resolved_at = now(), resolved_by = $2
+			WHERE message_id = $1 AND status = 'open'
+		`, *messageID, moderatorID)
+		if err != nil {
+			return ModerationResult{}, err
+		}
+		closed = tag.RowsAffected()
+
+	case ActionSuspendUser:
+		if userID == nil {
+			return ModerationResult{}, ErrActionNotApplicable
+		}
+		if _, err := tx.Exec(ctx, `UPDATE users SET is_active = false, updated_at = now() WHERE id = $1`, *userID); err != nil {
+			return ModerationResult{}, err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE refresh_tokens SET revoked = true WHERE user_id = $1 AND NOT revoked`, *userID); err != nil {
+			return ModerationResult{}, err
+		}
+		tag, err := tx.Exec(ctx, `
+			UPDATE reports SET status = 'resolved', resolved_at = now(), resolved_by = $2
+			WHERE user_id = $1 AND status = 'open'
+		`, *userID, moderatorID)
+		if err != nil {
+			return ModerationResult{}, err
+		}
+		closed = tag.RowsAffected()
+
+	case ActionDismiss:
+		if _, err := tx.Exec(ctx, `
+			UPDATE reports SET status = 'dismissed', resolved_at = now(), resolved_by = $2 WHERE id = $1
+		`, reportID, moderatorID); err != nil {
+			return ModerationResult{}, err
+		}
+		closed = 1
+
+	default:
+		return ModerationResult{}, ErrActionNotApplicable
+	}
+
+	a := ModerationAction{ModeratorID: &moderatorID, Action: action, ReportID: &reportID, TargetUserID: userID, Note: note}
+	if action == ActionDeleteMessage {
+		a.MessageID = messageID
+	}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO moderation_actions (moderator_id, action, report_id, target_user_id, message_id, note)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`, moderatorID, action, reportID, a.TargetUserID, a.MessageID, note).Scan(&a.ID, &a.CreatedAt)
+	if err != nil {
+		return ModerationResult{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return ModerationResult{}, err
+	}
+
+	res.Action = a
+	res.ClosedReports = int(closed)
+	if res.Report, err = GetReport(ctx, pool, reportID); err != nil {
+		return res, err
+	}
+	if action == ActionDeleteMessage {
+		m, err := GetMessage(ctx, pool, *messageID)
+		if err != nil {
+			return res, err
+		}
+		res.Message = &m
+	}
+	return res, nil
+}
+
+// GetModerationActions lists the audit trail, newest first, keyset-paged by (created_at, id).
+func GetModerationActions(ctx context.Context, pool *pgxpool.Pool, beforeTime *time.Time, beforeID *uuid.UUID, limit int) ([]ModerationAction, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT a.id, a.moderator_id, u.display_name, a.action, a.report_id, a.target_user_id, a.message_id, a.note, a.created_at
+		FROM moderation_actions a
+		LEFT JOIN users u ON u.id = a.moderator_id
+		WHERE ($1::timestamptz IS NULL OR (a.created_at, a.id) < ($1, $2))
+		ORDER BY a.created_at DESC, a.id DESC
+		LIMIT $3
+	`, beforeTime, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []ModerationAction{}
+	for rows.Next() {
+		var a ModerationAction
+		if err := rows.Scan(&a.ID, &a.ModeratorID, &a.ModeratorName, &a.Action, &a.ReportID, &a.TargetUserID, &a.MessageID, &a.Note, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
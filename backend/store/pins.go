@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrPinLimit is returned when a conversation already has the maximum number of pins.
+var ErrPinLimit = errors.New("pin limit reached")
+
+// PinnedMessage is an entry of a conversation's pinned list.
+type PinnedMessage struct {
+	Message      Message    `json:"message"`
+	PinnedBy     *uuid.UUID `json:"pinned_by,omitempty"`
+	PinnedByName *string    `json:"pinned_by_name,omitempty"`
+	PinnedAt     time.Time  `json:"pinned_at"`
+}
+
+// GetParticipantRole returns the user's role in the conversation and whether it is a group;
+// ErrNotFound when the user is not a participant.
+func GetParticipantRole(ctx context.Context, pool *pgxpool.Pool, convID, userID uuid.UUID) (string, bool, error) {
+	var role string
+	var isGroup bool
+	err := pool.QueryRow(ctx, `
+		SELECT coalesce(cp.role, 'member'), c.is_group
+		FROM conversation_participants cp
+		JOIN conversations c ON c.id = cp.conversation_id
+		WHERE cp.conversation_id = $1 AND cp.user_id = $2
+	`, convID, userID).Scan(&role, &isGroup)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", false, ErrNotFound
+	}
+	return role, isGroup, err
+}
+
+// PinMessage pins a top-level message of its conversation unless it already is; the
+// conversation row is locked so concurrent pins can't exceed limit. changed is false
+// when the message was pinned before.
+func PinMessage(ctx context.Context, pool *pgxpool.Pool, m Message, userID uuid.UUID, limit int) (bool, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM conversations WHERE id = $1 FOR UPDATE`, m.ConversationID); err != nil {
+		return false, err
+	}
+	var count int
+	var pinned bool
+	if err := tx.QueryRow(ctx, `
+		SELECT count(*), coalesce(bool_or(message_id = $2), false)
+		FROM pinned_messages WHERE conversation_id = $1
+	`, m.ConversationID, m.ID).Scan(&count, &pinned); err != nil {
+		return false, err
+	}
+	if pinned {
+		return false, nil
+	}
+	if count >= limit {
+		return false, ErrPinLimit
+	}
+	if _, err := tx.Exec(ctx, `
+		INSERT INTO pinned_messages (message_id, conversation_id, pinned_by, pinned_at)
+		VALUES ($1, $2, $3, now())
+	`, m.ID, m.ConversationID, userID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+// UnpinMessage removes a pin; changed is false when the message wasn't pinned.
+func UnpinMessage(ctx context.Context, pool *pgxpool.Pool, msgID uuid.UUID) (bool, error) {
+	tag, err := pool.Exec(ctx, `DELETE FROM pinned_messages WHERE message_id = $1`, msgID)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetPinnedMessages lists a conversation's pins, most recently pinned first.
+func GetPinnedMessages(ctx context.Context, pool *pgxpool.Pool, convID, viewerID uuid.UUID) ([]PinnedMessage, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT `+messageColumns+`, pm.pinned_by, pu.display_name, pm.pinned_at
+		FROM pinned_messages pm
+		JOIN messages m ON m.id = pm.message_id
+		LEFT JOIN users pu ON pu.id = pm.pinned_by
+		`+messageJoins+`
+		WHERE pm.conversation_id = $1
+		ORDER BY pm.pinned_at DESC
+	`, convID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []PinnedMessage{}
+	var msgs []Message
+	for rows.Next() {
+		var p PinnedMessage
+		m, err := scanMessage(rows, &p.PinnedBy, &p.PinnedByName, &p.PinnedAt)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+		msgs = append(msgs, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := attachAttachments(ctx, pool, msgs); err != nil {
+		return nil, err
+	}
+	if err := AttachReactions(ctx, pool, msgs, viewerID); err != nil {
+		return nil, err
+	}
+	for i := range out {
+		out[i].Message = msgs[i]
+	}
+	return out, nil
+}
@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Reaction is the aggregate of one emoji on a message; Reacted is whether the viewer used it.
+type Reaction struct {
+	Emoji   string `json:"emoji"`
+	Count   int    `json:"count"`
+	Reacted bool   `json:"reacted"`
+}
+
+// AddReaction records a reaction; added is false when the user had already reacted with that emoji.
+func AddReaction(ctx context.Context, pool *pgxpool.Pool, msgID, userID uuid.UUID, emoji string) (bool, error) {
+	tag, err := pool.Exec(ctx, `
+		INSERT INTO message_reactions (message_id, user_id, emoji)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`, msgID, userID, emoji)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RemoveReaction deletes a reaction; removed is false when there was none.
+func RemoveReaction(ctx context.Context, pool *pgxpool.Pool, msgID, userID uuid.UUID, emoji string) (bool, error) {
+	tag, err := pool.Exec(ctx, `
+		DELETE FROM message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3
+	`, msgID, userID, emoji)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// CountReactions returns the number of users who reacted with emoji to a message.
+func CountReactions(ctx context.Context, pool *pgxpool.Pool, msgID uuid.UUID, emoji string) (int, error) {
+	var n int
+	err := pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM message_reactions WHERE message_id = $1 AND emoji = $2
+	`, msgID, emoji).Scan(&n)
+	return n, err
+}
+
+// AttachReactions loads the aggregated reactions of msgs as seen by viewerID (in order of first use).
+func AttachReactions(ctx context.Context, pool *pgxpool.Pool, msgs []Message, viewerID uuid.UUID) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	idx := make(map[uuid.UUID]int, len(msgs))
+	ids := make([]uuid.UUID, 0, len(msgs))
+	for i, m := range msgs {
+		idx[m.ID] = i
+		ids = append(ids, m.ID)
+	}
+	rows, err := pool.Query(ctx, `
+		SELECT message_id, emoji, COUNT(*), bool_or(user_id = $2)
+		FROM message_reactions
+		WHERE message_id = ANY($1)
+		GROUP BY message_id, emoji
+		ORDER BY message_id, MIN(created_at)
+	`, ids, viewerID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uuid.UUID
+		var r Reaction
+		if err := rows.Scan(&id, &r.Emoji, &r.Count, &r.Reacted); err != nil {
+			return err
+		}
+		i := idx[id]
+		msgs[i].Reactions = append(msgs[i].Reactions, r)
+	}
+	return rows.Err()
+}
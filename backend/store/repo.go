@@ -6,9 +6,18 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// participant roles
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+	RoleBot    = "bot"
+)
+
 // validation errors
 var (
 	ErrDirectConversationsExists = fmt.Errorf("direct conversation already exists")
@@ -21,28 +30,89 @@ type Conversation struct {
 	IsGroup     bool      `json:"is_group"`
 	CreatedAt   time.Time `json:"created_at"`
 	DisplayName *string   `json:"display_name,omitempty"`
+	AvatarURL   *string   `json:"avatar_url,omitempty"`
+	Description *string   `json:"description,omitempty"`
+
+	// last top-level message (denormalized on save) and the caller's unread count
+	LastMessageAt         *time.Time `json:"last_message_at,omitempty"`
+	LastMessageID         *uuid.UUID `json:"last_message_id,omitempty"`
+	LastMessageAuthorID   *uuid.UUID `json:"last_message_author_id,omitempty"`
+	LastMessageAuthorName *string    `json:"last_message_author_name,omitempty"`
+	LastMessagePreview    *string    `json:"last_message_preview,omitempty"`
+	UnreadCount           int        `json:"unread_count"`
+
+	// the caller's own settings (mute, archive, pin to top)
+	ConversationSettings
+}
+
+// ConversationListParams filters and pages GetConversationsForUser.
+type ConversationListParams struct {
+	UserID uuid.UUID
+	// Archived selects archived (true) or active (false) conversations; nil lists both
+	Archived *bool
+	// keyset cursor (exclusive) of the previous page; pinned conversations only come on the first page
+	BeforeTime *time.Time
+	BeforeID   *uuid.UUID
+	Limit      int
+}
+
+// ActivityAt is the time the conversation list is ordered by: the last message, or the creation time.
+func (c Conversation) ActivityAt() time.Time {
+	if c.LastMessageAt != nil {
+		return *c.LastMessageAt
+	}
+	return c.CreatedAt
 }
 
+// length (in characters) of conversations.last_message_preview
+const conversationPreviewLength = 140
+
 type Message struct {
 	ID             uuid.UUID `json:"id"`
 	ConversationID uuid.UUID `json:"conversation_id"`
 	AuthorID       uuid.UUID `json:"author_id"`
 	Body           *string   `json:"body,omitempty"`
+	MessageType    string    `json:"message_type"`
 	CreatedAt      time.Time `json:"created_at"`
 
+	// threads: replies point at their root; roots carry the reply counters
+	ParentMessageID *uuid.UUID `json:"parent_message_id,omitempty"`
+	ReplyCount      int        `json:"reply_count"`
+	LastReplyAt     *time.Time `json:"last_reply_at,omitempty"`
+
+	// quoted replies: ReplyTo is the quoted message's current preview
+	ReplyToID *uuid.UUID      `json:"reply_to_id,omitempty"`
+	ReplyTo   *MessagePreview `json:"reply_to,omitempty"`
+
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	IsDeleted bool       `json:"is_deleted,omitempty"`
+	Pinned    bool       `json:"pinned,omitempty"`
+
 	// author info for convenience
 	AuthorName   *string `json:"author_name,omitempty"`
 	AuthorAvatar *string `json:"author_avatar,omitempty"`
+
+	Attachments  []Attachment  `json:"attachments,omitempty"`
+	Reactions    []Reaction    `json:"reactions,omitempty"`
+	LinkPreviews []LinkPreview `json:"link_previews,omitempty"`
 }
 
+// message types
+const (
+	MessageTypeText       = "text"
+	MessageTypeAttachment = "attachment"
+	// system messages record conversation changes ("Alice renamed the group to …")
+	MessageTypeSystem = "system"
+)
+
 type User struct {
 	ID          uuid.UUID `json:"id"`
 	DisplayName *string   `json:"display_name,omitempty"`
 	Email       *string   `json:"email,omitempty"`
 }
 
-// SearchUsersByDisplayName does a simple ILIKE lookup for display names.
-func SearchUsersByDisplayName(ctx context.Context, pool *pgxpool.Pool, q string, limit int) ([]User, error) {
+// SearchUsersByDisplayName does a simple ILIKE lookup for display names, leaving out users viewerID has blocked.
+func SearchUsersByDisplayName(ctx context.Context, pool *pgxpool.Pool, viewerID uuid.UUID, q string, limit int) ([]User, error) {
 	if limit <= 0 {
 		limit = 20
 	}
@@ -50,9 +120,10 @@ func SearchUsersByDisplayName(ctx context.Context, pool *pgxpool.Pool, q string,
 		SELECT id, display_name, email
 		FROM users
 		WHERE display_name ILIKE '%' || $1 || '%'
+			AND `+notBlockedBy("$3", "users.id")+`
 		ORDER BY display_name
 		LIMIT $2
-	`, q, limit)
+	`, q, limit, viewerID)
 	if err != nil {
 		return nil, err
 	}
@@ -69,25 +140,43 @@ func SearchUsersByDisplayName(ctx context.Context, pool *pgxpool.Pool, q string,
 	return out, rows.Err()
 }
 
-// GetConversationsForUser returns conversations the user participates in.
-func GetConversationsForUser(ctx context.Context, pool *pgxpool.Pool, userID uuid.UUID, limit int) ([]Conversation, error) {
-	if limit <= 0 {
-		limit = 50
+// GetConversationsForUser returns conversations the user participates in: the ones they pinned
+// (first page only, most recently pinned first) followed by up to Limit others, most recently
+// active first.
+func GetConversationsForUser(ctx context.Context, pool *pgxpool.Pool, p ConversationListParams) ([]Conversation, error) {
+	if p.Limit <= 0 {
+		p.Limit = 50
 	}
 
 	rows, err := pool.Query(ctx, `
-	SELECT c.id, c.title, c.is_group, c.created_at,
+	SELECT c.id, c.title, c.is_group, c.created_at, c.avatar_url, c.description,
 		(
 			SELECT u.display_name FROM conversation_participants cp2
 			JOIN users u ON u.id = cp2.user_id
-			WHERE cp2.conversation_id = c.id AND cp2.user_id <> $1
+			WHERE cp2.conversation_id = c.id AND cp2.user_id <> $1 AND cp2.role <> 'bot'
 			LIMIT 1
-		) as display_name
+		) as display_name,
+		c.last_message_at, c.last_message_id, c.last_message_author_id, lu.display_name, c.last_message_preview,
+		(
+			SELECT count(*) FROM messages m
+			WHERE m.conversation_id = c.id AND m.parent_message_id IS NULL AND NOT m.is_deleted
+				AND m.author_id IS DISTINCT FROM $1
+				AND m.created_at > coalesce(cp.last_read_at, cp.joined_at)
+				AND `+notBlockedBy("$1", "m.author_id")+`
+		) as unread_count,
+		`+settingsColumns+`
 	FROM conversation_participants cp
 	JOIN conversations c ON c.id = cp.conversation_id
+	LEFT JOIN users lu ON lu.id = c.last_message_author_id
 	WHERE cp.user_id = $1
-	ORDER BY c.created_at DESC
-	LIMIT $2`, userID, limit)
+		AND ($5::bool IS NULL OR cp.is_archived = $5)
+		AND CASE WHEN cp.pinned_at IS NOT NULL THEN $2::timestamptz IS NULL
+			ELSE $2::timestamptz IS NULL OR (coalesce(c.last_message_at, c.created_at), c.id) < ($2, $3::uuid) END
+	ORDER BY cp.pinned_at DESC NULLS LAST, coalesce(c.last_message_at, c.created_at) DESC, c.id DESC
+	LIMIT $4 + CASE WHEN $2::timestamptz IS NULL THEN (
+		SELECT count(*) FROM conversation_participants
+		WHERE user_id = $1 AND pinned_at IS NOT NULL AND ($5::bool IS NULL OR is_archived = $5)
+	) ELSE 0 END`, p.UserID, p.BeforeTime, p.BeforeID, p.Limit, p.Archived)
 	if err != nil {
 		return nil, err
 	}
@@ -96,7 +185,10 @@ func GetConversationsForUser(ctx context.Context, pool *pgxpool.Pool, userID uui
 	var out []Conversation
 	for rows.Next() {
 		var c Conversation
-		if err := rows.Scan(&c.ID, &c.Title, &c.IsGroup, &c.CreatedAt, &c.DisplayName); err != nil {
+		dest := []any{&c.ID, &c.Title, &c.IsGroup, &c.CreatedAt, &c.AvatarURL, &c.Description, &c.DisplayName,
+			&c.LastMessageAt, &c.LastMessageID, &c.LastMessageAuthorID, &c.LastMessageAuthorName, &c.LastMessagePreview,
+			&c.UnreadCount}
+		if err := rows.Scan(append(dest, c.ConversationSettings.scanDest()...)...); err != nil {
 			return nil, err
 		}
 		out = append(out, c)
@@ -104,23 +196,38 @@ func GetConversationsForUser(ctx context.Context, pool *pgxpool.Pool, userID uui
 	return out, rows.Err()
 }
 
-// GetMessagesForConversation returns recent messages for a conversation (oldest first).
-func GetMessagesForConversation(ctx context.Context, pool *pgxpool.Pool, convID uuid.UUID, limit int) ([]Message, error) {
+// MarkConversationRead moves the user's read marker forward to lastReadID (a message of the
+// conversation), or to now when lastReadID is nil. It never moves the marker backwards.
+func MarkConversationRead(ctx context.Context, pool *pgxpool.Pool, convID, userID uuid.UUID, lastReadID *uuid.UUID) error {
+	_, err := pool.Exec(ctx, `
+		UPDATE conversation_participants
+		SET last_read_at = greatest(last_read_at, coalesce(
+			(SELECT created_at FROM messages WHERE id = $3 AND conversation_id = $1), now()))
+		WHERE conversation_id = $1 AND user_id = $2
+	`, convID, userID, lastReadID)
+	return err
+}
+
+// GetMessagesForConversation returns recent top-level messages for a conversation (oldest first);
+// thread replies are loaded with GetThreadReplies. Reactions are aggregated for viewerID and
+// messages by users viewerID has blocked are left out.
+func GetMessagesForConversation(ctx context.Context, pool *pgxpool.Pool, convID, viewerID uuid.UUID, limit int) ([]Message, error) {
 	if limit <= 0 {
 		limit = 100
 	}
 
 	rows, err := pool.Query(ctx, `
-	SELECT m.id, m.conversation_id, m.author_id, m.body, m.created_at, u.display_name, u.avatar_url
+	SELECT `+messageColumns+`
 	FROM (
 		SELECT * FROM messages
-		WHERE conversation_id = $1
+		WHERE conversation_id = $1 AND parent_message_id IS NULL
+			AND `+notBlockedBy("$3", "messages.author_id")+`
 		ORDER BY created_at DESC
 		LIMIT $2
 	) m
-	LEFT JOIN users u ON u.id = m.author_id
+	`+messageJoins+`
 	ORDER BY m.created_at ASC
-	`, convID, limit)
+	`, convID, limit, viewerID)
 	if err != nil {
 		return nil, err
 	}
@@ -128,13 +235,24 @@ func GetMessagesForConversation(ctx context.Context, pool *pgxpool.Pool, convID
 
 	var out []Message
 	for rows.Next() {
-		var m Message
-		if err := rows.Scan(&m.ID, &m.ConversationID, &m.AuthorID, &m.Body, &m.CreatedAt, &m.AuthorName, &m.AuthorAvatar); err != nil {
+		m, err := scanMessage(rows)
+		if err != nil {
 			return nil, err
 		}
 		out = append(out, m)
 	}
-	return out, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := attachAttachments(ctx, pool, out); err != nil {
+		return nil, err
+	}
+	if err := AttachReactions(ctx, pool, out, viewerID); err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
 // IsUserInConversation returns true when the given user is a participant of the conversation.
@@ -148,23 +266,75 @@ func IsUserInConversation(ctx context.Context, pool *pgxpool.Pool, convID, userI
 	return exists, err
 }
 
-// SaveMessage inserts a new message and returns the saved row
+// IsConversationAdmin returns true when the user is an owner/admin participant or the creator of the conversation.
+func IsConversationAdmin(ctx context.Context, pool *pgxpool.Pool, convID, userID uuid.UUID) (bool, error) {
+	var ok bool
+	err := pool.QueryRow(ctx, `
+		SELECT EXISTS(
+			SELECT 1 FROM conversation_participants cp
+			JOIN conversations c ON c.id = cp.conversation_id
+			WHERE cp.conversation_id = $1 AND cp.user_id = $2
+				AND (cp.role IN ('owner', 'admin') OR c.created_by = cp.user_id)
+		)`, convID, userID).Scan(&ok)
+	return ok, err
+}
+
+// CanManageConversation reports whether a participant role may change shared conversation state
+// (pins, content filters): owners and admins in groups, either person in a direct conversation;
+// bots never.
+func CanManageConversation(role string, isGroup bool) bool {
+	if role == RoleBot {
+		return false
+	}
+	return !isGroup || role == RoleOwner || role == RoleAdmin
+}
+
+// SaveMessage inserts a new message, records it as the conversation's last message and returns the saved row
 func SaveMessage(ctx context.Context, pool *pgxpool.Pool, convID, authorID uuid.UUID, body string) (Message, error) {
-	var m Message
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return Message{}, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
 
-	err := pool.QueryRow(ctx, `
+	var id uuid.UUID
+	err = tx.QueryRow(ctx, `
 	INSERT INTO messages (id, conversation_id, author_id, body, message_type, created_at)
 	VALUES (gen_random_uuid(), $1, $2, $3, 'text', now())
-	RETURNING id, conversation_id, author_id, body, created_at
-	`, convID, authorID, body).Scan(&m.ID, &m.ConversationID, &m.AuthorID, &m.Body, &m.CreatedAt)
+	RETURNING id
+	`, convID, authorID, body).Scan(&id)
 	if err != nil {
-		return m, err
+		return Message{}, err
+	}
+	if err := touchConversation(ctx, tx, id); err != nil {
+		return Message{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Message{}, err
 	}
 
-	// fetching author display_name and avatar_url
-	_ = pool.QueryRow(ctx, `SELECT display_name, avatar_url FROM users WHERE id = $1`, m.AuthorID).Scan(&m.AuthorName, &m.AuthorAvatar)
+	// reloading with author display_name and avatar_url
+	return GetMessage(ctx, pool, id)
+}
 
-	return m, nil
+// touchConversation records a new top-level message as its conversation's last message. It runs
+// in the transaction that inserted the message; the row lock taken by the UPDATE plus the
+// timestamp check keep a concurrently saved newer message from being overwritten.
+func touchConversation(ctx context.Context, tx pgx.Tx, msgID uuid.UUID) error {
+	_, err := tx.Exec(ctx, `
+		UPDATE conversations c
+		SET last_message_at = m.created_at,
+			last_message_id = m.id,
+			last_message_author_id = m.author_id,
+			last_message_preview = coalesce(
+				left(nullif(m.body, ''), $2),
+				(SELECT '📎 ' || a.filename FROM attachments a WHERE a.message_id = m.id ORDER BY a.created_at LIMIT 1)
+			)
+		FROM messages m
+		WHERE m.id = $1 AND c.id = m.conversation_id
+			AND (c.last_message_at IS NULL OR c.last_message_at <= m.created_at)
+	`, msgID, conversationPreviewLength)
+	return err
 }
 
 // CreateConversation creates a conversation and inserts participants atomically.
@@ -219,6 +389,15 @@ func CreateConversation(ctx context.Context, pool *pgxpool.Pool, title *string,
 		if exists {
 			return c, ErrDirectConversationsExists
 		}
+
+		// a user who blocked the creator can't be pulled into a direct conversation by them
+		blocked, err := HasBlocked(ctx, pool, other, creatorID)
+		if err != nil {
+			return c, err
+		}
+		if blocked {
+			return c, ErrBlocked
+		}
 	}
 
 	if len(unique) == 0 {
@@ -258,13 +437,17 @@ func CreateConversation(ctx context.Context, pool *pgxpool.Pool, title *string,
 		return c, err
 	}
 
-	// inserting participants
+	// inserting participants (the creator owns the conversation)
 	for _, p := range unique {
+		role := RoleMember
+		if p == creatorID {
+			role = RoleOwner
+		}
 		if _, err := tx.Exec(ctx, `
 			INSERT INTO conversation_participants (conversation_id, user_id, joined_at, role)
-			VALUES ($1, $2, now(), 'member')
+			VALUES ($1, $2, now(), $3)
 			ON CONFLICT DO NOTHING
-		`, c.ID, p); err != nil {
+		`, c.ID, p, role); err != nil {
 			return c, err
 		}
 	}
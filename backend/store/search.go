@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Highlight markers ts_headline puts around matches in SearchResult.Snippet. Message bodies
+// are stripped of both characters first, so markers in the snippet are always ts_headline's.
+const (
+	HighlightStart = "\x02"
+	HighlightStop  = "\x03"
+)
+
+// SearchParams filters a message search; Before* is the keyset cursor (exclusive).
+type SearchParams struct {
+	Query          string
+	AuthorID       *uuid.UUID
+	ConversationID *uuid.UUID
+	From           *time.Time
+	To             *time.Time
+	BeforeTime     *time.Time
+	BeforeID       *uuid.UUID
+	Limit          int
+}
+
+type SearchResult struct {
+	Message
+	ConversationTitle *string `json:"conversation_title,omitempty"`
+	Snippet           string  `json:"-"`
+}
+
+// SearchMessages runs a full-text search over the conversations userID participates in, newest first.
+func SearchMessages(ctx context.Context, pool *pgxpool.Pool, userID uuid.UUID, p SearchParams) ([]SearchResult, error) {
+	if p.Limit <= 0 {
+		p.Limit = 20
+	}
+	rows, err := pool.Query(ctx, `
+		SELECT `+messageColumns+`, c.title,
+			ts_headline('english', translate(coalesce(m.body, ''), chr(2) || chr(3), ''), q,
+				'StartSel=`+HighlightStart+`, StopSel=`+HighlightStop+`, MaxWords=24, MinWords=8, MaxFragments=2, FragmentDelimiter=" … "')
+		FROM messages m
+		JOIN conversation_participants cp ON cp.conversation_id = m.conversation_id AND cp.user_id = $1
+		JOIN conversations c ON c.id = m.conversation_id
+		`+messageJoins+`
+		CROSS JOIN websearch_to_tsquery('english', $2) q
+		WHERE m.body_tsv @@ q
+			AND `+notBlockedBy("$1", "m.author_id")+`
+			AND ($3::uuid IS NULL OR m.author_id = $3)
+			AND ($4::uuid IS NULL OR m.conversation_id = $4)
+			AND ($5::timestamptz IS NULL OR m.created_at >= $5)
+			AND ($6::timestamptz IS NULL OR m.created_at < $6)
+			AND ($7::timestamptz IS NULL OR (m.created_at, m.id) < ($7, $8::uuid))
+		ORDER BY m.created_at DESC, m.id DESC
+		LIMIT $9
+	`, userID, p.Query, p.AuthorID, p.ConversationID, p.From, p.To, p.BeforeTime, p.BeforeID, p.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []SearchResult{}
+	for rows.Next() {
+		var r SearchResult
+		m, err := scanMessage(rows, &r.ConversationTitle, &r.Snippet)
+		if err != nil {
+			return nil, err
+		}
+		r.Message = m
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// MaxPinnedConversations is how many conversations a user can pin to the top of their list.
+const MaxPinnedConversations = 10
+
+// ErrPinnedConversationsLimit is returned when pinning one more conversation than MaxPinnedConversations.
+var ErrPinnedConversationsLimit = errors.New("pinned conversations limit reached")
+
+// mutedExpr is true while the mute of participant row "cp" is in effect (muted_until NULL = indefinitely).
+const mutedExpr = `(cp.is_muted AND (cp.muted_until IS NULL OR cp.muted_until > now()))`
+
+// settingsColumns selects ConversationSettings from participant row "cp".
+const settingsColumns = mutedExpr + `, CASE WHEN ` + mutedExpr + ` THEN cp.muted_until END,
+	cp.is_archived, cp.pinned_at IS NOT NULL, cp.pinned_at`
+
+// ConversationSettings are a user's own settings for a conversation. An expired mute reads as unmuted.
+type ConversationSettings struct {
+	Muted      bool       `json:"muted"`
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+	Archived   bool       `json:"archived"`
+	Pinned     bool       `json:"pinned"`
+	PinnedAt   *time.Time `json:"pinned_at,omitempty"`
+}
+
+func (s *ConversationSettings) scanDest() []any {
+	return []any{&s.Muted, &s.MutedUntil, &s.Archived, &s.Pinned, &s.PinnedAt}
+}
+
+// ConversationSettingsUpdate changes the non-nil fields. MutedUntil only applies when
+// muting (nil mutes indefinitely); unmuting clears it.
+type ConversationSettingsUpdate struct {
+	Muted      *bool
+	MutedUntil *time.Time
+	Archived   *bool
+	Pinned     *bool
+}
+
+// GetConversationSettings returns the user's settings; ErrNotFound when not a participant.
+func GetConversationSettings(ctx context.Context, pool *pgxpool.Pool, convID, userID uuid.UUID) (ConversationSettings, error) {
+	var s ConversationSettings
+	err := pool.QueryRow(ctx, `
+		SELECT `+settingsColumns+`
+		FROM conversation_participants cp
+		WHERE cp.conversation_id = $1 AND cp.user_id = $2
+	`, convID, userID).Scan(s.scanDest()...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return s, ErrNotFound
+	}
+	return s, err
+}
+
+// UpdateConversationSettings applies upd to the user's settings and returns the result.
+// Pinning an already pinned conversation keeps its position.
+func UpdateConversationSettings(ctx context.Context, pool *pgxpool.Pool, convID, userID uuid.UUID, upd ConversationSettingsUpdate) (ConversationSettings, error) {
+	var s ConversationSettings
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return s, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var pinned bool
+	err = tx.QueryRow(ctx, `
+		SELECT pinned_at IS NOT NULL FROM conversation_participants
+		WHERE conversation_id = $1 AND user_id = $2
+		FOR UPDATE
+	`, convID, userID).Scan(&pinned)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return s, ErrNotFound
+	}
+	if err != nil {
+		return s, err
+	}
+	if upd.Pinned != nil && *upd.Pinned && !pinned {
+		var count int
+		if err := tx.QueryRow(ctx, `
+			SELECT count(*) FROM conversation_participants WHERE user_id = $1 AND pinned_at IS NOT NULL
+		`, userID).Scan(&count); err != nil {
+			return s, err
+		}
+		if count >= MaxPinnedConversations {
+			return s, ErrPinnedConversationsLimit
+		}
+	}
+
+	err = tx.QueryRow(ctx, `
+		UPDATE conversation_participants cp
+		SET is_muted = coalesce($3, cp.is_muted),
+			muted_until = CASE WHEN $3::bool IS NULL THEN cp.muted_until WHEN $3 THEN $4::timestamptz END,
+			is_archived = coalesce($5, cp.is_archived),
+			pinned_at = CASE WHEN $6::bool IS NULL THEN cp.pinned_at WHEN $6 THEN coalesce(cp.pinned_at, now()) END
+		WHERE cp.conversation_id = $1 AND cp.user_id = $2
+		RETURNING `+settingsColumns+`
+	`, convID, userID, upd.Muted, upd.MutedUntil, upd.Archived, upd.Pinned).Scan(s.scanDest()...)
+	if err != nil {
+		return s, err
+	}
+	return s, tx.Commit(ctx)
+}
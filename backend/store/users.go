@@ -0,0 +1,95 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Profile is a user's editable profile. Email is only filled in for the user themselves.
+type Profile struct {
+	ID          uuid.UUID  `json:"id"`
+	Email       *string    `json:"email,omitempty"`
+	DisplayName *string    `json:"display_name,omitempty"`
+	FirstName   *string    `json:"first_name,omitempty"`
+	LastName    *string    `json:"last_name,omitempty"`
+	AvatarURL   *string    `json:"avatar_url,omitempty"`
+	IsBot       bool       `json:"is_bot"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+}
+
+// ProfileUpdate changes the non-nil fields; empty names and avatar URLs are stored as NULL.
+type ProfileUpdate struct {
+	DisplayName *string
+	FirstName   *string
+	LastName    *string
+	AvatarURL   *string
+}
+
+const profileColumns = `id, email, display_name, first_name, last_name, avatar_url, is_bot, created_at, updated_at`
+
+// scanProfile scans profileColumns followed by any extra columns into extra.
+func scanProfile(row pgx.Row, extra ...any) (Profile, error) {
+	var p Profile
+	dest := []any{&p.ID, &p.Email, &p.DisplayName, &p.FirstName, &p.LastName, &p.AvatarURL, &p.IsBot, &p.CreatedAt, &p.UpdatedAt}
+	err := row.Scan(append(dest, extra...)...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return p, ErrNotFound
+	}
+	return p, err
+}
+
+// GetProfile returns an active user's profile (with email); ErrNotFound for unknown or deactivated users.
+func GetProfile(ctx context.Context, pool *pgxpool.Pool, userID uuid.UUID) (Profile, error) {
+	return scanProfile(pool.QueryRow(ctx, `
+		SELECT `+profileColumns+` FROM users WHERE id = $1 AND is_active
+	`, userID))
+}
+
+// UpdateProfile applies upd and returns the updated profile with the previous avatar URL
+// (so a replaced uploaded avatar can be cleaned up).
+func UpdateProfile(ctx context.Context, pool *pgxpool.Pool, userID uuid.UUID, upd ProfileUpdate) (Profile, *string, error) {
+	var previousAvatar *string
+	p, err := scanProfile(pool.QueryRow(ctx, `
+		WITH prev AS (SELECT avatar_url FROM users WHERE id = $1 FOR UPDATE)
+		UPDATE users
+		SET display_name = CASE WHEN $2::text IS NULL THEN display_name ELSE nullif($2, '') END,
+			first_name = CASE WHEN $3::text IS NULL THEN first_name ELSE nullif($3, '') END,
+			last_name = CASE WHEN $4::text IS NULL THEN last_name ELSE nullif($4, '') END,
+			avatar_url = CASE WHEN $5::text IS NULL THEN avatar_url ELSE nullif($5, '') END,
+			updated_at = now()
+		WHERE id = $1 AND is_active
+		RETURNING `+profileColumns+`, (SELECT avatar_url FROM prev)
+	`, userID, upd.DisplayName, upd.FirstName, upd.LastName, upd.AvatarURL), &previousAvatar)
+	return p, previousAvatar, err
+}
+
+// GetContactIDs returns the users sharing at least one conversation with userID (userID included).
+func GetContactIDs(ctx context.Context, pool *pgxpool.Pool, userID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := pool.Query(ctx, `
+		SELECT DISTINCT other.user_id
+		FROM conversation_participants mine
+		JOIN conversation_participants other ON other.conversation_id = mine.conversation_id
+		WHERE mine.user_id = $1
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []uuid.UUID{userID}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		if id != userID {
+			out = append(out, id)
+		}
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,590 @@
+package backend
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TOTP parameters (RFC 6238 defaults, understood by all authenticator apps)
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	totpSkew   = 1 // accepted steps before/after the current one
+)
+
+// Challenge token lifetime (between the password step and the TOTP step)
+const challengeTokenTTL = 5 * time.Minute
+
+// failed second-factor logins allowed before the step is locked for totpLockout
+const (
+	maxTOTPFailures = 5
+	totpLockout     = 15 * time.Minute
+)
+
+// number of recovery codes generated on enrollment
+const recoveryCodeCount = 10
+
+var (
+	errInvalidTOTPCode    = errors.New("invalid two-factor code")
+	errTOTPNotEnrolled    = errors.New("two-factor authentication not enrolled")
+	errTOTPAlreadyEnabled = errors.New("two-factor authentication already enabled")
+	errInvalidChallenge   = errors.New("invalid or expired challenge")
+)
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateTOTPSecret returns a random 160-bit secret in base32 (no padding).
+func generateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return b32.EncodeToString(b), nil
+}
+
+// totpURI builds the otpauth:// URI rendered as QR code by the client.
+func totpURI(secret, account string) string {
+	issuer := os.Getenv("TOTP_ISSUER")
+	if issuer == "" {
+		issuer = "Go Realtime Chat"
+	}
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(totpDigits))
+	v.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+// totpCode computes the code for a given time step.
+func totpCode(secret string, step int64) (string, error) {
+	key, err := b32.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	bin := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, bin%mod), nil
+}
+
+// validateTOTP checks the code against the steps around now and returns the matching step.
+// Steps <= lastStep are rejected so a code can't be replayed.
+func validateTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	cur := now.Unix() / totpPeriod
+	for i := -totpSkew; i <= totpSkew; i++ {
+		step := cur + int64(i)
+		if step <= lastStep {
+			continue
+		}
+		want, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(want), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// generateChallengeToken issues the short-lived token returned by the password step. Its jti
+// is a login_challenges row, so the token can only be used for one successful login.
+func generateChallengeToken(ctx context.Context, pool *pgxpool.Pool, userID string) (string, error) {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		return "", errors.New("JWT_SECRET not configured")
+	}
+	now := time.Now().UTC()
+	var jti string
+	if err := pool.QueryRow(ctx, `
+		INSERT INTO login_challenges (user_id, expires_at) VALUES ($1, $2) RETURNING id::text
+	`, userID, now.Add(challengeTokenTTL)).Scan(&jti); err != nil {
+		return "", err
+	}
+	claims := jwt.MapClaims{
+		"sub": userID,
+		"jti": jti,
+		"typ": tokenTypeChallenge,
+		"iat": now.Unix(),
+		"exp": now.Add(challengeTokenTTL).Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+}
+
+// parseChallengeToken returns the user id and challenge id of a valid challenge token.
+func parseChallengeToken(tok string) (string, string, error) {
+	claims, err := parseToken(tok)
+	if err != nil {
+		return "", "", err
+	}
+	if typ, _ := claims["typ"].(string); typ != tokenTypeChallenge {
+		return "", "", errors.New("not a challenge token")
+	}
+	sub, _ := claims["sub"].(string)
+	jti, _ := claims["jti"].(string)
+	if sub == "" || jti == "" {
+		return "", "", errors.New("invalid challenge token")
+	}
+	return sub, jti, nil
+}
+
+// openChallenge checks that the challenge is unused and unexpired and that the user's second
+// factor isn't locked; a lock is reported with its end.
+func openChallenge(ctx context.Context, pool *pgxpool.Pool, userID, jti string) (lockedUntil *time.Time, err error) {
+	var open bool
+	err = pool.QueryRow(ctx, `
+		SELECT
+			EXISTS (SELECT 1 FROM login_challenges
+				WHERE id::text = $2 AND user_id = $1 AND used_at IS NULL AND expires_at > now()),
+			(SELECT totp_locked_until FROM users WHERE id = $1 AND totp_locked_until > now())
+	`, userID, jti).Scan(&open, &lockedUntil)
+	if err != nil {
+		return nil, err
+	}
+	if lockedUntil != nil {
+		return lockedUntil, nil
+	}
+	if !open {
+		return nil, errInvalidChallenge
+	}
+	return nil, nil
+}
+
+// totpLockedUntil returns the end of the user's 2FA lockout, or nil when not locked.
+func totpLockedUntil(ctx context.Context, pool *pgxpool.Pool, userID string) (lockedUntil *time.Time, err error) {
+	err = pool.QueryRow(ctx, `
+		SELECT totp_locked_until FROM users WHERE id = $1 AND totp_locked_until > now()
+	`, userID).Scan(&lockedUntil)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	return lockedUntil, err
+}
+
+// recordTOTPFailure counts a failed second-factor login. The maxTOTPFailures-th failure locks
+// the step for totpLockout and revokes the user's outstanding challenges, so minting new
+// challenges doesn't give more guesses.
+func recordTOTPFailure(ctx context.Context, pool *pgxpool.Pool, userID string) (lockedUntil *time.Time, err error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var attempts int
+	if err := tx.QueryRow(ctx, `
+		UPDATE users SET totp_failed_attempts = totp_failed_attempts + 1 WHERE id = $1
+		RETURNING totp_failed_attempts
+	`, userID).Scan(&attempts); err != nil {
+		return nil, err
+	}
+	if attempts >= maxTOTPFailures {
+		until := time.Now().Add(totpLockout)
+		if _, err := tx.Exec(ctx, `
+			UPDATE users SET totp_failed_attempts = 0, totp_locked_until = $2 WHERE id = $1
+		`, userID, until); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(ctx, `
+			UPDATE login_challenges SET used_at = now() WHERE user_id = $1 AND used_at IS NULL
+		`, userID); err != nil {
+			return nil, err
+		}
+		lockedUntil = &until
+	}
+	return lockedUntil, tx.Commit(ctx)
+}
+
+// consumeChallenge marks the challenge used after a successful second factor and resets the
+// failure count; errInvalidChallenge if a concurrent login used it first.
+func consumeChallenge(ctx context.Context, pool *pgxpool.Pool, userID, jti string) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE login_challenges SET used_at = now()
+		WHERE id::text = $2 AND user_id = $1 AND used_at IS NULL AND expires_at > now()
+	`, userID, jti)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errInvalidChallenge
+	}
+	if _, err := tx.Exec(ctx, `UPDATE users SET totp_failed_attempts = 0 WHERE id = $1`, userID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// writeTOTPLocked answers 429 until the lockout ends.
+func writeTOTPLocked(w http.ResponseWriter, until time.Time) {
+	w.Header().Set("Retry-After", fmt.Sprint(max(1, int(time.Until(until).Seconds()+1))))
+	http.Error(w, "too many failed attempts, try again later", http.StatusTooManyRequests)
+}
+
+// generateRecoveryCodes returns raw codes formatted as xxxxx-xxxxx.
+func generateRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		raw, err := generateRandomToken(5)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode makes codes comparable regardless of case, spaces or dashes.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code and records its use.
+func verifySecondFactor(ctx context.Context, pool *pgxpool.Pool, userID, code, recoveryCode string) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var secret sql.NullString
+	var enabled bool
+	var lastStep int64
+	err = tx.QueryRow(ctx, `
+		SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE id = $1 FOR UPDATE
+	`, userID).Scan(&secret, &enabled, &lastStep)
+	if err != nil {
+		return err
+	}
+	if !enabled || !secret.Valid {
+		return errTOTPNotEnrolled
+	}
+
+	if recoveryCode != "" {
+		tag, err := tx.Exec(ctx, `
+			UPDATE recovery_codes SET used_at = now()
+			WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+		`, userID, HashToken(normalizeRecoveryCode(recoveryCode)))
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return errInvalidTOTPCode
+		}
+		return tx.Commit(ctx)
+	}
+
+	step, ok := validateTOTP(secret.String, code, time.Now(), lastStep)
+	if !ok {
+		return errInvalidTOTPCode
+	}
+	if _, err := tx.Exec(ctx, `UPDATE users SET totp_last_step = $1 WHERE id = $2`, step, userID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// TOTPEnrollHandler creates a new (not yet enabled) TOTP secret for the authenticated user.
+func TOTPEnrollHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uid := GetUserIDFromCtx(r.Context())
+
+		var email string
+		var enabled bool
+		if err := pool.QueryRow(r.Context(), `SELECT email, totp_enabled FROM users WHERE id = $1`, uid).Scan(&email, &enabled); err != nil {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+		if enabled {
+			http.Error(w, errTOTPAlreadyEnabled.Error(), http.StatusConflict)
+			return
+		}
+
+		secret, err := generateTOTPSecret()
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := pool.Exec(r.Context(), `UPDATE users SET totp_secret = $1, totp_last_step = 0 WHERE id = $2`, secret, uid); err != nil {
+			log.Printf("2fa: enroll error user=%s: %v", uid, err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"secret":      secret,
+			"otpauth_uri": totpURI(secret, email),
+		})
+	})
+}
+
+// TOTPVerifyHandler confirms enrollment with a first valid code, enables 2FA and returns the recovery codes (shown once).
+func TOTPVerifyHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Code string `json:"code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		uid := GetUserIDFromCtx(r.Context())
+		ctx := r.Context()
+
+		tx, err := pool.Begin(ctx)
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		defer func() { _ = tx.Rollback(ctx) }()
+
+		var secret sql.NullString
+		var enabled bool
+		if err := tx.QueryRow(ctx, `SELECT totp_secret, totp_enabled FROM users WHERE id = $1 FOR UPDATE`, uid).Scan(&secret, &enabled); err != nil {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+		if enabled {
+			http.Error(w, errTOTPAlreadyEnabled.Error(), http.StatusConflict)
+			return
+		}
+		if !secret.Valid {
+			http.Error(w, errTOTPNotEnrolled.Error(), http.StatusBadRequest)
+			return
+		}
+		step, ok := validateTOTP(secret.String, req.Code, time.Now(), 0)
+		if !ok {
+			http.Error(w, errInvalidTOTPCode.Error(), http.StatusBadRequest)
+			return
+		}
+
+		codes, err := generateRecoveryCodes(recoveryCodeCount)
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, uid); err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		for _, c := range codes {
+			if _, err := tx.Exec(ctx, `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`, uid, HashToken(normalizeRecoveryCode(c))); err != nil {
+				log.Printf("2fa: store recovery code error user=%s: %v", uid, err)
+				http.Error(w, "server error", http.StatusInternalServerError)
+				return
+			}
+		}
+		if _, err := tx.Exec(ctx, `UPDATE users SET totp_enabled = true, totp_last_step = $1 WHERE id = $2`, step, uid); err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(ctx); err != nil {
+			log.Printf("2fa: verify commit error user=%s: %v", uid, err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("2fa: enabled for user %s", uid)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"enabled":        true,
+			"recovery_codes": codes,
+		})
+	})
+}
+
+// TOTPDisableHandler turns 2FA off; it requires a current TOTP or recovery code.
+func TOTPDisableHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			Code         string `json:"code"`
+			RecoveryCode string `json:"recovery_code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		uid := GetUserIDFromCtx(r.Context())
+		// the same failure counter and lockout as the login challenge, so a session can't be
+		// used to guess the code
+		lockedUntil, err := totpLockedUntil(r.Context(), pool, uid)
+		if err != nil {
+			log.Printf("2fa: lockout lookup error user=%s: %v", uid, err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		if lockedUntil != nil {
+			writeTOTPLocked(w, *lockedUntil)
+			return
+		}
+		if err := verifySecondFactor(r.Context(), pool, uid, req.Code, req.RecoveryCode); err != nil {
+			if errors.Is(err, errInvalidTOTPCode) {
+				lockedUntil, err := recordTOTPFailure(r.Context(), pool, uid)
+				if err != nil {
+					log.Printf("2fa: record failure user=%s error: %v", uid, err)
+				}
+				if lockedUntil != nil {
+					log.Printf("2fa: locked user=%s until %s after %d failed attempts", uid, lockedUntil.Format(time.RFC3339), maxTOTPFailures)
+					writeTOTPLocked(w, *lockedUntil)
+					return
+				}
+			}
+			if errors.Is(err, errInvalidTOTPCode) || errors.Is(err, errTOTPNotEnrolled) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Printf("2fa: disable verify error user=%s: %v", uid, err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := pool.Exec(r.Context(), `UPDATE users SET totp_enabled = false, totp_secret = NULL, totp_last_step = 0, totp_failed_attempts = 0 WHERE id = $1`, uid); err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := pool.Exec(r.Context(), `DELETE FROM recovery_codes WHERE user_id = $1`, uid); err != nil {
+			log.Printf("2fa: delete recovery codes error user=%s: %v", uid, err)
+		}
+		log.Printf("2fa: disabled for user %s", uid)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"enabled": false})
+	})
+}
+
+// LoginTOTPHandler is the second login step: it exchanges a challenge token + TOTP (or recovery) code for session cookies.
+// Challenges are single-use, and maxTOTPFailures wrong codes lock the step for totpLockout (429).
+func LoginTOTPHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req struct {
+			ChallengeToken string `json:"challenge_token"`
+			Code           string `json:"code"`
+			RecoveryCode   string `json:"recovery_code"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		uid, jti, err := parseChallengeToken(req.ChallengeToken)
+		if err != nil {
+			http.Error(w, errInvalidChallenge.Error(), http.StatusUnauthorized)
+			return
+		}
+		lockedUntil, err := openChallenge(r.Context(), pool, uid, jti)
+		if errors.Is(err, errInvalidChallenge) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Printf("2fa: challenge lookup error user=%s: %v", uid, err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		if lockedUntil != nil {
+			writeTOTPLocked(w, *lockedUntil)
+			return
+		}
+		if err := verifySecondFactor(r.Context(), pool, uid, req.Code, req.RecoveryCode); err != nil {
+			if errors.Is(err, errInvalidTOTPCode) || errors.Is(err, errTOTPNotEnrolled) || errors.Is(err, pgx.ErrNoRows) {
+				log.Printf("2fa: login rejected user=%s: %v", uid, err)
+				if errors.Is(err, errInvalidTOTPCode) {
+					lockedUntil, err := recordTOTPFailure(r.Context(), pool, uid)
+					if err != nil {
+						log.Printf("2fa: record failure user=%s error: %v", uid, err)
+					}
+					if lockedUntil != nil {
+						log.Printf("2fa: locked user=%s until %s after %d failed attempts", uid, lockedUntil.Format(time.RFC3339), maxTOTPFailures)
+						writeTOTPLocked(w, *lockedUntil)
+						return
+					}
+				}
+				http.Error(w, "invalid credentials", http.StatusUnauthorized)
+				return
+			}
+			log.Printf("2fa: login verify error user=%s: %v", uid, err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		if err := consumeChallenge(r.Context(), pool, uid, jti); err != nil {
+			if errors.Is(err, errInvalidChallenge) {
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			log.Printf("2fa: consume challenge error user=%s: %v", uid, err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+
+		var email string
+		var display sql.NullString
+		var active bool
+		if err := pool.QueryRow(r.Context(), `SELECT email, display_name, is_active FROM users WHERE id = $1`, uid).Scan(&email, &display, &active); err != nil {
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		if !active {
+			http.Error(w, ErrAccountSuspended.Error(), http.StatusForbidden)
+			return
+		}
+		if err := issueSession(w, r, pool, uid, email, display.String); err != nil {
+			log.Printf("2fa: issue session error user=%s: %v", uid, err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+	})
+}
@@ -0,0 +1,167 @@
+package backend
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Y3rnur/go-realtime-chat/backend/testdb"
+)
+
+// enrollTOTP turns 2FA on for the user and returns the secret.
+func enrollTOTP(t *testing.T, pool *pgxpool.Pool, uid uuid.UUID) string {
+	t.Helper()
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Exec(context.Background(), `UPDATE users SET totp_secret = $1, totp_enabled = true, totp_last_step = 0 WHERE id = $2`, secret, uid); err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+func challengeFor(t *testing.T, pool *pgxpool.Pool, uid uuid.UUID) string {
+	t.Helper()
+	tok, err := generateChallengeToken(context.Background(), pool, uid.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+// code returns the code of the step offset steps from now.
+func code(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	c, err := totpCode(secret, time.Now().Unix()/totpPeriod+offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// wrongCode returns a code none of the accepted steps has.
+func wrongCode(t *testing.T, secret string) string {
+	t.Helper()
+	for _, c := range []string{"000000", "111111", "222222"} {
+		if c != code(t, secret, -1) && c != code(t, secret, 0) && c != code(t, secret, 1) {
+			return c
+		}
+	}
+	t.Fatal("no wrong code")
+	return ""
+}
+
+func loginTOTP(h http.Handler, challenge, code string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	body := `{"challenge_token":"` + challenge + `","code":"` + code + `"}`
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/auth/login/2fa", strings.NewReader(body)))
+	return rec
+}
+
+func TestLoginTOTPChallengeIsSingleUse(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	pool := testdb.Pool(t)
+	uid := testdb.CreateUser(t, pool, "single-use@example.com")
+	secret := enrollTOTP(t, pool, uid)
+	h := LoginTOTPHandler(pool)
+
+	challenge := challengeFor(t, pool, uid)
+	if rec := loginTOTP(h, challenge, wrongCode(t, secret)); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong code: status %d", rec.Code)
+	}
+	if rec := loginTOTP(h, challenge, code(t, secret, 0)); rec.Code != http.StatusOK {
+		t.Fatalf("login: status %d %s", rec.Code, rec.Body)
+	}
+	// a fresh code doesn't make a used challenge valid again
+	if rec := loginTOTP(h, challenge, code(t, secret, 1)); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("reused challenge: status %d", rec.Code)
+	}
+
+	var failed int
+	if err := pool.QueryRow(context.Background(), `SELECT totp_failed_attempts FROM users WHERE id = $1`, uid).Scan(&failed); err != nil {
+		t.Fatal(err)
+	}
+	if failed != 0 {
+		t.Fatalf("failed attempts after login = %d, want 0", failed)
+	}
+}
+
+func TestLoginTOTPLockout(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	pool := testdb.Pool(t)
+	uid := testdb.CreateUser(t, pool, "lockout@example.com")
+	secret := enrollTOTP(t, pool, uid)
+	h := LoginTOTPHandler(pool)
+
+	wrong := wrongCode(t, secret)
+	challenge := challengeFor(t, pool, uid)
+	for i := 1; i < maxTOTPFailures; i++ {
+		if rec := loginTOTP(h, challenge, wrong); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status %d", i, rec.Code)
+		}
+	}
+	rec := loginTOTP(h, challenge, wrong)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("attempt %d: status %d, Retry-After %q", maxTOTPFailures, rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// locked: neither the right code nor a new challenge gets through
+	if rec := loginTOTP(h, challenge, code(t, secret, 0)); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("locked, right code: status %d", rec.Code)
+	}
+	if rec := loginTOTP(h, challengeFor(t, pool, uid), code(t, secret, 0)); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("locked, new challenge: status %d", rec.Code)
+	}
+
+	// after the lockout the revoked challenge stays dead, a new one works
+	if _, err := pool.Exec(context.Background(), `UPDATE users SET totp_locked_until = now() - interval '1 second' WHERE id = $1`, uid); err != nil {
+		t.Fatal(err)
+	}
+	if rec := loginTOTP(h, challenge, code(t, secret, 0)); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("revoked challenge: status %d", rec.Code)
+	}
+	if rec := loginTOTP(h, challengeFor(t, pool, uid), code(t, secret, 0)); rec.Code != http.StatusOK {
+		t.Fatalf("after lockout: status %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestDisableTOTPLockout(t *testing.T) {
+	pool := testdb.Pool(t)
+	uid := testdb.CreateUser(t, pool, "disable@example.com")
+	secret := enrollTOTP(t, pool, uid)
+	h := TOTPDisableHandler(pool)
+	disable := func(code string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/2fa/disable", strings.NewReader(`{"code":"`+code+`"}`))
+		h.ServeHTTP(rec, req.WithContext(context.WithValue(req.Context(), userIDKey, uid.String())))
+		return rec
+	}
+
+	wrong := wrongCode(t, secret)
+	for i := 1; i < maxTOTPFailures; i++ {
+		if rec := disable(wrong); rec.Code != http.StatusBadRequest {
+			t.Fatalf("attempt %d: status %d", i, rec.Code)
+		}
+	}
+	if rec := disable(wrong); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("attempt %d: status %d", maxTOTPFailures, rec.Code)
+	}
+	// locked: the right code doesn't turn 2FA off either
+	if rec := disable(code(t, secret, 0)); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("locked, right code: status %d", rec.Code)
+	}
+	var enabled bool
+	if err := pool.QueryRow(context.Background(), `SELECT totp_enabled FROM users WHERE id = $1`, uid).Scan(&enabled); err != nil {
+		t.Fatal(err)
+	}
+	if !enabled {
+		t.Fatal("2fa disabled during the lockout")
+	}
+}
@@ -0,0 +1,210 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Y3rnur/go-realtime-chat/backend/netguard"
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+// fetch limits: previews are best effort, so slow or large pages are given up on quickly
+const (
+	fetchTimeout   = 5 * time.Second
+	dialTimeout    = 3 * time.Second
+	maxRedirects   = 3
+	maxPageBytes   = 512 << 10
+	maxHeaderBytes = 16 << 10
+	maxURLLength   = 2048
+
+	maxTitleLen       = 200
+	maxDescriptionLen = 500
+	maxSiteNameLen    = 100
+)
+
+var (
+	errNotHTML    = errors.New("unfurl: not an html page")
+	errNoMetadata = errors.New("unfurl: page has no title or description")
+)
+
+// checkURL accepts absolute http(s) URLs on the default ports, with no credentials.
+func checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unfurl: scheme %q not allowed", u.Scheme)
+	}
+	if u.User != nil || u.Hostname() == "" {
+		return fmt.Errorf("unfurl: invalid url %q", u.Redacted())
+	}
+	if p := u.Port(); p != "" && p != "80" && p != "443" {
+		return fmt.Errorf("unfurl: port %s not allowed", p)
+	}
+	return nil
+}
+
+// Fetcher downloads pages and reads their OpenGraph metadata. Connections to internal
+// addresses are refused by netguard.
+type Fetcher struct {
+	client *http.Client
+}
+
+func NewFetcher() *Fetcher {
+	return newFetcher(netguard.PublicAddr, fetchTimeout)
+}
+
+// newFetcher connects only to addresses allow accepts and gives up on a page after timeout.
+// Tests use it to reach a local server.
+func newFetcher(allow func(netip.Addr) bool, timeout time.Duration) *Fetcher {
+	dialer := &net.Dialer{
+		Timeout: dialTimeout,
+		Control: netguard.Control(allow),
+	}
+	transport := &http.Transport{
+		// no proxy: it would connect on our behalf and skip the address check
+		Proxy:                  nil,
+		DialContext:            dialer.DialContext,
+		TLSHandshakeTimeout:    dialTimeout,
+		ResponseHeaderTimeout:  timeout,
+		MaxResponseHeaderBytes: maxHeaderBytes,
+		MaxIdleConns:           10,
+		IdleConnTimeout:        30 * time.Second,
+	}
+	return &Fetcher{client: &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			// via holds the requests made so far: the first one and the redirects followed
+			if len(via) > maxRedirects {
+				return errors.New("unfurl: too many redirects")
+			}
+			return checkURL(req.URL)
+		},
+	}}
+}
+
+// Fetch returns the preview of the page at rawURL.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (store.LinkPreview, error) {
+	preview := store.LinkPreview{URL: rawURL}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return preview, err
+	}
+	if err := checkURL(u); err != nil {
+		return preview, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return preview, err
+	}
+	req.Header.Set("User-Agent", "go-realtime-chat link preview (+OpenGraph)")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return preview, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return preview, fmt.Errorf("unfurl: %s returned %s", u.Redacted(), resp.Status)
+	}
+	ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if ct != "text/html" && ct != "application/xhtml+xml" {
+		return preview, errNotHTML
+	}
+	page, err := io.ReadAll(io.LimitReader(resp.Body, maxPageBytes))
+	if err != nil {
+		return preview, err
+	}
+	// relative image URLs resolve against the page the redirects ended at
+	parsePage(&preview, resp.Request.URL, string(page))
+	if preview.Title == nil && preview.Description == nil {
+		return preview, errNoMetadata
+	}
+	return preview, nil
+}
+
+var (
+	metaPattern  = regexp.MustCompile(`(?is)<meta\s([^>]*)>`)
+	attrPattern  = regexp.MustCompile(`(?is)([a-z][a-z0-9:_-]*)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	headEnd      = regexp.MustCompile(`(?i)</head>|<body[\s>]`)
+)
+
+// parsePage fills p from the page's <meta> tags: OpenGraph first, then Twitter cards and the
+// plain description and <title>.
+func parsePage(p *store.LinkPreview, base *url.URL, page string) {
+	if loc := headEnd.FindStringIndex(page); loc != nil {
+		page = page[:loc[0]]
+	}
+	page = strings.ToValidUTF8(page, "")
+
+	meta := map[string]string{}
+	for _, tag := range metaPattern.FindAllStringSubmatch(page, -1) {
+		attrs := map[string]string{}
+		for _, a := range attrPattern.FindAllStringSubmatch(tag[1], -1) {
+			attrs[strings.ToLower(a[1])] = a[2] + a[3] + a[4]
+		}
+		key := attrs["property"]
+		if key == "" {
+			key = attrs["name"]
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if _, seen := meta[key]; key != "" && !seen {
+			meta[key] = attrs["content"]
+		}
+	}
+	first := func(limit int, values ...string) *string {
+		for _, v := range values {
+			if v = clean(v, limit); v != "" {
+				return &v
+			}
+		}
+		return nil
+	}
+
+	var title string
+	if m := titlePattern.FindStringSubmatch(page); m != nil {
+		title = m[1]
+	}
+	p.Title = first(maxTitleLen, meta["og:title"], meta["twitter:title"], title)
+	p.Description = first(maxDescriptionLen, meta["og:description"], meta["twitter:description"], meta["description"])
+	p.SiteName = first(maxSiteNameLen, meta["og:site_name"], base.Hostname())
+	for _, v := range []string{meta["og:image:secure_url"], meta["og:image"], meta["og:image:url"], meta["twitter:image"]} {
+		if img := imageURL(base, v); img != "" {
+			p.ImageURL = &img
+			break
+		}
+	}
+}
+
+// clean unescapes entities, collapses whitespace and truncates to limit runes.
+func clean(s string, limit int) string {
+	s = strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+	if utf8.RuneCountInString(s) > limit {
+		s = string([]rune(s)[:limit-1]) + "…"
+	}
+	return s
+}
+
+// imageURL resolves an image reference against the page URL; only http(s) images are kept.
+func imageURL(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(html.UnescapeString(ref))
+	if ref == "" || len(ref) > maxURLLength {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.User != nil {
+		return ""
+	}
+	return u.String()
+}
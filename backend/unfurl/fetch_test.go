@@ -0,0 +1,211 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Y3rnur/go-realtime-chat/backend/netguard"
+)
+
+func allowAll(netip.Addr) bool { return true }
+
+// testFetcher returns a fetcher checking addresses with allow that sends every connection to
+// srv, so URLs can use made-up host names on the default port.
+func testFetcher(srv *httptest.Server, allow func(netip.Addr) bool, timeout time.Duration) *Fetcher {
+	f := newFetcher(allow, timeout)
+	tr := f.client.Transport.(*http.Transport)
+	dial := tr.DialContext
+	tr.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return dial(ctx, network, srv.Listener.Addr().String())
+	}
+	return f
+}
+
+func htmlHandler(page string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, page)
+	}
+}
+
+func str(p *string) string {
+	if p == nil {
+		return "<nil>"
+	}
+	return *p
+}
+
+func TestFetchMetadata(t *testing.T) {
+	tests := []struct {
+		name                         string
+		page                         string
+		title, desc, image, siteName string
+	}{
+		{
+			name: "opengraph",
+			page: `<html><head><title>Plain</title>
+				<meta property="og:title" content="OG &amp; title">
+				<meta name="twitter:title" content="Twitter title">
+				<meta property="og:description" content="  OG
+					description ">
+				<meta property="og:site_name" content="Example">
+				<meta property="og:image" content="https://cdn.example/a.png">
+				</head><body><meta property="og:title" content="in the body"></body></html>`,
+			title: "OG & title", desc: "OG description", image: "https://cdn.example/a.png", siteName: "Example",
+		},
+		{
+			name: "twitter card",
+			page: `<head><title>Plain</title>
+				<meta name="twitter:title" content='Twitter title'>
+				<meta name="twitter:description" content="Twitter description">
+				<meta name="description" content="Plain description">
+				<meta name="twitter:image" content="//cdn.example/t.png"></head>`,
+			title: "Twitter title", desc: "Twitter description", image: "http://cdn.example/t.png", siteName: "site.test",
+		},
+		{
+			name:  "title fallback",
+			page:  `<HEAD><TITLE> Just a  page </TITLE><meta name=description content=plain></HEAD>`,
+			title: "Just a page", desc: "plain", image: "<nil>", siteName: "site.test",
+		},
+		{
+			name:  "relative image",
+			page:  `<head><meta property="og:title" content="t"><meta property="og:image" content="../img/a.png?x=1&amp;y=2"></head>`,
+			title: "t", desc: "<nil>", image: "http://site.test/img/a.png?x=1&y=2", siteName: "site.test",
+		},
+		{
+			name:  "unsafe image",
+			page:  `<head><meta property="og:title" content="t"><meta property="og:image" content="javascript:alert(1)"></head>`,
+			title: "t", desc: "<nil>", image: "<nil>", siteName: "site.test",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(htmlHandler(tt.page))
+			defer srv.Close()
+			p, err := testFetcher(srv, allowAll, fetchTimeout).Fetch(context.Background(), "http://site.test/pages/a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if str(p.Title) != tt.title || str(p.Description) != tt.desc || str(p.ImageURL) != tt.image || str(p.SiteName) != tt.siteName {
+				t.Fatalf("got title %q, description %q, image %q, site %q", str(p.Title), str(p.Description), str(p.ImageURL), str(p.SiteName))
+			}
+		})
+	}
+}
+
+func TestFetchRejects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"title":"t"}`)
+	})
+	// the title is past the part of the page that is read
+	mux.Handle("/large", htmlHandler("<head>"+strings.Repeat(" ", maxPageBytes)+"<title>late</title></head>"))
+	mux.Handle("/empty", htmlHandler("<head></head><body>no metadata</body>"))
+	mux.HandleFunc("/missing", http.NotFound)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	f := testFetcher(srv, allowAll, fetchTimeout)
+
+	tests := []struct {
+		url  string
+		want error
+	}{
+		{"http://site.test/json", errNotHTML},
+		{"http://site.test/large", errNoMetadata},
+		{"http://site.test/empty", errNoMetadata},
+		{"http://site.test/missing", nil},
+		{"ftp://site.test/file", nil},
+		{"http://site.test:8080/", nil},
+		{"http://user:pw@site.test/", nil},
+	}
+	for _, tt := range tests {
+		_, err := f.Fetch(context.Background(), tt.url)
+		if err == nil || (tt.want != nil && !errors.Is(err, tt.want)) {
+			t.Errorf("%s: err = %v, want %v", tt.url, err, tt.want)
+		}
+	}
+}
+
+func TestFetchRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	// /r/{n} is n+1 redirects away from the page
+	mux.HandleFunc("/r/{n}", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.PathValue("n"))
+		if n == 0 {
+			http.Redirect(w, r, "/final/page", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/r/"+strconv.Itoa(n-1), http.StatusFound)
+	})
+	mux.Handle("/final/page", htmlHandler(`<head><meta property="og:title" content="t"><meta property="og:image" content="a.png"></head>`))
+	mux.HandleFunc("/port", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://site.test:8080/final/page", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	f := testFetcher(srv, allowAll, fetchTimeout)
+
+	p, err := f.Fetch(context.Background(), fmt.Sprintf("http://site.test/r/%d", maxRedirects-1))
+	if err != nil {
+		t.Fatalf("%d redirects: %v", maxRedirects, err)
+	}
+	// the image resolves against the page the redirects ended at
+	if str(p.ImageURL) != "http://site.test/final/a.png" {
+		t.Fatalf("image %q", str(p.ImageURL))
+	}
+	if _, err := f.Fetch(context.Background(), fmt.Sprintf("http://site.test/r/%d", maxRedirects)); err == nil || !strings.Contains(err.Error(), "too many redirects") {
+		t.Fatalf("%d redirects: err = %v", maxRedirects+1, err)
+	}
+	// redirects are held to the same URL rules
+	if _, err := f.Fetch(context.Background(), "http://site.test/port"); err == nil || !strings.Contains(err.Error(), "port 8080") {
+		t.Fatalf("redirect to another port: err = %v", err)
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	start := time.Now()
+	_, err := testFetcher(srv, allowAll, 100*time.Millisecond).Fetch(context.Background(), "http://site.test/slow")
+	if err == nil {
+		t.Fatal("slow page fetched")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("gave up after %v", d)
+	}
+}
+
+func TestFetchBlocksLoopback(t *testing.T) {
+	requested := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	}))
+	defer srv.Close()
+
+	// the default check, with the host name resolving to the loopback test server
+	_, err := testFetcher(srv, netguard.PublicAddr, fetchTimeout).Fetch(context.Background(), "http://site.test/")
+	if !errors.Is(err, netguard.ErrBlockedAddress) {
+		t.Fatalf("err = %v, want %v", err, netguard.ErrBlockedAddress)
+	}
+	if requested {
+		t.Fatal("request reached the server")
+	}
+}
@@ -0,0 +1,194 @@
+// Package unfurl adds link previews to messages: a background worker fetches the OpenGraph
+// metadata of the URLs in new messages, caches it and attaches it to the message.
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+// EventMessageUpdated is published when a message got its link previews.
+const EventMessageUpdated = "message_updated"
+
+const (
+	// previews per message; further links are left alone
+	maxPreviews = 3
+	// how long fetched pages (and failures) are cached
+	cacheTTL       = 24 * time.Hour
+	failedCacheTTL = time.Hour
+)
+
+// EventPublisher is the part of the hub the worker needs.
+type EventPublisher interface {
+	PublishEvent(convID string, v interface{}) error
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"'` + "`" + `]+`)
+
+// trailing punctuation that ends a sentence rather than the link
+const linkTrailer = ".,;:!?)]}'\""
+
+// ExtractURLs returns the distinct http(s) URLs in body, in order, at most maxPreviews.
+func ExtractURLs(body string) []string {
+	var out []string
+	seen := map[string]bool{}
+	for _, link := range linkPattern.FindAllString(body, -1) {
+		link = strings.TrimRight(link, linkTrailer)
+		if len(link) > maxURLLength || seen[link] {
+			continue
+		}
+		seen[link] = true
+		out = append(out, link)
+		if len(out) == maxPreviews {
+			break
+		}
+	}
+	return out
+}
+
+type job struct {
+	messageID uuid.UUID
+	urls      []string
+}
+
+// Worker unfurls the links of queued messages in the background.
+type Worker struct {
+	pool      *pgxpool.Pool
+	fetcher   *Fetcher
+	publisher EventPublisher
+
+	queue chan job
+	once  sync.Once
+}
+
+func NewWorker(pool *pgxpool.Pool, fetcher *Fetcher, publisher EventPublisher) *Worker {
+	return &Worker{
+		pool:      pool,
+		fetcher:   fetcher,
+		publisher: publisher,
+		queue:     make(chan job, 256),
+	}
+}
+
+// Start runs the workers until ctx is cancelled, and prunes expired cache entries hourly.
+func (w *Worker) Start(ctx context.Context, workers int) {
+	w.once.Do(func() {
+		if workers <= 0 {
+			workers = 2
+		}
+		for i := 0; i < workers; i++ {
+			go w.run(ctx)
+		}
+		go w.prune(ctx)
+	})
+}
+
+// Enqueue schedules the links of m for unfurling; messages without links are skipped. Unlike
+// attachment processing it never blocks: previews are optional, so a full queue drops them.
+func (w *Worker) Enqueue(m store.Message) {
+	if m.Body == nil || m.IsDeleted {
+		return
+	}
+	urls := ExtractURLs(*m.Body)
+	if len(urls) == 0 {
+		return
+	}
+	select {
+	case w.queue <- job{messageID: m.ID, urls: urls}:
+	default:
+		log.Printf("unfurl: queue full, skipping previews of message %s", m.ID)
+	}
+}
+
+func (w *Worker) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-w.queue:
+			w.process(ctx, j)
+		}
+	}
+}
+
+func (w *Worker) process(ctx context.Context, j job) {
+	var previews []store.LinkPreview
+	for _, u := range j.urls {
+		if p, ok := w.preview(ctx, u); ok {
+			previews = append(previews, p)
+		}
+	}
+	if len(previews) == 0 {
+		return
+	}
+	m, err := store.SetMessageLinkPreviews(ctx, w.pool, j.messageID, previews)
+	if errors.Is(err, store.ErrNotFound) {
+		// deleted in the meantime
+		return
+	}
+	if err != nil {
+		log.Printf("unfurl: save previews of message %s error: %v", j.messageID, err)
+		return
+	}
+	if w.publisher != nil {
+		payload := map[string]any{
+			"type":            EventMessageUpdated,
+			"conversation_id": m.ConversationID,
+			"message":         m,
+		}
+		if err := w.publisher.PublishEvent(m.ConversationID.String(), payload); err != nil {
+			log.Printf("unfurl: publish %s error: %v", EventMessageUpdated, err)
+		}
+	}
+}
+
+// preview returns the cached preview of rawURL, fetching (and caching) it when needed.
+func (w *Worker) preview(ctx context.Context, rawURL string) (store.LinkPreview, bool) {
+	p, ok, err := store.GetCachedLinkPreview(ctx, w.pool, rawURL, cacheTTL, failedCacheTTL)
+	if err == nil {
+		return p, ok
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		log.Printf("unfurl: cache lookup %s error: %v", rawURL, err)
+	}
+
+	fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	p, err = w.fetcher.Fetch(fetchCtx, rawURL)
+	ok = err == nil
+	if !ok {
+		log.Printf("unfurl: fetch %s: %v", rawURL, err)
+		p = store.LinkPreview{URL: rawURL}
+	}
+	if err := store.SaveLinkPreview(ctx, w.pool, p, ok); err != nil {
+		log.Printf("unfurl: cache %s error: %v", rawURL, err)
+	}
+	return p, ok
+}
+
+func (w *Worker) prune(ctx context.Context) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		if n, err := store.PruneLinkPreviews(ctx, w.pool, time.Now().Add(-cacheTTL)); err != nil {
+			log.Printf("unfurl: prune cache error: %v", err)
+		} else if n > 0 {
+			log.Printf("unfurl: pruned %d cached preview(s)", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package unfurl
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+	"github.com/Y3rnur/go-realtime-chat/backend/testdb"
+)
+
+type recordingPublisher struct {
+	mu     sync.Mutex
+	events []map[string]any
+}
+
+func (p *recordingPublisher) PublishEvent(convID string, v interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, v.(map[string]any))
+	return nil
+}
+
+func TestWorkerProcess(t *testing.T) {
+	pool := testdb.Pool(t)
+	ctx := context.Background()
+	alice := testdb.CreateUser(t, pool, "alice@example.com")
+	bob := testdb.CreateUser(t, pool, "bob@example.com")
+	conv, err := store.CreateConversation(ctx, pool, nil, false, alice, []uuid.UUID{bob})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	hits := map[string]int{}
+	mux := http.NewServeMux()
+	mux.Handle("/page", htmlHandler(`<head><meta property="og:title" content="A page"></head>`))
+	mux.HandleFunc("/missing", http.NotFound)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	fetched := func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return hits[path]
+	}
+	pub := &recordingPublisher{}
+	w := NewWorker(pool, testFetcher(srv, allowAll, fetchTimeout), pub)
+
+	send := func(body string) store.Message {
+		t.Helper()
+		m, err := store.SaveMessage(ctx, pool, conv.ID, alice, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.process(ctx, job{messageID: m.ID, urls: ExtractURLs(body)})
+		return m
+	}
+
+	// a preview is saved on the message and announced as message_updated
+	m := send("look: http://site.test/page and http://site.test/missing.")
+	if len(pub.events) != 1 {
+		t.Fatalf("%d events published", len(pub.events))
+	}
+	ev := pub.events[0]
+	updated, _ := ev["message"].(store.Message)
+	if ev["type"] != EventMessageUpdated || updated.ID != m.ID {
+		t.Fatalf("event %+v", ev)
+	}
+	if len(updated.LinkPreviews) != 1 || str(updated.LinkPreviews[0].Title) != "A page" {
+		t.Fatalf("previews %+v", updated.LinkPreviews)
+	}
+
+	// both results are cached: the page and the failure aren't fetched again
+	send("again http://site.test/page http://site.test/missing")
+	if fetched("/page") != 1 || fetched("/missing") != 1 {
+		t.Fatalf("fetched /page %d times, /missing %d times", fetched("/page"), fetched("/missing"))
+	}
+	if len(pub.events) != 2 {
+		t.Fatalf("%d events published", len(pub.events))
+	}
+	if _, ok, err := store.GetCachedLinkPreview(ctx, pool, "http://site.test/missing", cacheTTL, failedCacheTTL); err != nil || ok {
+		t.Fatalf("cached failure: ok %v, err %v", ok, err)
+	}
+
+	// only failures: nothing is saved or published
+	send("http://site.test/missing")
+	if len(pub.events) != 2 || fetched("/missing") != 1 {
+		t.Fatalf("failure only: %d events, /missing fetched %d times", len(pub.events), fetched("/missing"))
+	}
+}
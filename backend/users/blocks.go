@@ -0,0 +1,103 @@
+package users
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Y3rnur/go-realtime-chat/backend"
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+// BlocksNotifier is told when a user's block list changes (the hub caches block lists).
+type BlocksNotifier interface {
+	BlocksChanged(userID string)
+}
+
+// BlocksHandler lists (GET) the caller's block list and blocks a user (POST {"user_id"}).
+// Route: /api/blocks
+func BlocksHandler(pool *pgxpool.Pool, notifier BlocksNotifier) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		uid, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+		if err != nil {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			blocked, err := store.GetBlockedUsers(r.Context(), pool, uid)
+			if err != nil {
+				http.Error(w, "database error", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, blocked)
+
+		case http.MethodPost:
+			var req struct {
+				UserID uuid.UUID `json:"user_id"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID == uuid.Nil {
+				http.Error(w, "user_id required", http.StatusBadRequest)
+				return
+			}
+			if req.UserID == uid {
+				http.Error(w, "you can't block yourself", http.StatusBadRequest)
+				return
+			}
+			changed, err := store.BlockUser(r.Context(), pool, uid, req.UserID)
+			if errors.Is(err, store.ErrNotFound) {
+				http.Error(w, "user not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("users: block %s by %s error: %v", req.UserID, uid, err)
+				http.Error(w, "database error", http.StatusInternalServerError)
+				return
+			}
+			status := http.StatusOK
+			if changed {
+				notifier.BlocksChanged(uid.String())
+				status = http.StatusCreated
+			}
+			writeJSON(w, status, map[string]any{"user_id": req.UserID, "blocked": true})
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// BlockHandler unblocks a user. Route: DELETE /api/blocks/{id}
+func BlockHandler(pool *pgxpool.Pool, notifier BlocksNotifier) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uid, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+		if err != nil {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+		blockedID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid user id", http.StatusBadRequest)
+			return
+		}
+		changed, err := store.UnblockUser(r.Context(), pool, uid, blockedID)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		if !changed {
+			http.Error(w, "user is not blocked", http.StatusNotFound)
+			return
+		}
+		notifier.BlocksChanged(uid.String())
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
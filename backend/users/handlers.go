@@ -0,0 +1,352 @@
+package users
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/Y3rnur/go-realtime-chat/backend"
+	"github.com/Y3rnur/go-realtime-chat/backend/attachments"
+	"github.com/Y3rnur/go-realtime-chat/backend/storage"
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+// EventUserUpdated is sent to everyone sharing a conversation with a user whose profile changed.
+const EventUserUpdated = "user_updated"
+
+// default avatar upload limit (AVATAR_MAX_BYTES overrides it)
+const defaultAvatarMaxBytes = 5 << 20
+
+// field limits (characters)
+const maxNameLength = 64
+
+// UserEventPublisher is the part of the hub profile updates need.
+type UserEventPublisher interface {
+	PublishUserEvent(userID string, v interface{}) error
+}
+
+// AvatarMaxBytes returns the maximum accepted avatar upload size.
+func AvatarMaxBytes() int64 {
+	if v, err := strconv.ParseInt(os.Getenv("AVATAR_MAX_BYTES"), 10, 64); err == nil && v > 0 {
+		return v
+	}
+	return defaultAvatarMaxBytes
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// publicProfile is what other users see.
+func publicProfile(p store.Profile) store.Profile {
+	p.Email = nil
+	return p
+}
+
+// announce sends user_updated to every user sharing a conversation with p (and p's own other sessions),
+// so clients refresh the author names and avatars cached in message payloads.
+func announce(pool *pgxpool.Pool, events UserEventPublisher, p store.Profile) {
+	go func() {
+		ids, err := store.GetContactIDs(context.Background(), pool, p.ID)
+		if err != nil {
+			log.Printf("users: load contacts of %s error: %v", p.ID, err)
+			return
+		}
+		payload := map[string]any{
+			"type": EventUserUpdated,
+			"user": publicProfile(p),
+		}
+		for _, id := range ids {
+			if err := events.PublishUserEvent(id.String(), payload); err != nil {
+				log.Printf("users: publish %s (user %s) error: %v", EventUserUpdated, id, err)
+			}
+		}
+	}()
+}
+
+// removeUploadedAvatar deletes the blob behind a replaced avatar URL if it was uploaded here.
+func removeUploadedAvatar(ctx context.Context, st storage.Storage, avatarURL *string) {
+	if avatarURL == nil || st == nil {
+		return
+	}
+	file, ok := strings.CutPrefix(*avatarURL, attachments.AvatarPathPrefix)
+	if !ok || !attachments.ValidAvatarFile(file) {
+		return
+	}
+	if err := st.Delete(ctx, "avatars/"+file); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		log.Printf("users: delete avatar %s error: %v", file, err)
+	}
+}
+
+// MeHandler returns (GET) and updates (PATCH {"display_name", "first_name", "last_name", "avatar_url"})
+// the caller's profile. Route: /api/me
+func MeHandler(pool *pgxpool.Pool, st storage.Storage, events UserEventPublisher) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPatch {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uid, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+		if err != nil {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			p, err := store.GetProfile(r.Context(), pool, uid)
+			if errors.Is(err, store.ErrNotFound) {
+				http.Error(w, "user not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				http.Error(w, "database error", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, p)
+			return
+		}
+
+		upd, err := decodeProfileUpdate(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p, previous, err := store.UpdateProfile(r.Context(), pool, uid, upd)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("users: update profile %s error: %v", uid, err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		if upd.AvatarURL != nil && !sameURL(previous, p.AvatarURL) {
+			removeUploadedAvatar(r.Context(), st, previous)
+		}
+		announce(pool, events, p)
+		writeJSON(w, http.StatusOK, p)
+	})
+}
+
+func sameURL(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func decodeProfileUpdate(r *http.Request) (store.ProfileUpdate, error) {
+	var req struct {
+		DisplayName *string `json:"display_name"`
+		FirstName   *string `json:"first_name"`
+		LastName    *string `json:"last_name"`
+		AvatarURL   *string `json:"avatar_url"`
+	}
+	var upd store.ProfileUpdate
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return upd, errors.New("invalid request")
+	}
+	if req.DisplayName == nil && req.FirstName == nil && req.LastName == nil && req.AvatarURL == nil {
+		return upd, errors.New("nothing to update")
+	}
+	clean := func(field string, v *string) (*string, error) {
+		if v == nil {
+			return nil, nil
+		}
+		s := strings.Join(strings.Fields(*v), " ")
+		if utf8.RuneCountInString(s) > maxNameLength {
+			return nil, errors.New(field + " is too long")
+		}
+		return &s, nil
+	}
+	var err error
+	if upd.DisplayName, err = clean("display_name", req.DisplayName); err != nil {
+		return upd, err
+	}
+	if upd.DisplayName != nil && *upd.DisplayName == "" {
+		return upd, errors.New("display_name can't be empty")
+	}
+	if upd.FirstName, err = clean("first_name", req.FirstName); err != nil {
+		return upd, err
+	}
+	if upd.LastName, err = clean("last_name", req.LastName); err != nil {
+		return upd, err
+	}
+	if req.AvatarURL != nil {
+		a := strings.TrimSpace(*req.AvatarURL)
+		if a != "" && !attachments.ValidAvatarURL(a) {
+			return upd, errors.New("avatar_url must be an uploaded image or an https URL on an allowed host")
+		}
+		upd.AvatarURL = &a
+	}
+	return upd, nil
+}
+
+// UserHandler returns another user's public profile. Route: GET /api/users/{id}
+func UserHandler(pool *pgxpool.Pool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uid, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+		if err != nil {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+		id, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid user id", http.StatusBadRequest)
+			return
+		}
+		p, err := store.GetProfile(r.Context(), pool, id)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "user not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		if id != uid {
+			p = publicProfile(p)
+		}
+		writeJSON(w, http.StatusOK, p)
+	})
+}
+
+// AvatarUploadHandler sets the caller's avatar from a multipart "file" upload (JPEG, PNG or GIF,
+// at most AvatarMaxBytes). The image is cropped, scaled and re-encoded without metadata.
+// Route: POST /api/me/avatar
+func AvatarUploadHandler(pool *pgxpool.Pool, st storage.Storage, events UserEventPublisher) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uid, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+		if err != nil {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+
+		maxBytes := AvatarMaxBytes()
+		r.Body = http.MaxBytesReader(w, r.Body, maxBytes+64<<10)
+		if err := r.ParseMultipartForm(maxBytes); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "avatar too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "invalid multipart form", http.StatusBadRequest)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "file required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		if header.Size <= 0 {
+			http.Error(w, "file is empty", http.StatusBadRequest)
+			return
+		}
+		if header.Size > maxBytes {
+			http.Error(w, "avatar too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(file, maxBytes+1))
+		if err != nil {
+			http.Error(w, "unreadable file", http.StatusBadRequest)
+			return
+		}
+
+		img, contentType, err := attachments.ProcessAvatar(data)
+		if errors.Is(err, attachments.ErrUnsupportedImage) {
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+		if err != nil {
+			log.Printf("users: process avatar of %s error: %v", uid, err)
+			http.Error(w, "could not process image", http.StatusInternalServerError)
+			return
+		}
+		ext := ".png"
+		if contentType == "image/jpeg" {
+			ext = ".jpg"
+		}
+		name := uuid.NewString() + ext
+		if err := st.Put(r.Context(), "avatars/"+name, bytes.NewReader(img), int64(len(img)), contentType); err != nil {
+			log.Printf("users: store avatar error: %v", err)
+			http.Error(w, "storage error", http.StatusInternalServerError)
+			return
+		}
+
+		avatarURL := attachments.AvatarPathPrefix + name
+		p, previous, err := store.UpdateProfile(r.Context(), pool, uid, store.ProfileUpdate{AvatarURL: &avatarURL})
+		if err != nil {
+			removeUploadedAvatar(r.Context(), st, &avatarURL)
+			if errors.Is(err, store.ErrNotFound) {
+				http.Error(w, "user not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		removeUploadedAvatar(r.Context(), st, previous)
+		announce(pool, events, p)
+		writeJSON(w, http.StatusOK, p)
+	})
+}
+
+// AvatarHandler serves uploaded avatars. Route: GET /api/avatars/{file}
+func AvatarHandler(st storage.Storage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		file := r.PathValue("file")
+		if !attachments.ValidAvatarFile(file) {
+			http.Error(w, "avatar not found", http.StatusNotFound)
+			return
+		}
+		blob, err := st.Get(r.Context(), "avatars/"+file)
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, "avatar not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "storage error", http.StatusInternalServerError)
+			return
+		}
+		defer blob.Close()
+		contentType := "image/png"
+		if strings.HasSuffix(file, ".jpg") {
+			contentType = "image/jpeg"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		// avatar files are never rewritten: a new upload gets a new name
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+		if r.Method == http.MethodHead {
+			return
+		}
+		_, _ = io.Copy(w, blob)
+	})
+}
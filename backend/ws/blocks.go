@@ -0,0 +1,190 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
+)
+
+// EventBlocksChanged tells a user's connections (on every instance) that their block list changed.
+const EventBlocksChanged = "blocks_changed"
+
+// how long a user's block list is used before it is reloaded in the background (changes are
+// also pushed with EventBlocksChanged; this only bounds how stale a missed one can get)
+const blockCacheTTL = 5 * time.Minute
+
+// blockList is the cached block list of a user with connections on this instance.
+type blockList struct {
+	ids map[string]bool
+	// when the list was read; older reads never replace newer ones
+	loaded time.Time
+	// a background reload is running
+	reloading bool
+}
+
+// eventAuthor returns the author of the content a conversation payload carries: a message (or
+// the message an event carries). Coalesced events (reactions) list their users in user_ids,
+// which is returned as users. Who triggered an event (user_id) doesn't count: dropping pin or
+// conversation changes because a blocked user made them would leave the blocker's state stale.
+func eventAuthor(payload []byte) (author string, users []string) {
+	var p struct {
+		AuthorID string   `json:"author_id"`
+		UserIDs  []string `json:"user_ids"`
+		Message  *struct {
+			AuthorID string `json:"author_id"`
+		} `json:"message"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return "", nil
+	}
+	switch {
+	case p.Message != nil && p.Message.AuthorID != "":
+		return p.Message.AuthorID, nil
+	case p.AuthorID != "":
+		return p.AuthorID, nil
+	}
+	return "", p.UserIDs
+}
+
+// deliver sends a conversation payload to each client, skipping recipients who blocked its
+// author. Coalesced events reach them without the blocked users, or not at all when only
+// blocked users are left. Block lists come from the cache; the database is never queried here.
+func (h *Hub) deliver(clients []*Client, payload []byte) {
+	author, users := eventAuthor(payload)
+	if author == "" && len(users) == 0 {
+		for _, c := range clients {
+			c.send(payload)
+		}
+		return
+	}
+	for _, c := range clients {
+		blocked := h.blockedBy(c.userID)
+		if len(users) > 0 {
+			if p := withoutUsers(payload, users, blocked); p != nil {
+				c.send(p)
+			}
+			continue
+		}
+		if author != c.userID && blocked[author] {
+			continue
+		}
+		c.send(payload)
+	}
+}
+
+// withoutUsers returns payload with the blocked users removed from its user_ids, or nil when
+// none are left.
+func withoutUsers(payload []byte, users []string, blocked map[string]bool) []byte {
+	keep := slices.DeleteFunc(slices.Clone(users), func(id string) bool { return blocked[id] })
+	if len(keep) == len(users) {
+		return payload
+	}
+	if len(keep) == 0 {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil
+	}
+	ids, err := json.Marshal(keep)
+	if err != nil {
+		return nil
+	}
+	fields["user_ids"] = ids
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// blockedBy returns the cached block list of viewer. A missing or expired list is reloaded in
+// the background; until then the cached one (or none) is used.
+func (h *Hub) blockedBy(viewer string) map[string]bool {
+	if h.pool == nil {
+		return nil
+	}
+	h.blocksMu.Lock()
+	bl, ok := h.blocks[viewer]
+	stale := !ok || time.Since(bl.loaded) > blockCacheTTL
+	if stale && !bl.reloading {
+		bl.reloading = true
+		h.blocks[viewer] = bl
+		go h.loadBlocks(viewer)
+	}
+	h.blocksMu.Unlock()
+	return bl.ids
+}
+
+// loadBlocks reads the block list of viewer into the cache. It is called when a user connects
+// and in the background, never from the delivery path.
+func (h *Hub) loadBlocks(viewer string) {
+	uid, err := uuid.Parse(viewer)
+	if err != nil || h.pool == nil {
+		return
+	}
+	started := time.Now()
+	ctx, cancel := context.WithTimeout(h.ctx, 2*time.Second)
+	defer cancel()
+	ids, err := store.GetBlockedIDs(ctx, h.pool, uid)
+	if err != nil {
+		// delivering unfiltered beats dropping messages; retried on the next delivery
+		log.Printf("hub: load block list of %s error: %v", viewer, err)
+		h.blocksMu.Lock()
+		if bl, ok := h.blocks[viewer]; ok {
+			bl.reloading = false
+			h.blocks[viewer] = bl
+		}
+		h.blocksMu.Unlock()
+		return
+	}
+	bl := blockList{ids: make(map[string]bool, len(ids)), loaded: started}
+	for _, id := range ids {
+		bl.ids[id.String()] = true
+	}
+
+	connected := h.hasClients(viewer)
+	h.blocksMu.Lock()
+	defer h.blocksMu.Unlock()
+	if cur, ok := h.blocks[viewer]; ok && cur.loaded.After(started) {
+		return
+	}
+	if !connected {
+		delete(h.blocks, viewer)
+		return
+	}
+	h.blocks[viewer] = bl
+}
+
+// preloadBlocks loads the block list of a newly connected user unless it is cached already.
+func (h *Hub) preloadBlocks(userID string) {
+	h.blocksMu.Lock()
+	_, ok := h.blocks[userID]
+	h.blocksMu.Unlock()
+	if !ok {
+		h.loadBlocks(userID)
+	}
+}
+
+// dropBlocks forgets the block list of a user whose last connection closed.
+func (h *Hub) dropBlocks(userID string) {
+	h.blocksMu.Lock()
+	delete(h.blocks, userID)
+	h.blocksMu.Unlock()
+}
+
+// BlocksChanged reloads the block list of userID here and, through the user's event channel,
+// on every other instance.
+func (h *Hub) BlocksChanged(userID string) {
+	go h.loadBlocks(userID)
+	payload := map[string]string{"type": EventBlocksChanged, "user_id": userID}
+	if err := h.PublishUserEvent(userID, payload); err != nil {
+		log.Printf("hub: publish %s error: %v", EventBlocksChanged, err)
+	}
+}
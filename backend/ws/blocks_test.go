@@ -0,0 +1,60 @@
+package ws
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestEventAuthor(t *testing.T) {
+	tests := []struct {
+		payload string
+		author  string
+		users   []string
+	}{
+		{`{"id":"m1","author_id":"a"}`, "a", nil},
+		{`{"type":"thread_reply","message":{"author_id":"a"},"user_id":"b"}`, "a", nil},
+		{`{"type":"message_pinned","message":{"author_id":"a"},"user_id":"b"}`, "a", nil},
+		// state changes are delivered whoever made them
+		{`{"type":"message_unpinned","message_id":"m1","user_id":"b"}`, "", nil},
+		{`{"type":"conversation_updated","user_id":"b"}`, "", nil},
+		{`{"type":"reaction_added","user_ids":["a","b"],"count":2}`, "", []string{"a", "b"}},
+		{`{"type":"conversation_updated"}`, "", nil},
+		{`not json`, "", nil},
+	}
+	for _, tt := range tests {
+		author, users := eventAuthor([]byte(tt.payload))
+		if author != tt.author || !slices.Equal(users, tt.users) {
+			t.Errorf("eventAuthor(%s) = %q, %v; want %q, %v", tt.payload, author, users, tt.author, tt.users)
+		}
+	}
+}
+
+func TestWithoutUsers(t *testing.T) {
+	payload := []byte(`{"type":"reaction_added","emoji":"👍","user_ids":["a","b","c"],"count":3}`)
+	users := []string{"a", "b", "c"}
+
+	if got := withoutUsers(payload, users, nil); string(got) != string(payload) {
+		t.Errorf("no blocks: payload changed to %s", got)
+	}
+	if got := withoutUsers(payload, users, map[string]bool{"a": true, "b": true, "c": true}); got != nil {
+		t.Errorf("all blocked: got %s, want nil", got)
+	}
+
+	got := withoutUsers(payload, users, map[string]bool{"b": true})
+	var p struct {
+		Type    string   `json:"type"`
+		Emoji   string   `json:"emoji"`
+		UserIDs []string `json:"user_ids"`
+		Count   int      `json:"count"`
+	}
+	if err := json.Unmarshal(got, &p); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(p.UserIDs, []string{"a", "c"}) || p.Type != "reaction_added" || p.Emoji != "👍" || p.Count != 3 {
+		t.Errorf("one blocked: got %s", got)
+	}
+	if !slices.Equal(users, []string{"a", "b", "c"}) {
+		t.Errorf("users modified: %v", users)
+	}
+}
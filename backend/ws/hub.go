@@ -3,19 +3,26 @@ package ws
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"math/rand"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
+
+	"github.com/Y3rnur/go-realtime-chat/backend/ratelimit"
+	"github.com/Y3rnur/go-realtime-chat/backend/store"
 )
 
 type Hub struct {
 	mu      sync.RWMutex
 	clients map[string]map[*Client]struct{}
+	// connections per user
+	users map[string]int
 
 	// Introduction of Redis
 	redis  *redis.Client
@@ -23,16 +30,26 @@ type Hub struct {
 	cancel context.CancelFunc
 
 	pool *pgxpool.Pool
+
+	// flood control for client frames (typing)
+	limiter *ratelimit.Limiter
+
+	// cached block lists (user -> blocked users) for per-recipient filtering
+	blocksMu sync.Mutex
+	blocks   map[string]blockList
 }
 
-func NewHub(redisClient *redis.Client, dbPool *pgxpool.Pool) *Hub {
+func NewHub(redisClient *redis.Client, dbPool *pgxpool.Pool, limiter *ratelimit.Limiter) *Hub {
 	ctx, cancel := context.WithCancel(context.Background())
 	h := &Hub{
 		clients: make(map[string]map[*Client]struct{}),
+		users:   make(map[string]int),
 		redis:   redisClient,
 		ctx:     ctx,
 		cancel:  cancel,
 		pool:    dbPool,
+		limiter: limiter,
+		blocks:  make(map[string]blockList),
 	}
 	if redisClient != nil {
 		go h.runPubSub()
@@ -52,18 +69,69 @@ func (h *Hub) AddClient(convID string, c *Client) {
 	if _, ok := h.clients[convID]; !ok {
 		h.clients[convID] = make(map[*Client]struct{})
 	}
+	if _, ok := h.clients[convID][c]; !ok {
+		h.users[c.userID]++
+	}
 	h.clients[convID][c] = struct{}{}
 }
 
 func (h *Hub) RemoveClient(convID string, c *Client) {
 	h.mu.Lock()
-	defer h.mu.Unlock()
+	last := false
 	if m, ok := h.clients[convID]; ok {
-		delete(m, c)
+		if _, ok := m[c]; ok {
+			delete(m, c)
+			h.users[c.userID]--
+			if h.users[c.userID] <= 0 {
+				delete(h.users, c.userID)
+				last = true
+			}
+		}
 		if len(m) == 0 {
 			delete(h.clients, convID)
 		}
 	}
+	h.mu.Unlock()
+	if last {
+		h.dropBlocks(c.userID)
+	}
+}
+
+// conversationClients returns a copy of the local clients of a conversation, so they can be
+// written to without holding the lock.
+func (h *Hub) conversationClients(convID string) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	out := make([]*Client, 0, len(h.clients[convID]))
+	for c := range h.clients[convID] {
+		out = append(out, c)
+	}
+	return out
+}
+
+// userClients returns a copy of the local connections of a user.
+func (h *Hub) userClients(userID string) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.users[userID] == 0 {
+		return nil
+	}
+	var out []*Client
+	for _, clients := range h.clients {
+		for c := range clients {
+			if c.userID == userID {
+				out = append(out, c)
+			}
+		}
+	}
+	return out
+}
+
+// hasClients reports whether userID has connections on this instance.
+func (h *Hub) hasClients(userID string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.users[userID] > 0
 }
 
 // publish message to redis channel for conversation
@@ -108,9 +176,7 @@ func (h *Hub) PublishMessage(convID string, v interface{}) error {
 
 // internal local broadcast
 func (h *Hub) broadcastLocal(convID string, v interface{}) {
-	h.mu.RLock()
-	clients := h.clients[convID]
-	h.mu.RUnlock()
+	clients := h.conversationClients(convID)
 	if len(clients) == 0 {
 		return
 	}
@@ -118,9 +184,7 @@ func (h *Hub) broadcastLocal(convID string, v interface{}) {
 	if err != nil {
 		return
 	}
-	for c := range clients {
-		c.send(b)
-	}
+	h.deliver(clients, b)
 }
 
 // run a pattern subscription and relay to local clients
@@ -147,29 +211,27 @@ func (h *Hub) runPubSub() {
 			payload := json.RawMessage(msg.Payload)
 
 			if scope == "conversation" {
-				h.mu.RLock()
-				clients := h.clients[id]
-				h.mu.RUnlock()
+				clients := h.conversationClients(id)
 				if len(clients) == 0 {
 					log.Printf("hub: received conversation events for conv %s but no local clients", id)
 					continue
 				}
-				for c := range clients {
-					c.send(payload)
-				}
+				h.deliver(clients, payload)
 				continue
 			}
 
 			if scope == "user" {
-				h.mu.RLock()
-				for _, clients := range h.clients {
-					for c := range clients {
-						if c.userID == id {
-							c.send(payload)
-						}
-					}
+				typ := eventType(payload)
+				if typ == EventBlocksChanged {
+					// reloaded off the pubsub loop
+					go h.loadBlocks(id)
+				}
+				for _, c := range h.userClients(id) {
+					c.send(payload)
+				}
+				if typ == EventSessionRevoked {
+					h.closeUser(id)
 				}
-				h.mu.RUnlock()
 				continue
 			}
 		}
@@ -199,14 +261,8 @@ func (h *Hub) PublishUserEvent(userID string, v interface{}) error {
 		if err != nil {
 			return err
 		}
-		h.mu.RLock()
-		defer h.mu.RUnlock()
-		for _, clients := range h.clients {
-			for c := range clients {
-				if c.userID == userID {
-					c.send(b)
-				}
-			}
+		for _, c := range h.userClients(userID) {
+			c.send(b)
 		}
 		return nil
 	}
@@ -248,8 +304,79 @@ func (h *Hub) UpdatePresence(convID, userID, status string) error {
 	return h.redis.Publish(h.ctx, chanName, b).Err()
 }
 
+// returns the users currently present in a conversation: local connections plus
+// (with Redis) unexpired "online" presence keys of other instances
+func (h *Hub) OnlineUsers(ctx context.Context, convID string) ([]string, error) {
+	seen := map[string]bool{}
+	h.mu.RLock()
+	for c := range h.clients[convID] {
+		seen[c.userID] = true
+	}
+	h.mu.RUnlock()
+
+	if h.redis != nil {
+		prefix := "presence:" + convID + ":"
+		iter := h.redis.Scan(ctx, 0, prefix+"*", 200).Iterator()
+		for iter.Next(ctx) {
+			key := iter.Val()
+			status, err := h.redis.Get(ctx, key).Result()
+			if err == nil && status == "online" {
+				seen[strings.TrimPrefix(key, prefix)] = true
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([]string, 0, len(seen))
+	for id := range seen {
+		out = append(out, id)
+	}
+	return out, nil
+}
+
+// returns the number of websocket connections on this instance and of distinct users behind them
+func (h *Hub) LocalStats() (connections, users int) {
+	seen := map[string]bool{}
+	h.mu.RLock()
+	for _, clients := range h.clients {
+		for c := range clients {
+			connections++
+			seen[c.userID] = true
+		}
+	}
+	h.mu.RUnlock()
+	return connections, len(seen)
+}
+
+// persists the user's read marker (drives unread counts in the conversation list)
+func (h *Hub) markRead(convID, userID string, lastReadID any) {
+	if h.pool == nil {
+		return
+	}
+	cid, err := uuid.Parse(convID)
+	if err != nil {
+		return
+	}
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return
+	}
+	var msgID *uuid.UUID
+	if s, ok := lastReadID.(string); ok {
+		if id, err := uuid.Parse(s); err == nil {
+			msgID = &id
+		}
+	}
+	if err := store.MarkConversationRead(h.ctx, h.pool, cid, uid, msgID); err != nil {
+		log.Printf("hub: mark read conv=%s user=%s error: %v", convID, userID, err)
+	}
+}
+
 // parses incoming WS client messages (typing/read/presence)
-func (h *Hub) HandleClientMessage(convID string, userID string, raw []byte) {
+func (h *Hub) HandleClientMessage(c *Client, raw []byte) {
+	convID, userID := c.convID, c.userID
 	var m map[string]any
 	if err := json.Unmarshal(raw, &m); err != nil {
 		log.Printf("hub: invalid client message: %v", err)
@@ -258,6 +385,13 @@ func (h *Hub) HandleClientMessage(convID string, userID string, raw []byte) {
 	typ, _ := m["type"].(string)
 	switch typ {
 	case "typing":
+		if err := h.allowTyping(convID, userID); err != nil {
+			var exceeded *ratelimit.Exceeded
+			if errors.As(err, &exceeded) {
+				c.sendError(exceeded.Fields())
+			}
+			return
+		}
 		payload := map[string]any{
 			"type":            "typing",
 			"conversation_id": convID,
@@ -277,6 +411,7 @@ func (h *Hub) HandleClientMessage(convID string, userID string, raw []byte) {
 		if lr, ok := m["last_read_id"]; ok {
 			payload["last_read_id"] = lr
 		}
+		h.markRead(convID, userID, m["last_read_id"])
 		if err := h.PublishEvent(convID, payload); err != nil {
 			log.Printf("hub: publish read error: %v", err)
 		}
@@ -293,3 +428,14 @@ func (h *Hub) HandleClientMessage(convID string, userID string, raw []byte) {
 		log.Printf("hub: unknown client message type: %q", typ)
 	}
 }
+
+// allowTyping applies the typing rate limits (per user, then per conversation).
+func (h *Hub) allowTyping(convID, userID string) error {
+	if h.limiter == nil {
+		return nil
+	}
+	if err := h.limiter.Allow(h.ctx, ratelimit.TypingPerUser, userID); err != nil {
+		return err
+	}
+	return h.limiter.Allow(h.ctx, ratelimit.TypingPerConversation, convID)
+}
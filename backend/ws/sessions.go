@@ -0,0 +1,49 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// EventSessionRevoked tells a user's connections that their sessions were ended (e.g. the account
+// was suspended); the hub closes those connections right after delivering it.
+const EventSessionRevoked = "session_revoked"
+
+// eventType returns the "type" of a JSON event payload.
+func eventType(payload []byte) string {
+	var p struct {
+		Type string `json:"type"`
+	}
+	_ = json.Unmarshal(payload, &p)
+	return p.Type
+}
+
+// DisconnectUser ends userID's websocket connections on every instance, telling the clients why.
+func (h *Hub) DisconnectUser(userID, reason string) {
+	payload := map[string]string{"type": EventSessionRevoked, "user_id": userID, "reason": reason}
+	if err := h.PublishUserEvent(userID, payload); err != nil {
+		log.Printf("hub: publish %s error: %v", EventSessionRevoked, err)
+	}
+	// with Redis every instance (this one included) closes them when the event comes back
+	if h.redis == nil {
+		h.closeUser(userID)
+	}
+}
+
+// closeUser closes the local connections of userID; their read loops then remove them from the hub.
+func (h *Hub) closeUser(userID string) {
+	conns := h.userClients(userID)
+	for _, c := range conns {
+		c.mu.Lock()
+		_ = c.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, EventSessionRevoked), time.Now().Add(time.Second))
+		_ = c.conn.Close()
+		c.mu.Unlock()
+	}
+	if len(conns) > 0 {
+		log.Printf("hub: closed %d connection(s) of user %s", len(conns), userID)
+	}
+}
@@ -1,6 +1,8 @@
 package ws
 
 import (
+	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"sync"
@@ -29,14 +31,33 @@ func (c *Client) send(b []byte) {
 	_ = c.conn.WriteMessage(websocket.TextMessage, b)
 }
 
+// sendError sends an "error" frame (e.g. rate limited) to this client only.
+func (c *Client) sendError(fields map[string]any) {
+	payload := map[string]any{"type": "error"}
+	for k, v := range fields {
+		payload[k] = v
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	c.send(b)
+}
+
 func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
-	// derive user from JWT (Authorization header, cookie, or ?token)
-	uidStr, err := backend.GetUserIDFromRequest(r)
+	// derive user from JWT (Authorization header, cookie, or ?token) or an API token
+	principal, err := backend.GetPrincipalFromRequest(r)
 	if err != nil {
 		http.Error(w, "unauthorized", http.StatusUnauthorized)
 		log.Printf("ws: upgrade unauthorized (token) from %s: %v", r.RemoteAddr, err)
 		return
 	}
+	if !principal.HasScope(backend.ScopeMessagesRead) {
+		http.Error(w, "missing scope: "+backend.ScopeMessagesRead, http.StatusForbidden)
+		log.Printf("ws: upgrade rejected - api token %s without %s", principal.TokenID, backend.ScopeMessagesRead)
+		return
+	}
+	uidStr := principal.UserID
 	uid, err := uuid.Parse(uidStr)
 	if err != nil {
 		http.Error(w, "invalid user", http.StatusBadRequest)
@@ -58,6 +79,23 @@ func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if h.pool != nil {
+		// suspended accounts keep no realtime access, even with a still-valid access token
+		active, err := store.IsUserActive(r.Context(), h.pool, uid)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			log.Printf("ws: upgrade rejected - unknown user %s", uidStr)
+			return
+		}
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			log.Printf("ws: account check error for user=%s: %v", uidStr, err)
+			return
+		}
+		if !active {
+			http.Error(w, "account suspended", http.StatusForbidden)
+			log.Printf("ws: upgrade rejected - user %s is suspended", uidStr)
+			return
+		}
 		ok, err := store.IsUserInConversation(r.Context(), h.pool, cid, uid)
 		if err != nil {
 			http.Error(w, "server error", http.StatusInternalServerError)
@@ -83,6 +121,8 @@ func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
 	log.Printf("ws: connected user=%s conv=%s remote=%s", uidStr, convID, r.RemoteAddr)
 	client := &Client{conn: conn, userID: uidStr, convID: convID}
 	h.AddClient(convID, client)
+	// the block list is cached before any delivery needs it
+	h.preloadBlocks(uidStr)
 
 	go func() {
 		defer func() {
@@ -95,7 +135,7 @@ func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 			// forwarding the client-sent messages (typing/read/presence) to hub
-			h.HandleClientMessage(convID, uidStr, msg)
+			h.HandleClientMessage(client, msg)
 		}
 	}()
 }
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_recovery_codes_user_id;
+
+DROP TABLE IF EXISTS recovery_codes;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS totp_last_step,
+    DROP COLUMN IF EXISTS totp_enabled,
+    DROP COLUMN IF EXISTS totp_secret;
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_webhook_deliveries_webhook_created_at;
+DROP INDEX IF EXISTS idx_outgoing_webhooks_conversation_id;
+
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS outgoing_webhooks;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS bot_owner_id,
+    DROP COLUMN IF EXISTS is_bot;
@@ -0,0 +1,35 @@
+ALTER TABLE users
+    ADD COLUMN is_bot BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN bot_owner_id UUID REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE TABLE outgoing_webhooks (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    bot_user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    commands TEXT[] NOT NULL DEFAULT '{}',
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE webhook_deliveries (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    webhook_id UUID NOT NULL REFERENCES outgoing_webhooks(id) ON DELETE CASCADE,
+    event_type TEXT NOT NULL,
+    attempt INT NOT NULL,
+    status_code INT,
+    error TEXT,
+    duration_ms INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_outgoing_webhooks_conversation_id ON outgoing_webhooks (conversation_id);
+CREATE INDEX idx_webhook_deliveries_webhook_created_at ON webhook_deliveries (webhook_id, created_at DESC);
+
+-- conversation creators become owners (admins of their conversation)
+UPDATE conversation_participants cp
+SET role = 'owner'
+FROM conversations c
+WHERE c.id = cp.conversation_id AND c.created_by = cp.user_id AND cp.role = 'member';
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_incoming_webhooks_conversation_id;
+
+DROP TABLE IF EXISTS incoming_webhooks;
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS attachment_thumbnails;
+DROP INDEX IF EXISTS idx_attachments_pending;
+
+ALTER TABLE attachments
+    DROP COLUMN IF EXISTS processed_at,
+    DROP COLUMN IF EXISTS height,
+    DROP COLUMN IF EXISTS width,
+    DROP COLUMN IF EXISTS status;
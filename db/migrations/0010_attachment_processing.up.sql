@@ -0,0 +1,20 @@
+-- images are processed in the background (metadata stripped, thumbnails generated);
+-- other attachments are ready immediately
+ALTER TABLE attachments
+    ADD COLUMN status TEXT NOT NULL DEFAULT 'ready',
+    ADD COLUMN width INT,
+    ADD COLUMN height INT,
+    ADD COLUMN processed_at TIMESTAMPTZ;
+
+CREATE INDEX idx_attachments_pending ON attachments (created_at) WHERE status = 'pending';
+
+CREATE TABLE attachment_thumbnails (
+    attachment_id UUID NOT NULL REFERENCES attachments(id) ON DELETE CASCADE,
+    size INT NOT NULL,
+    width INT NOT NULL,
+    height INT NOT NULL,
+    content_type TEXT NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    storage_key TEXT NOT NULL UNIQUE,
+    PRIMARY KEY (attachment_id, size)
+);
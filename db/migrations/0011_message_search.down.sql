@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_messages_body_tsv;
+
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS body_tsv;
@@ -0,0 +1,5 @@
+-- full-text search over message bodies
+ALTER TABLE messages
+    ADD COLUMN body_tsv tsvector GENERATED ALWAYS AS (to_tsvector('english', coalesce(body, ''))) STORED;
+
+CREATE INDEX idx_messages_body_tsv ON messages USING GIN (body_tsv);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_messages_parent_created_at;
+
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS last_reply_at,
+    DROP COLUMN IF EXISTS reply_count,
+    DROP COLUMN IF EXISTS parent_message_id;
@@ -0,0 +1,7 @@
+-- threaded replies: replies point at a top-level message, which keeps denormalized counters
+ALTER TABLE messages
+    ADD COLUMN parent_message_id UUID REFERENCES messages(id) ON DELETE CASCADE,
+    ADD COLUMN reply_count INT NOT NULL DEFAULT 0,
+    ADD COLUMN last_reply_at TIMESTAMPTZ;
+
+CREATE INDEX idx_messages_parent_created_at ON messages (parent_message_id, created_at) WHERE parent_message_id IS NOT NULL;
@@ -0,0 +1,5 @@
+-- quoted replies: the quoted message's preview is resolved at read time, so edits/deletions show up
+ALTER TABLE messages
+    ADD COLUMN reply_to_id UUID REFERENCES messages(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_messages_reply_to_id ON messages (reply_to_id) WHERE reply_to_id IS NOT NULL;
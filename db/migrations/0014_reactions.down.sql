@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_message_reactions_message_emoji;
+DROP TABLE IF EXISTS message_reactions;
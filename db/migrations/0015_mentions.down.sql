@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_message_mentions_user_created_at;
+DROP TABLE IF EXISTS message_mentions;
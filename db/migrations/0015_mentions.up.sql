@@ -0,0 +1,12 @@
+CREATE TABLE message_mentions (
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    conversation_id UUID NOT NULL REFERENCES conversations(id) ON DELETE CASCADE,
+    -- how the user was mentioned: 'user' (@name), 'here' or 'all'
+    kind TEXT NOT NULL DEFAULT 'user',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    read_at TIMESTAMPTZ,
+    PRIMARY KEY (message_id, user_id)
+);
+
+CREATE INDEX idx_message_mentions_user_created_at ON message_mentions (user_id, created_at DESC);
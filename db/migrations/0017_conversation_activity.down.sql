@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_conversations_activity;
+
+ALTER TABLE conversations
+    DROP COLUMN IF EXISTS last_message_preview,
+    DROP COLUMN IF EXISTS last_message_author_id,
+    DROP COLUMN IF EXISTS last_message_id,
+    DROP COLUMN IF EXISTS last_message_at;
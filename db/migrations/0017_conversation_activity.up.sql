@@ -0,0 +1,24 @@
+-- denormalized last activity of a conversation (its latest top-level message) for the conversation list
+ALTER TABLE conversations
+    ADD COLUMN last_message_at TIMESTAMPTZ,
+    ADD COLUMN last_message_id UUID REFERENCES messages(id) ON DELETE SET NULL,
+    ADD COLUMN last_message_author_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    ADD COLUMN last_message_preview TEXT;
+
+UPDATE conversations c
+SET last_message_at = m.created_at,
+    last_message_id = m.id,
+    last_message_author_id = m.author_id,
+    last_message_preview = coalesce(
+        left(nullif(m.body, ''), 140),
+        (SELECT '📎 ' || a.filename FROM attachments a WHERE a.message_id = m.id ORDER BY a.created_at LIMIT 1)
+    )
+FROM (
+    SELECT DISTINCT ON (conversation_id) id, conversation_id, author_id, body, created_at
+    FROM messages
+    WHERE parent_message_id IS NULL AND NOT is_deleted
+    ORDER BY conversation_id, created_at DESC, id DESC
+) m
+WHERE m.conversation_id = c.id;
+
+CREATE INDEX idx_conversations_activity ON conversations ((coalesce(last_message_at, created_at)) DESC, id DESC);
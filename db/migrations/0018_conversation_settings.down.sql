@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_participants_user_pinned;
+
+ALTER TABLE conversation_participants
+    DROP COLUMN IF EXISTS pinned_at,
+    DROP COLUMN IF EXISTS is_archived,
+    DROP COLUMN IF EXISTS muted_until;
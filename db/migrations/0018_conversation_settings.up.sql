@@ -0,0 +1,7 @@
+-- per-user conversation settings; is_muted (from 0001) is now honoured, optionally until muted_until
+ALTER TABLE conversation_participants
+    ADD COLUMN muted_until TIMESTAMPTZ,
+    ADD COLUMN is_archived BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN pinned_at TIMESTAMPTZ;
+
+CREATE INDEX idx_participants_user_pinned ON conversation_participants (user_id, pinned_at) WHERE pinned_at IS NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE conversations
+    ADD COLUMN description TEXT,
+    ADD COLUMN updated_at TIMESTAMPTZ;
@@ -0,0 +1,2 @@
+-- instance administrators (moderation queue, admin API); granted through ADMIN_EMAILS or the admin API
+ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,34 @@
+CREATE TABLE reports (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    reporter_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    -- the reported user (the author when a message is reported)
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    message_id UUID REFERENCES messages(id) ON DELETE SET NULL,
+    conversation_id UUID REFERENCES conversations(id) ON DELETE SET NULL,
+    -- message body at report time, kept as evidence after the message is deleted
+    message_body TEXT,
+    reason TEXT NOT NULL CHECK (reason IN ('spam', 'harassment', 'hate', 'inappropriate', 'other')),
+    details TEXT,
+    status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'resolved', 'dismissed')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    resolved_at TIMESTAMPTZ,
+    resolved_by UUID REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_reports_status_created ON reports (status, created_at DESC, id DESC);
+-- one open report per reporter and target
+CREATE UNIQUE INDEX idx_reports_open_unique ON reports (reporter_id, user_id, coalesce(message_id, '00000000-0000-0000-0000-000000000000'::uuid))
+    WHERE status = 'open';
+
+CREATE TABLE moderation_actions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    moderator_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    action TEXT NOT NULL,
+    report_id UUID REFERENCES reports(id) ON DELETE SET NULL,
+    target_user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    message_id UUID REFERENCES messages(id) ON DELETE SET NULL,
+    note TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_moderation_actions_created ON moderation_actions (created_at DESC, id DESC);
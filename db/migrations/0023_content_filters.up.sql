@@ -0,0 +1,11 @@
+-- per-conversation content filter settings; conversations without a row use the defaults
+CREATE TABLE conversation_content_filters (
+    conversation_id UUID PRIMARY KEY REFERENCES conversations(id) ON DELETE CASCADE,
+    profanity TEXT NOT NULL DEFAULT 'off' CHECK (profanity IN ('off', 'flag', 'rewrite', 'reject')),
+    links TEXT NOT NULL DEFAULT 'reject' CHECK (links IN ('off', 'flag', 'rewrite', 'reject')),
+    spam TEXT NOT NULL DEFAULT 'flag' CHECK (spam IN ('off', 'flag', 'reject')),
+    extra_words TEXT[] NOT NULL DEFAULT '{}',
+    blocked_domains TEXT[] NOT NULL DEFAULT '{}',
+    updated_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
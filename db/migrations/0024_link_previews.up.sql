@@ -0,0 +1,12 @@
+-- unfurled link metadata, cached per URL; failed fetches are cached too (ok = false) so
+-- unreachable links aren't fetched again for every message
+CREATE TABLE link_previews (
+    url TEXT PRIMARY KEY,
+    ok BOOLEAN NOT NULL,
+    title TEXT,
+    description TEXT,
+    image_url TEXT,
+    site_name TEXT,
+    fetched_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX idx_link_previews_fetched_at ON link_previews(fetched_at);
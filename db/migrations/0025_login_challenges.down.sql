@@ -0,0 +1,5 @@
+ALTER TABLE users
+    DROP COLUMN IF EXISTS totp_locked_until,
+    DROP COLUMN IF EXISTS totp_failed_attempts;
+
+DROP TABLE IF EXISTS login_challenges;
@@ -0,0 +1,15 @@
+-- second-factor challenges are single-use: the challenge JWT carries the id of its row
+CREATE TABLE login_challenges (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_login_challenges_user_id ON login_challenges (user_id);
+
+-- failed second-factor attempts; the account's 2FA step is locked for a while after too many
+ALTER TABLE users
+    ADD COLUMN totp_failed_attempts INT NOT NULL DEFAULT 0,
+    ADD COLUMN totp_locked_until TIMESTAMPTZ;
@@ -4,16 +4,25 @@ const WS_TOKEN = "";
 
 const api = {
     // conversations is now an auth-protected endpoint; no user_id param required.
-    conversations: () => `/api/conversations`,
+    conversations: (archived) => `/api/conversations${archived ? "?archived=true" : ""}`,
     messages: (conversationId) => `/api/messages?conversation_id=${encodeURIComponent(conversationId)}`,
 };
 
 const conversationsEl = document.getElementById("conversations");
+const searchInput = document.getElementById("search");
+const threadPanel = document.getElementById("thread-panel");
+const threadMessagesEl = document.getElementById("thread-messages");
+const threadComposer = document.getElementById("thread-composer");
+const threadInput = document.getElementById("thread-input");
+const replyBar = document.getElementById("reply-bar");
+const replyBarText = document.getElementById("reply-bar-text");
 const messagesEl = document.getElementById("messages");
 const chatNameEl = document.getElementById("chat-name");
 const chatSubEl = document.getElementById("chat-sub");
 const composer = document.getElementById("composer");
 const inputMsg = document.getElementById("input-msg");
+const inputFile = document.getElementById("input-file");
+const attachBtn = document.getElementById("attach");
 const sidebar = document.getElementById("sidebar");
 const backBtn = document.getElementById("back-btn");
 
@@ -24,9 +33,21 @@ let state = {
     messages: {},
     _messagesReqId: 0,
     users: {},
+    thread: null, // { id, parent, replies } of the open thread
+    replyTo: null, // message quoted by the next message sent
+    showArchived: false, // the sidebar lists archived conversations instead of active ones
 };
 
 function getStoredToken() { return ""; }
+
+// double-submit csrf token: the server sets the readable csrf_token cookie at login/refresh
+function getCsrfToken() {
+    const m = document.cookie.match(/(?:^|;\s*)csrf_token=([^;]*)/);
+    return m ? decodeURIComponent(m[1]) : "";
+}
+function csrfHeaders(extra) {
+    return Object.assign({ "X-CSRF-Token": getCsrfToken() }, extra || {});
+}
 function saveStoredToken(tok) { /* no-op for cookie-only auth*/ }
 
 // Auth UI DOM refs
@@ -134,6 +155,21 @@ function init() {
             logout();
         });
     }
+    const authForgotBtn = document.getElementById("auth-forgot");
+    if (authForgotBtn) {
+        authForgotBtn.addEventListener("click", () => requestPasswordReset((authEmail.value || "").trim()));
+    }
+    // opened from the emailed reset link
+    const params = new URLSearchParams(window.location.search);
+    const resetToken = params.get("reset_token");
+    if (resetToken) confirmPasswordReset(resetToken);
+    // SSO login of an account with 2FA enabled lands here with a challenge
+    const ssoChallenge = params.get("two_factor_challenge");
+    if (ssoChallenge) {
+        window.history.replaceState(null, "", window.location.pathname);
+        completeTwoFactorLogin(ssoChallenge).then((data) => { if (data) window.location.reload(); });
+    }
+    showSsoButton();
 
     (async () => {
         const ok = await refreshAccess();
@@ -149,9 +185,20 @@ function init() {
     })();
 
     composer.addEventListener("submit", onSend);
+    attachBtn.addEventListener("click", () => { if (state.active) inputFile.click(); });
+    inputFile.addEventListener("change", onAttach);
     document.getElementById("new-conv").addEventListener("click", (e) => { e.preventDefault(); openNewConversationModal(); });
     wireNewConversationModal();
     backBtn.addEventListener("click", () => sidebar.classList.add("open"));
+    wireSearch();
+    wireThreads();
+    wireQuotes();
+    wireReactions();
+    wirePins();
+    wireReports();
+    wireConversationSettings();
+    wireRename();
+    wireProfile();
 }
 
 // New conversation modal logic
@@ -247,9 +294,14 @@ function wireNewConversationModal() {
             const res = await fetch("/api/conversations", {
                 method: "POST",
                 credentials: "same-origin",
-                headers: { "Content-Type": "application/json" },
+                headers: csrfHeaders({ "Content-Type": "application/json" }),
                 body: JSON.stringify({ title, is_group: isGroup, participants})
             });
+            if (res.status === 429) {
+                const data = await res.json().catch(() => ({}));
+                showToast(`Too many new conversations. Try again in ${data.retry_after || 30}s.`, "error", 4000);
+                return;
+            }
             if (!res.ok) {
                 const body = await res.text().catch(()=>"");
                 showToast("Failed to create conversation: " + (body || res.status), "error", 4000);
@@ -283,8 +335,12 @@ async function login(email, password) {
             alert("Login failed: " + (body || res.status));
             return;
         }
-        const data = await res.json();
-        
+        let data = await res.json();
+        if (data && data.two_factor_required) {
+            data = await completeTwoFactorLogin(data.challenge_token);
+            if (!data) return;
+        }
+
         if (data && data.user && data.user.id) {
             state.me = data.user.id;
             if (data.user.display_name) state.users[state.me] = data.user.display_name;
@@ -303,11 +359,94 @@ async function login(email, password) {
     }
 }
 
+// showing the SSO button only when the server has an identity provider configured
+async function showSsoButton() {
+    const btn = document.getElementById("auth-sso");
+    if (!btn) return;
+    try {
+        const res = await fetch("/api/auth/sso", { credentials: "same-origin" });
+        if (!res.ok) return;
+        const cfg = await res.json();
+        if (cfg && cfg.enabled) {
+            btn.href = cfg.login_url;
+            btn.style.display = "inline-block";
+        }
+    } catch (_) {}
+}
+
+// second login step for accounts with 2FA enabled
+async function completeTwoFactorLogin(challengeToken) {
+    const input = (prompt("Enter the 6-digit code from your authenticator app (or a recovery code):") || "").trim();
+    if (!input) return null;
+    const payload = { challenge_token: challengeToken };
+    if (/^\d{6}$/.test(input)) payload.code = input;
+    else payload.recovery_code = input;
+    const res = await fetch("/api/login/2fa", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify(payload),
+        credentials: "same-origin"
+    });
+    if (!res.ok) {
+        const body = await res.text().catch(()=>"");
+        alert("Login failed: " + (body || res.status));
+        return null;
+    }
+    return res.json();
+}
+
+// password reset helpers
+async function requestPasswordReset(email) {
+    if (!email) email = (prompt("Email of your account:") || "").trim();
+    if (!email) return;
+    try {
+        const res = await fetch("/api/password/reset/request", {
+            method: "POST",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify({ email }),
+            credentials: "same-origin"
+        });
+        if (!res.ok) {
+            const body = await res.text().catch(()=>"");
+            showToast("Reset request failed: " + (body || res.status), "error", 4000);
+            return;
+        }
+        showToast("If the account exists, a reset link was sent.", "success", 4000);
+    } catch (err) {
+        console.error("password reset request error", err);
+        showToast("Network error", "error", 3000);
+    }
+}
+
+async function confirmPasswordReset(token) {
+    const password = prompt("Choose a new password (min 8 characters):");
+    if (!password) return;
+    try {
+        const res = await fetch("/api/password/reset/confirm", {
+            method: "POST",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify({ token, password }),
+            credentials: "same-origin"
+        });
+        if (!res.ok) {
+            const body = await res.text().catch(()=>"");
+            showToast("Password reset failed: " + (body || res.status), "error", 4000);
+            return;
+        }
+        // dropping the token from the address bar
+        window.history.replaceState(null, "", window.location.pathname);
+        showToast("Password updated - please log in.", "success", 4000);
+    } catch (err) {
+        console.error("password reset confirm error", err);
+        showToast("Network error", "error", 3000);
+    }
+}
+
 function logout() {
     // telling server to clear cookie, then clear client-side token and state
     (async () => {
         try {
-            const res = await fetch("/api/logout", { method: "POST", credentials: "same-origin" });
+            const res = await fetch("/api/logout", { method: "POST", credentials: "same-origin", headers: csrfHeaders() });
             if (res.ok) {
                 try { const body = await res.json().catch(()=>null); console.debug("logout response", body); } catch(_) {}
             }
@@ -338,8 +477,9 @@ function logout() {
 async function refreshAccess() {
     try {
         const res = await fetch("/api/refresh", {
-            method: "GET",
+            method: "POST",
             credentials: "same-origin",
+            headers: csrfHeaders(),
         });
         if (!res.ok) {
             handleLoggedOut("Session expired - please log in.");
@@ -367,7 +507,7 @@ async function refreshAccess() {
 
 async function loadConversations() {
     try {
-        const res = await fetch(api.conversations(), {credentials: "same-origin"});
+        const res = await fetch(api.conversations(state.showArchived), {credentials: "same-origin"});
         if (!res.ok) {
             if (res.status === 401) {
                 handleLoggedOut("Session expired - please log in.");
@@ -392,6 +532,70 @@ async function loadConversations() {
     }
 }
 
+// Message search: Enter runs a full-text search, clearing the box restores the conversation list
+function wireSearch() {
+    searchInput.addEventListener("keydown", (e) => {
+        if (e.key !== "Enter") return;
+        e.preventDefault();
+        const q = searchInput.value.trim();
+        if (q) searchMessages(q);
+        else renderConversations();
+    });
+    searchInput.addEventListener("input", () => {
+        if (!searchInput.value.trim()) renderConversations();
+    });
+}
+
+async function searchMessages(q, cursor) {
+    const params = new URLSearchParams({ q });
+    if (cursor) params.set("cursor", cursor);
+    try {
+        const res = await fetch(`/api/search?${params}`, { credentials: "same-origin" });
+        const data = await res.json().catch(() => null);
+        if (!res.ok) {
+            showToast((data && data.error) || "Search failed", "error", 4000);
+            return;
+        }
+        renderSearchResults(q, data, !!cursor);
+    } catch (err) {
+        console.error("search error", err);
+        showToast("Search failed (network)", "error", 4000);
+    }
+}
+
+function renderSearchResults(q, data, append) {
+    if (!append) conversationsEl.innerHTML = "";
+    conversationsEl.querySelectorAll(".search-more").forEach(el => el.remove());
+    const results = (data && data.results) || [];
+    if (!append && results.length === 0) {
+        conversationsEl.innerHTML = `<li class="empty">No messages found</li>`;
+        return;
+    }
+    for (const r of results) {
+        const li = document.createElement("li");
+        li.className = "search-result";
+        li.tabIndex = 0;
+        const where = r.conversation_title || r.author_name || "Direct";
+        // snippet_html is escaped by the server, only <mark> is added
+        li.innerHTML = `
+            <div class="conv-meta">
+                <div class="name">${escapeHtml(where)} <span class="search-when">${formatDate(new Date(r.created_at))}</span></div>
+                <div class="search-author">${escapeHtml(r.author_name || "")}</div>
+                <div class="search-snippet">${r.snippet_html}</div>
+            </div>
+        `;
+        li.addEventListener("click", () => openConversation(r.conversation_id));
+        conversationsEl.appendChild(li);
+    }
+    if (data && data.next_cursor) {
+        const more = document.createElement("li");
+        more.className = "search-more";
+        more.textContent = "More results…";
+        more.addEventListener("click", () => searchMessages(q, data.next_cursor));
+        conversationsEl.appendChild(more);
+    }
+}
+
 function renderConversations() {
     conversationsEl.innerHTML = "";
     if (!Array.isArray(state.convs) || state.convs.length === 0) {
@@ -399,20 +603,206 @@ function renderConversations() {
         return;
     }
     for (const c of state.convs) {
-        const display = c.is_group ? (c.title || "Group") : (c.display_name || (c.title || "Direct"));
+        const display = conversationName(c);
         const li = document.createElement("li");
-        li.className = c.id === state.active ? "active" : "";
+        li.className = (c.id === state.active ? "active" : "") + (c.muted ? " muted" : "");
         li.tabIndex = 0;
         li.innerHTML = `
             <img class="avatar" src="https://via.placeholder.com/40" alt="avatar" />
             <div class="conv-meta">
-                <div class="name">${escapeHtml(display)}</div>
-                <div class="last"></div>
+                <div class="name">${c.pinned ? "📌 " : ""}${escapeHtml(display)}${c.muted ? " 🔕" : ""}</div>
+                <div class="last">${escapeHtml(conversationPreview(c))}</div>
             </div>
+            ${c.unread_count > 0 && c.id !== state.active ? `<span class="unread">${c.unread_count > 99 ? "99+" : c.unread_count}</span>` : ""}
         `;
         li.addEventListener("click", () => openConversation(c.id));
         conversationsEl.appendChild(li);
     }
+    renderChatActions();
+}
+
+// Profile: clicking your name edits the display name; everyone sharing a conversation
+// receives user_updated and refreshes the names/avatars cached in loaded messages
+function wireProfile() {
+    authName.title = "Click to change your display name";
+    authName.style.cursor = "pointer";
+    authName.addEventListener("click", async () => {
+        const current = state.users[state.me] || "";
+        const name = prompt("Display name", current);
+        if (name === null || name.trim() === "" || name.trim() === current) return;
+        try {
+            const res = await fetch("/api/me", {
+                method: "PATCH",
+                headers: csrfHeaders({ "Content-Type": "application/json" }),
+                credentials: "same-origin",
+                body: JSON.stringify({ display_name: name.trim() }),
+            });
+            if (!res.ok) {
+                const body = await res.text().catch(() => "");
+                showToast("Profile update failed: " + (body || res.status), "error", 3000);
+                return;
+            }
+            applyUserUpdate(await res.json());
+        } catch (err) {
+            console.error("profile update error", err);
+        }
+    });
+}
+
+function applyUserUpdate(u) {
+    if (!u || !u.id) return;
+    if (u.display_name) state.users[u.id] = u.display_name;
+    if (String(u.id) === String(state.me) && authName) authName.textContent = u.display_name || state.me;
+    const touch = (m) => {
+        if (String(m.author_id) !== String(u.id)) return;
+        m.author_name = u.display_name;
+        m.author_avatar = u.avatar_url;
+    };
+    for (const list of Object.values(state.messages)) list.forEach(touch);
+    if (state.thread) [state.thread.parent, ...state.thread.replies].forEach(touch);
+    for (const c of state.convs || []) {
+        if (String(c.last_message_author_id) === String(u.id)) c.last_message_author_name = u.display_name;
+    }
+    // direct conversations are named after the other person
+    if ((state.convs || []).some(c => !c.is_group)) loadConversations();
+    else renderConversations();
+    if (state.active) renderMessages(state.active, { scrollToBottom: false });
+    if (state.thread) renderThread();
+}
+
+function conversationName(c) {
+    return c.is_group ? (c.title || "Group") : (c.display_name || (c.title || "Direct"));
+}
+
+function renderChatHeader(conv) {
+    chatNameEl.textContent = conv ? conversationName(conv) : "Conversation";
+    chatNameEl.title = conv && conv.is_group ? "Click to rename" : "";
+    chatSubEl.textContent = (conv && conv.description) || "—";
+    const avatar = document.getElementById("chat-avatar");
+    if (conv && conv.avatar_url) avatar.src = conv.avatar_url;
+    else avatar.removeAttribute("src");
+}
+
+// Group owners/admins rename a group by clicking its name; the server answers with a
+// system message and a conversation_updated event for every participant
+function wireRename() {
+    chatNameEl.addEventListener("click", async () => {
+        const conv = activeConversation();
+        if (!conv || !conv.is_group) return;
+        const title = prompt("Rename group", conv.title || "");
+        if (title === null || title.trim() === "" || title.trim() === conv.title) return;
+        try {
+            const res = await fetch(`/api/conversations/${encodeURIComponent(conv.id)}`, {
+                method: "PATCH",
+                headers: csrfHeaders({ "Content-Type": "application/json" }),
+                credentials: "same-origin",
+                body: JSON.stringify({ title: title.trim() }),
+            });
+            if (!res.ok) {
+                const body = await res.text().catch(() => "");
+                showToast("Rename failed: " + (body || res.status), "error", 3000);
+            }
+        } catch (err) {
+            console.error("rename error", err);
+        }
+    });
+}
+
+function applyConversationUpdate(updated) {
+    const conv = (state.convs || []).find(c => c.id === updated.id);
+    if (!conv) return;
+    conv.title = updated.title;
+    conv.avatar_url = updated.avatar_url;
+    conv.description = updated.description;
+    renderConversations();
+    if (state.active === conv.id) renderChatHeader(conv);
+}
+
+// Per-user conversation settings: pin to top, mute (toggled indefinitely here) and archive
+function wireConversationSettings() {
+    const archivedBtn = document.getElementById("show-archived");
+    archivedBtn.addEventListener("click", () => {
+        state.showArchived = !state.showArchived;
+        archivedBtn.setAttribute("aria-pressed", String(state.showArchived));
+        loadConversations();
+    });
+    document.getElementById("conv-pin").addEventListener("click", () => {
+        const c = activeConversation();
+        if (c) updateConversationSettings(c, { pinned: !c.pinned });
+    });
+    document.getElementById("conv-mute").addEventListener("click", () => {
+        const c = activeConversation();
+        if (c) updateConversationSettings(c, { muted: !c.muted });
+    });
+    document.getElementById("conv-archive").addEventListener("click", () => {
+        const c = activeConversation();
+        if (c) updateConversationSettings(c, { archived: !c.archived });
+    });
+}
+
+function activeConversation() {
+    return (state.convs || []).find(c => c.id === state.active);
+}
+
+function renderChatActions() {
+    const c = activeConversation();
+    const actions = document.getElementById("chat-actions");
+    actions.style.display = c ? "flex" : "none";
+    if (!c) return;
+    document.getElementById("conv-pin").classList.toggle("on", !!c.pinned);
+    document.getElementById("conv-pin").title = c.pinned ? "Unpin" : "Pin to top";
+    document.getElementById("conv-mute").textContent = c.muted ? "🔕" : "🔔";
+    document.getElementById("conv-mute").title = c.muted ? "Unmute" : "Mute";
+    document.getElementById("conv-archive").title = c.archived ? "Unarchive" : "Archive";
+}
+
+async function updateConversationSettings(conv, changes) {
+    try {
+        const res = await fetch(`/api/conversations/${encodeURIComponent(conv.id)}/settings`, {
+            method: "PATCH",
+            headers: csrfHeaders({ "Content-Type": "application/json" }),
+            credentials: "same-origin",
+            body: JSON.stringify(changes),
+        });
+        if (!res.ok) {
+            const body = await res.text().catch(() => "");
+            showToast("Failed to update conversation: " + (body || res.status), "error", 3000);
+            return;
+        }
+        Object.assign(conv, await res.json());
+        // pin order and the archive filter are applied server-side
+        loadConversations();
+    } catch (err) {
+        console.error("conversation settings error", err);
+    }
+}
+
+// "Ann: hello" style preview of the conversation's last message
+function conversationPreview(c) {
+    if (!c.last_message_preview) return "";
+    if (!c.last_message_author_id) return c.last_message_preview;
+    const who = String(c.last_message_author_id) === String(state.me)
+        ? "You"
+        : (c.last_message_author_name || getDisplayName(c.last_message_author_id));
+    return c.is_group || who === "You" ? `${who}: ${c.last_message_preview}` : c.last_message_preview;
+}
+
+// bumpConversation moves a conversation to the top of the list with m as its last message
+function bumpConversation(m) {
+    const idx = (state.convs || []).findIndex(c => c.id === m.conversation_id);
+    if (idx === -1 || m.parent_message_id) return;
+    const conv = state.convs[idx];
+    conv.last_message_at = m.created_at;
+    conv.last_message_id = m.id;
+    conv.last_message_author_id = m.author_id;
+    conv.last_message_author_name = m.author_name;
+    conv.last_message_preview = m.body || (m.attachments && m.attachments[0] ? "📎 " + m.attachments[0].filename : "");
+    if (state.active !== m.conversation_id && String(m.author_id) !== String(state.me)) {
+        conv.unread_count = (conv.unread_count || 0) + 1;
+    }
+    state.convs.splice(idx, 1);
+    state.convs.unshift(conv);
+    renderConversations();
 }
 
 async function openConversation(id) {
@@ -424,7 +814,10 @@ async function openConversation(id) {
     messagesFetchController = new AbortController();
     const signal = messagesFetchController.signal;
 
+    if (state.thread && state.thread.parent.conversation_id !== id) closeThread();
     state.active = id;
+    const opened = (state.convs || []).find(c => c.id === id);
+    if (opened) opened.unread_count = 0;
     renderConversations();
     chatNameEl.textContent = "Loading...";
     sidebar.classList.remove("open");
@@ -448,7 +841,8 @@ async function openConversation(id) {
 
         state.messages[id] = data;
         const conv = state.convs.find((x) => x.id === id);
-        chatNameEl.textContent = conv?.title || "Conversation";
+        renderChatHeader(conv);
+        renderChatActions();
         renderMessages(id, { scrollToBottom: true});
 
         try {
@@ -503,13 +897,33 @@ function renderMessages(convId, opts = {}) {
             lastDate = dateKey;
         }
 
+        if (m.message_type === "system") {
+            // conversation changes ("Alice renamed the group to …") are shown as a centered note
+            const note = document.createElement("div");
+            note.className = "system-msg";
+            note.textContent = m.body || "";
+            note.setAttribute("data-id", m.id);
+            messagesEl.appendChild(note);
+            continue;
+        }
+
         const div = document.createElement("div");
         const isMe = String(m.author_id) === String(state.me);
-        div.className = "msg " + (isMe ? "me" : "them");
+        div.className = "msg " + (isMe ? "me" : "them") + (m.pinned ? " pinned" : "");
 
         const authorLine = !isMe && m.author_name ? `<div class="author">${escapeHtml(m.author_name)}</div>` : "";
-        div.innerHTML = `${authorLine}<div class="text">${escapeHtml(m.body || "")}</div><span class="time">${formatTime(m.created_at)}</span>`;
+        if (m.is_deleted) {
+            div.className += " deleted";
+            div.innerHTML = `${authorLine}<div class="text">Message removed</div><span class="time">${formatTime(m.created_at)}</span>`;
+            div.setAttribute("data-date", dateKey);
+            div.setAttribute("data-id", m.id);
+            messagesEl.appendChild(div);
+            continue;
+        }
+        const text = m.body ? `<div class="text">${renderBody(m.body)}</div>` : "";
+        div.innerHTML = `${authorLine}${renderQuote(m)}${renderAttachments(m)}${text}${renderLinkPreviews(m)}<span class="time">${formatTime(m.created_at)}</span>${renderReactions(m)}${renderThreadLink(m)}${renderQuoteLink(m)}${renderPinLink(m)}${isMe ? "" : renderReportLink(m)}`;
         div.setAttribute("data-date", dateKey);
+        div.setAttribute("data-id", m.id);
 
         messagesEl.appendChild(div);
     }
@@ -519,6 +933,426 @@ function renderMessages(convId, opts = {}) {
     }
 }
 
+// escaping a message body and highlighting its @mentions
+function renderBody(body) {
+    return escapeHtml(body).replace(/(^|[^\w@])(@[\p{L}\p{N}._-]+)/gu, (_, pre, tag) => `${pre}<span class="mention">${tag}</span>`);
+}
+
+// Quoted replies: the preview comes from the server and reflects edits/deletions of the quoted message
+function renderQuote(m) {
+    const q = m.reply_to;
+    if (!q) return "";
+    const who = q.author_name ? `<div class="quote-author">${escapeHtml(q.author_name)}</div>` : "";
+    const body = q.is_deleted
+        ? `<div class="quote-body quote-deleted">Message deleted</div>`
+        : `<div class="quote-body">${escapeHtml(q.body || "")}${q.edited_at ? ` <span class="quote-edited">(edited)</span>` : ""}</div>`;
+    return `<div class="quote" data-quote="${escapeHtml(q.id)}">${who}${body}</div>`;
+}
+
+// Reactions: chips with counts; the caller's own reactions are highlighted and toggle on click
+const QUICK_REACTIONS = ["👍", "❤️", "😂", "🎉", "😮", "😢"];
+
+function renderReactions(m) {
+    if (m._local) return "";
+    const chips = (m.reactions || []).map(r =>
+        `<button type="button" class="reaction${r.reacted ? " mine" : ""}" data-msg="${escapeHtml(m.id)}" data-emoji="${escapeHtml(r.emoji)}">${escapeHtml(r.emoji)} ${r.count}</button>`
+    ).join("");
+    const picker = QUICK_REACTIONS.map(e =>
+        `<button type="button" class="reaction-pick" data-msg="${escapeHtml(m.id)}" data-emoji="${e}">${e}</button>`
+    ).join("");
+    return `<div class="reactions">${chips}<button type="button" class="reaction-add" aria-label="Add reaction">+</button><span class="reaction-picker">${picker}</span></div>`;
+}
+
+// findMessages looks a message up in the timeline of its conversation and in the open thread
+function findMessages(id) {
+    const found = [];
+    for (const list of Object.values(state.messages)) {
+        const m = list.find(x => x.id === id);
+        if (m) found.push(m);
+    }
+    if (state.thread) {
+        for (const m of [state.thread.parent, ...state.thread.replies]) {
+            if (m.id === id && !found.includes(m)) found.push(m);
+        }
+    }
+    return found;
+}
+
+// findQuoting returns the loaded messages (timelines and open thread) that quote the message id
+function findQuoting(id) {
+    const all = Object.values(state.messages).flat();
+    if (state.thread) all.push(state.thread.parent, ...state.thread.replies);
+    return [...new Set(all)].filter(m => m.reply_to && m.reply_to.id === id);
+}
+
+function setReaction(m, emoji, count, reacted) {
+    m.reactions = m.reactions || [];
+    let r = m.reactions.find(x => x.emoji === emoji);
+    if (!r) {
+        r = { emoji, count: 0, reacted: false };
+        m.reactions.push(r);
+    }
+    r.count = count;
+    if (reacted !== undefined) r.reacted = reacted;
+    m.reactions = m.reactions.filter(x => x.count > 0);
+}
+
+function rerenderAfterReaction() {
+    if (state.active) renderMessages(state.active, { scrollToBottom: false });
+    if (state.thread) renderThread();
+}
+
+function wireReactions() {
+    const onClick = (e) => {
+        const add = e.target.closest(".reaction-add");
+        if (add) {
+            add.parentElement.classList.toggle("open");
+            return;
+        }
+        const btn = e.target.closest(".reaction, .reaction-pick");
+        if (!btn) return;
+        const m = findMessages(btn.dataset.msg)[0];
+        const mine = m && (m.reactions || []).some(r => r.emoji === btn.dataset.emoji && r.reacted);
+        toggleReaction(btn.dataset.msg, btn.dataset.emoji, !mine);
+    };
+    messagesEl.addEventListener("click", onClick);
+    threadMessagesEl.addEventListener("click", onClick);
+}
+
+async function toggleReaction(msgId, emoji, add) {
+    try {
+        const res = await fetch(`/api/messages/${encodeURIComponent(msgId)}/reactions?emoji=${encodeURIComponent(emoji)}`, {
+            method: add ? "POST" : "DELETE",
+            headers: csrfHeaders(),
+            credentials: "same-origin",
+        });
+        if (!res.ok) {
+            showToast("Failed to update reaction", "error", 3000);
+            return;
+        }
+        const data = await res.json().catch(() => null);
+        if (data && data.reaction) {
+            for (const m of findMessages(msgId)) setReaction(m, emoji, data.reaction.count, add);
+            rerenderAfterReaction();
+        }
+    } catch (err) {
+        console.error("reaction error", err);
+    }
+}
+
+function renderQuoteLink(m) {
+    if (m._local) return "";
+    return `<button type="button" class="quote-link" data-reply="${escapeHtml(m.id)}">Quote</button>`;
+}
+
+function wireQuotes() {
+    messagesEl.addEventListener("click", (e) => {
+        const btn = e.target.closest(".quote-link");
+        if (btn) {
+            const m = (state.messages[state.active] || []).find(x => x.id === btn.dataset.reply);
+            if (m) setReplyTo(m);
+            return;
+        }
+        // clicking a quote scrolls to the quoted message when it is loaded
+        const quote = e.target.closest(".quote");
+        if (quote) {
+            const target = messagesEl.querySelector(`[data-id="${CSS.escape(quote.dataset.quote)}"]`);
+            if (target) target.scrollIntoView({ behavior: "smooth", block: "center" });
+        }
+    });
+    document.getElementById("reply-cancel").addEventListener("click", () => setReplyTo(null));
+}
+
+function setReplyTo(m) {
+    state.replyTo = m ? { id: m.id, author_name: m.author_name, body: m.body } : null;
+    if (!state.replyTo) {
+        replyBar.style.display = "none";
+        replyBarText.textContent = "";
+        return;
+    }
+    const who = m.author_name || (String(m.author_id) === String(state.me) ? "yourself" : "message");
+    const body = (m.body || "").length > 80 ? m.body.slice(0, 80) + "…" : (m.body || "");
+    replyBarText.textContent = `Replying to ${who}: ${body}`;
+    replyBar.style.display = "flex";
+    inputMsg.focus();
+}
+
+// Pins: owners/admins (anyone in a direct chat) pin messages; changes arrive as message_pinned/unpinned events
+function renderPinLink(m) {
+    if (m._local) return "";
+    return `<button type="button" class="pin-link" data-pin="${escapeHtml(m.id)}">${m.pinned ? "📌 Unpin" : "Pin"}</button>`;
+}
+
+function wirePins() {
+    messagesEl.addEventListener("click", (e) => {
+        const btn = e.target.closest(".pin-link");
+        if (!btn) return;
+        const m = findMessages(btn.dataset.pin)[0];
+        if (m) togglePin(m.id, !m.pinned);
+    });
+}
+
+async function togglePin(msgId, pin) {
+    try {
+        const res = await fetch(`/api/messages/${encodeURIComponent(msgId)}/pin`, {
+            method: pin ? "POST" : "DELETE",
+            headers: csrfHeaders(),
+            credentials: "same-origin",
+        });
+        if (!res.ok) {
+            const body = await res.text().catch(() => "");
+            showToast("Failed to update pin: " + (body || res.status), "error", 3000);
+            return;
+        }
+        setPinned(msgId, pin);
+    } catch (err) {
+        console.error("pin error", err);
+    }
+}
+
+function setPinned(msgId, pinned) {
+    for (const m of findMessages(msgId)) m.pinned = pinned;
+    if (state.active) renderMessages(state.active, { scrollToBottom: false });
+}
+
+// Reports: other people's messages can be reported to the instance admins
+const REPORT_REASONS = ["spam", "harassment", "hate", "inappropriate", "other"];
+
+function renderReportLink(m) {
+    if (m._local) return "";
+    return `<button type="button" class="report-link" data-report="${escapeHtml(m.id)}">Report</button>`;
+}
+
+function wireReports() {
+    messagesEl.addEventListener("click", (e) => {
+        const btn = e.target.closest(".report-link");
+        if (btn) reportMessage(btn.dataset.report);
+    });
+}
+
+async function reportMessage(msgId) {
+    const reason = (prompt(`Why are you reporting this message? (${REPORT_REASONS.join(", ")})`, "spam") || "").trim().toLowerCase();
+    if (!reason) return;
+    if (!REPORT_REASONS.includes(reason)) {
+        showToast("Unknown reason: " + reason, "error", 3000);
+        return;
+    }
+    const details = prompt("Anything the moderators should know? (optional)") || "";
+    try {
+        const res = await fetch("/api/reports", {
+            method: "POST",
+            headers: csrfHeaders({ "Content-Type": "application/json" }),
+            credentials: "same-origin",
+            body: JSON.stringify({ message_id: msgId, reason, details }),
+        });
+        if (!res.ok) {
+            const body = await res.text().catch(() => "");
+            showToast("Report failed: " + (body || res.status), "error", 3000);
+            return;
+        }
+        showToast("Thanks, the moderators will take a look.", "success", 3000);
+    } catch (err) {
+        console.error("report error", err);
+    }
+}
+
+// message_deleted: a moderator removed the message
+function markDeleted(msgId) {
+    for (const m of findMessages(msgId)) {
+        m.is_deleted = true;
+        m.body = null;
+        m.attachments = [];
+    }
+    // quotes of it show "Message deleted" like a reload would
+    for (const m of findQuoting(msgId)) {
+        m.reply_to = { ...m.reply_to, is_deleted: true, body: null };
+    }
+    if (state.active) renderMessages(state.active, { scrollToBottom: false });
+    if (state.thread) renderThread();
+}
+
+function renderThreadLink(m) {
+    if (m._local) return "";
+    const label = m.reply_count > 0
+        ? `${m.reply_count} ${m.reply_count === 1 ? "reply" : "replies"} · last ${formatTime(m.last_reply_at)}`
+        : "Reply in thread";
+    return `<button type="button" class="thread-link" data-thread="${escapeHtml(m.id)}">${label}</button>`;
+}
+
+// Threads: replies are shown in a side panel and arrive as "thread_reply" events
+function wireThreads() {
+    messagesEl.addEventListener("click", (e) => {
+        const btn = e.target.closest(".thread-link");
+        if (btn) openThread(btn.dataset.thread);
+    });
+    document.getElementById("thread-close").addEventListener("click", closeThread);
+    threadComposer.addEventListener("submit", onThreadReply);
+}
+
+async function openThread(id) {
+    try {
+        const res = await fetch(`/api/messages/${encodeURIComponent(id)}/thread`, { credentials: "same-origin" });
+        if (!res.ok) {
+            showToast("Failed to load thread", "error", 4000);
+            return;
+        }
+        const data = await res.json();
+        state.thread = { id: data.parent.id, parent: data.parent, replies: data.replies || [] };
+        threadPanel.style.display = "flex";
+        renderThread();
+        threadInput.focus();
+    } catch (err) {
+        console.error("thread load error", err);
+        showToast("Failed to load thread (network)", "error", 4000);
+    }
+}
+
+function closeThread() {
+    state.thread = null;
+    threadPanel.style.display = "none";
+    threadMessagesEl.innerHTML = "";
+}
+
+function renderThread() {
+    if (!state.thread) return;
+    threadMessagesEl.innerHTML = "";
+    const items = [state.thread.parent, ...state.thread.replies];
+    items.forEach((m, i) => {
+        const div = document.createElement("div");
+        const isMe = String(m.author_id) === String(state.me);
+        div.className = "msg " + (isMe ? "me" : "them") + (i === 0 ? " thread-root" : "");
+        const authorLine = m.author_name ? `<div class="author">${escapeHtml(m.author_name)}</div>` : "";
+        const text = m.body ? `<div class="text">${renderBody(m.body)}</div>` : "";
+        div.innerHTML = `${authorLine}${renderQuote(m)}${renderAttachments(m)}${text}${renderLinkPreviews(m)}<span class="time">${formatTime(m.created_at)}</span>${renderReactions(m)}`;
+        threadMessagesEl.appendChild(div);
+    });
+    threadMessagesEl.scrollTop = threadMessagesEl.scrollHeight;
+}
+
+function addThreadReply(threadId, reply) {
+    if (!state.thread || state.thread.id !== threadId) return;
+    if (state.thread.replies.some(m => m.id === reply.id)) return;
+    state.thread.replies.push(reply);
+    renderThread();
+}
+
+async function onThreadReply(e) {
+    e.preventDefault();
+    const text = threadInput.value.trim();
+    if (!text || !state.thread) return;
+    const thread = state.thread;
+    threadInput.value = "";
+    try {
+        const res = await fetch("/api/messages", {
+            method: "POST",
+            headers: csrfHeaders({ "Content-Type": "application/json" }),
+            credentials: "same-origin",
+            body: JSON.stringify({
+                conversation_id: thread.parent.conversation_id,
+                body: text,
+                parent_message_id: thread.id,
+            }),
+        });
+        const data = await res.json().catch(() => null);
+        if (!res.ok) {
+            showToast((data && data.error) || "Failed to send reply", "error", 4000);
+            return;
+        }
+        if (res.status === 202) {
+            if (data && data.reply) showToast(data.reply, "info", 4000);
+            return;
+        }
+        if (data && data.id) addThreadReply(thread.id, data);
+    } catch (err) {
+        console.error("thread reply error", err);
+        showToast("Failed to send reply (network)", "error", 4000);
+    }
+}
+
+function renderAttachments(m) {
+    if (!m.attachments || !m.attachments.length) return "";
+    return m.attachments.map(a => {
+        const url = `/api/attachments/${encodeURIComponent(a.id)}`;
+        if (a.status === "pending") {
+            return `<div class="attachment attachment-pending">Processing ${escapeHtml(a.filename)}…</div>`;
+        }
+        if (a.status === "failed") {
+            return `<div class="attachment attachment-pending">${escapeHtml(a.filename)} could not be processed</div>`;
+        }
+        if (String(a.content_type).startsWith("image/")) {
+            return `<a class="attachment" href="${url}" target="_blank" rel="noopener"><img src="${url}/thumbnail?size=480" alt="${escapeHtml(a.filename)}" loading="lazy"></a>`;
+        }
+        return `<a class="attachment attachment-file" href="${url}" download="${escapeHtml(a.filename)}">📄 ${escapeHtml(a.filename)} <span class="attachment-size">${formatSize(a.size_bytes)}</span></a>`;
+    }).join("");
+}
+
+// Link previews are unfurled server-side and arrive later as "message_updated" events
+function renderLinkPreviews(m) {
+    if (!m.link_previews || !m.link_previews.length) return "";
+    return m.link_previews.map(p => {
+        const img = p.image_url ? `<img src="${escapeHtml(p.image_url)}" alt="" loading="lazy" referrerpolicy="no-referrer">` : "";
+        const site = p.site_name ? `<div class="link-preview-site">${escapeHtml(p.site_name)}</div>` : "";
+        const title = p.title ? `<div class="link-preview-title">${escapeHtml(p.title)}</div>` : "";
+        const desc = p.description ? `<div class="link-preview-desc">${escapeHtml(p.description)}</div>` : "";
+        return `<a class="link-preview" href="${escapeHtml(p.url)}" target="_blank" rel="noopener noreferrer">${img}<div>${site}${title}${desc}</div></a>`;
+    }).join("");
+}
+
+// message_updated: swapping the server's copy into the timeline and the open thread
+function applyMessageUpdate(updated) {
+    for (const m of findMessages(updated.id)) {
+        Object.assign(m, updated);
+    }
+    if (state.active === updated.conversation_id) renderMessages(state.active, { scrollToBottom: false });
+    if (state.thread) renderThread();
+}
+
+function formatSize(n) {
+    if (n < 1024) return n + " B";
+    if (n < 1024 * 1024) return (n / 1024).toFixed(1) + " KB";
+    return (n / (1024 * 1024)).toFixed(1) + " MB";
+}
+
+// Uploading the chosen file as an attachment message (the typed text becomes its caption)
+async function onAttach() {
+    const file = inputFile.files && inputFile.files[0];
+    inputFile.value = "";
+    if (!file || !state.active) return;
+
+    const convId = state.active;
+    const form = new FormData();
+    form.append("file", file);
+    const caption = inputMsg.value.trim();
+    if (caption) form.append("body", caption);
+
+    attachBtn.disabled = true;
+    try {
+        const res = await fetch(`/api/conversations/${encodeURIComponent(convId)}/attachments`, {
+            method: "POST",
+            headers: csrfHeaders(),
+            credentials: "same-origin",
+            body: form,
+        });
+        const data = await res.json().catch(() => null);
+        if (!res.ok) {
+            showToast((data && data.error) || "Upload failed", "error", 5000);
+            return;
+        }
+        inputMsg.value = "";
+        // the hub usually delivers the message first; adding it only once
+        const msgs = state.messages[convId] = state.messages[convId] || [];
+        if (data && data.id && !msgs.some(m => m.id === data.id)) {
+            msgs.push(data);
+            if (state.active === convId) renderMessages(convId, { scrollToBottom: true });
+        }
+    } catch (err) {
+        console.error("upload error", err);
+        showToast("Upload failed (network)", "error", 5000);
+    } finally {
+        attachBtn.disabled = false;
+    }
+}
+
 async function onSend(e) {
     e.preventDefault();
     const text = inputMsg.value.trim();
@@ -534,21 +1368,28 @@ async function onSend(e) {
         created_at: new Date().toISOString(),
         _local: true,
     };
+    const replyTo = state.replyTo;
+    if (replyTo) {
+        tempMsg.reply_to_id = replyTo.id;
+        tempMsg.reply_to = { id: replyTo.id, author_name: replyTo.author_name, body: replyTo.body, is_deleted: false };
+    }
 
     state.messages[state.active] = state.messages[state.active] || [];
     state.messages[state.active].push(tempMsg);
     renderMessages(state.active, { scrollToBottom: true });
 
     inputMsg.value = "";
+    setReplyTo(null);
 
     try {
         const res = await fetch("/api/messages", {
             method: "POST",
-            headers: { "Content-Type": "application/json" },
+            headers: csrfHeaders({ "Content-Type": "application/json" }),
             credentials: "same-origin",
             body: JSON.stringify({
                 conversation_id: state.active,
                 body: text,
+                reply_to_id: replyTo ? replyTo.id : undefined,
             }),
         });
 
@@ -557,9 +1398,38 @@ async function onSend(e) {
             renderMessages(state.active); 
             const body = await res.text().catch(() => "");
             console.error("send message failed", res.status, body);
-            alert("Failed to send message");
+            if (res.status === 429) {
+                let retry = 1;
+                try { retry = JSON.parse(body).retry_after || 1; } catch (_) {}
+                showToast(`You're sending messages too fast. Try again in ${retry}s.`, "error", 3000);
+            } else if (res.status === 422) {
+                let reason = "";
+                try { reason = JSON.parse(body).error || ""; } catch (_) {}
+                alert(reason ? `Message not sent: ${reason}` : "Message not sent");
+            } else {
+                alert("Failed to send message");
+            }
             return;
         }
+        // slash commands that don't post anything only answer the caller
+        if (res.status === 202) {
+            state.messages[state.active] = (state.messages[state.active] || []).filter(m => m.id !== tempId);
+            renderMessages(state.active);
+            const data = await res.json().catch(() => null);
+            if (data && data.reply) showToast(data.reply, "info", 4000);
+            return;
+        }
+        // replacing the local copy with the saved one (its body may differ, e.g. /poll)
+        const saved = await res.json().catch(() => null);
+        if (saved && saved.id) {
+            const msgs = state.messages[saved.conversation_id] || [];
+            const idx = msgs.findIndex(m => m.id === tempId);
+            if (idx !== -1) {
+                if (msgs.some(m => m.id === saved.id)) msgs.splice(idx, 1);
+                else msgs[idx] = saved;
+                if (state.active === saved.conversation_id) renderMessages(state.active);
+            }
+        }
 
     } catch (err) {
         state.messages[state.active] = (state.messages[state.active] || []).filter(m => m.id !== tempId);
@@ -720,6 +1590,92 @@ async function wsConnect(convId) {
                         }
                         break;
                     }
+                    case "thread_reply": {
+                        // updating the root's counters in the timeline and the open thread
+                        const list = state.messages[msg.conversation_id] || [];
+                        const root = list.find(x => x.id === msg.thread_id);
+                        if (root) {
+                            root.reply_count = msg.reply_count;
+                            root.last_reply_at = msg.last_reply_at;
+                            if (state.active === msg.conversation_id) {
+                                renderMessages(msg.conversation_id, { scrollToBottom: false });
+                            }
+                        }
+                        if (msg.message) addThreadReply(msg.thread_id, msg.message);
+                        break;
+                    }
+                    case "mentioned": {
+                        // mentions arrive on every socket of the user; the open conversation already shows them
+                        if (msg.conversation_id === state.active) break;
+                        const m = msg.message || {};
+                        const who = m.author_name || getDisplayName(m.author_id);
+                        const body = (m.body || "").length > 80 ? m.body.slice(0, 80) + "…" : (m.body || "");
+                        showToast(`${who} mentioned you: ${body}`, "info", 6000);
+                        break;
+                    }
+                    case "user_updated": {
+                        applyUserUpdate(msg.user);
+                        break;
+                    }
+                    case "message_updated": {
+                        if (msg.message) applyMessageUpdate(msg.message);
+                        break;
+                    }
+                    case "message_deleted": {
+                        markDeleted(msg.message_id);
+                        break;
+                    }
+                    case "error": {
+                        // rate limited typing frames: hold off until the server allows them again
+                        if (msg.code === "rate_limited" && msg.limit === "typing") {
+                            typingSentAt = Date.now() + (msg.retry_after || 1) * 1000;
+                        } else {
+                            console.warn("[WS] server error frame", msg);
+                        }
+                        break;
+                    }
+                    case "session_revoked": {
+                        // the server closes the socket right after this event
+                        handleLoggedOut(msg.reason === "suspended" ? "Your account has been suspended." : "Your session has ended.");
+                        break;
+                    }
+                    case "blocks_changed": {
+                        // history is filtered server-side; refetch what's on screen
+                        for (const id of Object.keys(state.messages)) if (id !== state.active) delete state.messages[id];
+                        if (state.active) openConversation(state.active);
+                        break;
+                    }
+                    case "conversation_updated": {
+                        if (msg.conversation) applyConversationUpdate(msg.conversation);
+                        break;
+                    }
+                    case "message_pinned":
+                    case "message_unpinned": {
+                        setPinned(msg.message_id, msg.type === "message_pinned");
+                        break;
+                    }
+                    case "reaction_added":
+                    case "reaction_removed": {
+                        // events are coalesced server-side: one per emoji with the users that changed
+                        const mineChanged = (msg.user_ids || []).some(u => String(u) === String(state.me));
+                        const reacted = mineChanged ? msg.type === "reaction_added" : undefined;
+                        for (const m of findMessages(msg.message_id)) setReaction(m, msg.emoji, msg.count, reacted);
+                        rerenderAfterReaction();
+                        break;
+                    }
+                    case "attachment_ready":
+                    case "attachment_failed": {
+                        // swapping the processed attachment into its message
+                        const list = state.messages[msg.conversation_id] || [];
+                        const m = list.find(x => x.id === msg.message_id);
+                        if (m && msg.attachment) {
+                            m.attachments = (m.attachments || []).map(a => a.id === msg.attachment.id ? msg.attachment : a);
+                            if (state.active === msg.conversation_id) {
+                                renderMessages(msg.conversation_id, { scrollToBottom: false });
+                            }
+                        }
+                        break;
+                    }
                     case "read": {
                         // for now, no visual mark messages (will add later)
                         if (msg.conversation_id === state.active) {
@@ -759,8 +1715,13 @@ async function wsConnect(convId) {
             const exists = msgs.some((m) => m.id === msg.id);
             if (!exists) {
                 msgs.push(msg);
+                bumpConversation(msg);
                 if (state.active === msg.conversation_id) {
                     renderMessages(state.active, { scrollToBottom: true });
+                    // the conversation is on screen, so the new message counts as read
+                    if (String(msg.author_id) !== String(state.me)) {
+                        try { conn.send(JSON.stringify({ type: "read", conversation_id: msg.conversation_id, last_read_id: msg.id })); } catch (_) {}
+                    }
                 }
             }
         } catch (err) {
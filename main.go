@@ -8,17 +8,34 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 
 	"github.com/Y3rnur/go-realtime-chat/backend"
+	"github.com/Y3rnur/go-realtime-chat/backend/admin"
+	"github.com/Y3rnur/go-realtime-chat/backend/attachments"
+	"github.com/Y3rnur/go-realtime-chat/backend/bots"
+	"github.com/Y3rnur/go-realtime-chat/backend/conversations"
+	"github.com/Y3rnur/go-realtime-chat/backend/filters"
+	"github.com/Y3rnur/go-realtime-chat/backend/mentions"
+	"github.com/Y3rnur/go-realtime-chat/backend/moderation"
+	"github.com/Y3rnur/go-realtime-chat/backend/pins"
+	"github.com/Y3rnur/go-realtime-chat/backend/ratelimit"
+	"github.com/Y3rnur/go-realtime-chat/backend/reactions"
+	"github.com/Y3rnur/go-realtime-chat/backend/search"
+	"github.com/Y3rnur/go-realtime-chat/backend/storage"
 	"github.com/Y3rnur/go-realtime-chat/backend/store"
+	"github.com/Y3rnur/go-realtime-chat/backend/unfurl"
+	"github.com/Y3rnur/go-realtime-chat/backend/users"
 	"github.com/Y3rnur/go-realtime-chat/backend/ws"
 	"github.com/redis/go-redis/v9"
 )
 
 func main() {
+	started := time.Now()
 	fs := http.FileServer(http.Dir("./frontend"))
 
 	ctx := context.Background()
@@ -48,9 +65,90 @@ func main() {
 		log.Printf("redis: connected to %s", redisAddr)
 	}
 
-	hub := ws.NewHub(redisClient, pool)
+	// token-bucket rate limits, shared through Redis when it's available
+	limiter := ratelimit.New(redisClient)
+
+	hub := ws.NewHub(redisClient, pool, limiter)
 	defer hub.Close()
 
+	mailer := backend.NewMailerFromEnv()
+
+	// API tokens (bots/integrations) are looked up in the database
+	backend.ConfigureAPITokens(pool)
+
+	// accounts listed in ADMIN_EMAILS get the admin role
+	if err := backend.BootstrapAdmins(ctx, pool); err != nil {
+		log.Printf("admin: bootstrap from ADMIN_EMAILS failed: %v", err)
+	}
+
+	// outgoing messages pass the content filters (profanity, link blocklist, spam) before they're saved
+	contentFilters := filters.NewDefaultPipeline()
+
+	// outgoing webhooks + slash commands; bot replies and incoming webhook messages are
+	// filtered and published like any other message (publishMessage is defined below)
+	var publishMessage func(store.Message)
+	dispatcher := bots.NewDispatcher(pool, contentFilters, func(m store.Message) { publishMessage(m) })
+	dispatcher.Start(ctx, 4)
+	commands := bots.NewRegistry(dispatcher)
+
+	// @mentions are stored and pushed to the mentioned users' sockets
+	mentionNotifier := mentions.NewNotifier(pool, hub)
+
+	// link previews are fetched in the background and arrive as message_updated events
+	linkPreviews := unfurl.NewWorker(pool, unfurl.NewFetcher(), hub)
+	linkPreviews.Start(ctx, 2)
+
+	// publishMessage fans a saved message out to websocket clients, mentioned users and outgoing webhooks
+	publishMessage = func(m store.Message) {
+		if err := hub.PublishMessage(m.ConversationID.String(), m); err != nil {
+			log.Printf("redis publish error: %v", err)
+		}
+		mentionNotifier.MessageCreated(m)
+		// notifying outgoing webhooks (async, with retries)
+		dispatcher.MessageCreated(m)
+		linkPreviews.Enqueue(m)
+	}
+	// publishThreadReply sends a reply as a thread-scoped event (it stays out of the main timeline)
+	// together with the root's updated counters
+	publishThreadReply := func(reply, root store.Message) {
+		payload := map[string]any{
+			"type":            "thread_reply",
+			"conversation_id": reply.ConversationID,
+			"thread_id":       root.ID,
+			"message":         reply,
+			"reply_count":     root.ReplyCount,
+			"last_reply_at":   root.LastReplyAt,
+		}
+		if err := hub.PublishEvent(reply.ConversationID.String(), payload); err != nil {
+			log.Printf("publish thread_reply error: %v", err)
+		}
+		mentionNotifier.MessageCreated(reply)
+		dispatcher.MessageCreated(reply)
+		linkPreviews.Enqueue(reply)
+	}
+
+	// reaction events are coalesced per conversation before they reach clients
+	reactionEvents := reactions.NewCoalescer(pool, hub)
+
+	// attachment blobs (local filesystem by default, S3-compatible with STORAGE_DRIVER=s3)
+	blobs, err := storage.NewFromEnv()
+	if err != nil {
+		log.Fatalf("storage: %v", err)
+	}
+	// images are stripped of EXIF/GPS metadata and thumbnailed in the background
+	imageProcessor := attachments.NewProcessor(pool, blobs, hub)
+	imageProcessor.Start(ctx, 2)
+
+	// optional SSO via OpenID Connect (enabled when OIDC_ISSUER is set)
+	oidcProvider, err := backend.NewOIDCProviderFromEnv(ctx)
+	if err != nil {
+		log.Printf("oidc: %v - continuing without SSO", err)
+		oidcProvider = nil
+	} else if oidcProvider != nil {
+		oidcProvider.ValidAvatarURL = attachments.ValidAvatarURL
+		log.Printf("oidc: SSO enabled with issuer %s", oidcProvider.Issuer)
+	}
+
 	mux := http.NewServeMux()
 
 	// websocket endpoint
@@ -58,10 +156,49 @@ func main() {
 
 	// auth: login (returns token + sets httpOnly cookie)
 	mux.Handle("/api/login", backend.LoginHandler(pool))
+	// auth: second login step when 2FA is enabled (challenge token + TOTP/recovery code)
+	mux.Handle("/api/login/2fa", backend.LoginTOTPHandler(pool))
 	// auth: logout (clears cookie)
 	mux.Handle("/api/logout", backend.LogoutHandler(pool))
 	// auth: refresh (rotates refresh token & issues new access token)
 	mux.Handle("/api/refresh", backend.RefreshHandler(pool))
+	// auth: SSO (authorization code + PKCE); the frontend asks /api/auth/sso whether to show the button
+	if oidcProvider != nil {
+		mux.Handle("/api/oidc/login", backend.OIDCLoginHandler(oidcProvider))
+		mux.Handle("/api/oidc/callback", backend.OIDCCallbackHandler(pool, oidcProvider))
+	}
+	mux.HandleFunc("/api/auth/sso", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"enabled":   oidcProvider != nil,
+			"login_url": "/api/oidc/login",
+		})
+	})
+	// password reset: request emails a single-use link, confirm sets the new password
+	mux.Handle("/api/password/reset/request", backend.PasswordResetRequestHandler(pool, mailer))
+	mux.Handle("/api/password/reset/confirm", backend.PasswordResetConfirmHandler(pool))
+	// 2FA management (auth required): enroll returns secret + otpauth URI, verify enables it
+	mux.Handle("/api/2fa/enroll", backend.RequireAuth(backend.TOTPEnrollHandler(pool)))
+	mux.Handle("/api/2fa/verify", backend.RequireAuth(backend.TOTPVerifyHandler(pool)))
+	mux.Handle("/api/2fa/disable", backend.RequireAuth(backend.TOTPDisableHandler(pool)))
+	// API tokens: GET lists, POST creates (raw token returned once), DELETE /api/tokens/{id} revokes
+	// (session auth only - a token can't manage tokens)
+	mux.Handle("/api/tokens", backend.RequireAuth(backend.APITokensHandler(pool)))
+	mux.Handle("/api/tokens/{id}", backend.RequireAuth(backend.APITokenHandler(pool)))
+
+	// bots: GET/POST /api/bots, POST /api/bots/{id}/tokens (owner mints the bot's API token)
+	mux.Handle("/api/bots", backend.RequireAuth(bots.BotsHandler(pool)))
+	mux.Handle("/api/bots/{id}/tokens", backend.RequireAuth(bots.BotTokensHandler(pool)))
+	// outgoing webhooks: GET/POST per conversation, DELETE /api/webhooks/{id}, delivery log
+	mux.Handle("/api/conversations/{id}/webhooks", backend.RequireAuth(bots.ConversationWebhooksHandler(pool)))
+	mux.Handle("/api/webhooks/{id}", backend.RequireAuth(bots.WebhookHandler(pool)))
+	mux.Handle("/api/webhooks/{id}/deliveries", backend.RequireAuth(bots.WebhookDeliveriesHandler(pool)))
+	// incoming webhooks: GET/POST per conversation, PATCH enable/disable, POST rotate (conversation admins);
+	// /api/hooks/{token} is public and authenticated by the token in the URL
+	mux.Handle("/api/conversations/{id}/incoming-webhooks", backend.RequireAuth(bots.ConversationIncomingWebhooksHandler(pool)))
+	mux.Handle("/api/incoming-webhooks/{id}", backend.RequireAuth(bots.IncomingWebhookHandler(pool)))
+	mux.Handle("/api/incoming-webhooks/{id}/rotate", backend.RequireAuth(bots.IncomingWebhookRotateHandler(pool)))
+	mux.Handle("/api/hooks/{token}", bots.HookHandler(pool, dispatcher, limiter))
 
 	mux.HandleFunc("/api/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -69,8 +206,10 @@ func main() {
 	})
 
 	// GET /api/ws_check?conversation_id=<uuid>
-	// requires auth (JWT in Authorization header or cookie)
-	mux.Handle("/api/ws_check", backend.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	// requires auth (JWT in Authorization header or cookie, or an API token with messages:read)
+	mux.Handle("/api/ws_check", backend.RequireAuthScoped(backend.MethodScopes{
+		http.MethodGet: backend.ScopeMessagesRead,
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("ws_check: incoming %s %s", r.Method, r.URL.String())
 
 		convQ := r.URL.Query().Get("conversation_id")
@@ -108,7 +247,9 @@ func main() {
 	})))
 
 	// GET /api/users?q=<query>     - returns basic user list for participant picker
-	mux.Handle("/api/users", backend.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/api/users", backend.RequireAuthScoped(backend.MethodScopes{
+		http.MethodGet: backend.ScopeConversationsManage,
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		q := r.URL.Query().Get("q")
 		log.Printf("users search q=%q from %s", q, r.RemoteAddr)
 		if strings.TrimSpace(q) == "" {
@@ -116,7 +257,12 @@ func main() {
 			json.NewEncoder(w).Encode([]any{})
 			return
 		}
-		users, err := store.SearchUsersByDisplayName(r.Context(), pool, q, 20)
+		uid, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+		if err != nil {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+		users, err := store.SearchUsersByDisplayName(r.Context(), pool, uid, q, 20)
 		if err != nil {
 			log.Printf("search users error: %v", err)
 			http.Error(w, "server error", http.StatusInternalServerError)
@@ -126,10 +272,44 @@ func main() {
 		json.NewEncoder(w).Encode(users)
 	})))
 
+	// profiles: /api/me reads/updates the caller's profile (session auth only), POST /api/me/avatar uploads
+	// an avatar; changes are announced to everyone sharing a conversation as user_updated
+	mux.Handle("/api/me", backend.RequireAuth(users.MeHandler(pool, blobs, hub)))
+	mux.Handle("/api/me/avatar", backend.RequireAuth(users.AvatarUploadHandler(pool, blobs, hub)))
+	// block list (session auth only): blocked users can't open a direct conversation with the
+	// blocker and their messages are hidden from the blocker
+	mux.Handle("/api/blocks", backend.RequireAuth(users.BlocksHandler(pool, hub)))
+	mux.Handle("/api/blocks/{id}", backend.RequireAuth(users.BlockHandler(pool, hub)))
+	// abuse reports (any user) and the admins' moderation queue with its audit trail
+	mux.Handle("/api/reports", backend.RequireAuthScoped(backend.MethodScopes{
+		http.MethodPost: backend.ScopeMessagesWrite,
+	}, moderation.ReportHandler(pool)))
+	mux.Handle("/api/moderation/reports", backend.RequireAdmin(pool, moderation.QueueHandler(pool)))
+	mux.Handle("/api/moderation/reports/{id}/actions", backend.RequireAdmin(pool, moderation.ActionHandler(pool, blobs, hub)))
+	mux.Handle("/api/moderation/actions", backend.RequireAdmin(pool, moderation.AuditHandler(pool)))
+
+	// instance administration (admins only): accounts, sessions, conversation metadata, stats
+	mux.Handle("/api/admin/users", backend.RequireAdmin(pool, admin.UsersHandler(pool)))
+	mux.Handle("/api/admin/users/{id}", backend.RequireAdmin(pool, admin.UserHandler(pool, hub)))
+	mux.Handle("/api/admin/users/{id}/sessions", backend.RequireAdmin(pool, admin.SessionsHandler(pool, hub)))
+	mux.Handle("/api/admin/conversations", backend.RequireAdmin(pool, admin.ConversationsHandler(pool)))
+	mux.Handle("/api/admin/conversations/{id}", backend.RequireAdmin(pool, admin.ConversationHandler(pool)))
+	mux.Handle("/api/admin/stats", backend.RequireAdmin(pool, admin.StatsHandler(pool, hub, started)))
+	mux.Handle("/api/users/{id}", backend.RequireAuthScoped(backend.MethodScopes{
+		http.MethodGet: backend.ScopeMessagesRead,
+	}, users.UserHandler(pool)))
+	mux.Handle("/api/avatars/{file}", backend.RequireAuthScoped(backend.MethodScopes{
+		http.MethodGet:  backend.ScopeMessagesRead,
+		http.MethodHead: backend.ScopeMessagesRead,
+	}, users.AvatarHandler(blobs)))
+
 	// GET /api/conversations
 	// POST /api/conversations
 	// (auth required - user is derived from the access token)
-	mux.Handle("/api/conversations", backend.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/api/conversations", backend.RequireAuthScoped(backend.MethodScopes{
+		http.MethodGet:  backend.ScopeMessagesRead,
+		http.MethodPost: backend.ScopeConversationsManage,
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
 			uidStr := backend.GetUserIDFromCtx(r.Context())
@@ -138,11 +318,60 @@ func main() {
 				http.Error(w, "invalid user", http.StatusUnauthorized)
 				return
 			}
-			convs, err := store.GetConversationsForUser(r.Context(), pool, uid, 50)
+			// most recently active first; ?limit= and ?cursor= page through the list and
+			// the next page's cursor comes back in the X-Next-Cursor header
+			limit := 50
+			if v := r.URL.Query().Get("limit"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n <= 0 {
+					http.Error(w, "invalid limit", http.StatusBadRequest)
+					return
+				}
+				limit = min(n, 100)
+			}
+			var beforeTime *time.Time
+			var beforeID *uuid.UUID
+			if v := r.URL.Query().Get("cursor"); v != "" {
+				t, id, ok := backend.DecodeCursor(v)
+				if !ok {
+					http.Error(w, "invalid cursor", http.StatusBadRequest)
+					return
+				}
+				beforeTime, beforeID = &t, &id
+			}
+			// ?archived=true lists the archive, ?archived=all everything; archived ones are hidden by default
+			params := store.ConversationListParams{UserID: uid, BeforeTime: beforeTime, BeforeID: beforeID, Limit: limit}
+			switch r.URL.Query().Get("archived") {
+			case "", "false":
+				archived := false
+				params.Archived = &archived
+			case "true":
+				archived := true
+				params.Archived = &archived
+			case "all":
+			default:
+				http.Error(w, "invalid archived filter", http.StatusBadRequest)
+				return
+			}
+			convs, err := store.GetConversationsForUser(r.Context(), pool, params)
 			if err != nil {
 				http.Error(w, "database error", http.StatusInternalServerError)
 				return
 			}
+			// pinned conversations come on top of the first page and don't count towards the limit
+			var unpinned []store.Conversation
+			for _, c := range convs {
+				if !c.Pinned {
+					unpinned = append(unpinned, c)
+				}
+			}
+			if len(unpinned) == limit {
+				last := unpinned[len(unpinned)-1]
+				w.Header().Set("X-Next-Cursor", backend.EncodeCursor(last.ActivityAt(), last.ID))
+			}
+			if convs == nil {
+				convs = []store.Conversation{}
+			}
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(convs)
 			return
@@ -165,6 +394,11 @@ func main() {
 				http.Error(w, "invalid user", http.StatusUnauthorized)
 				return
 			}
+			var exceeded *ratelimit.Exceeded
+			if err := limiter.Allow(r.Context(), ratelimit.ConversationsPerUser, uid.String()); errors.As(err, &exceeded) {
+				exceeded.WriteHTTP(w)
+				return
+			}
 
 			var pIDs []uuid.UUID
 			for _, s := range req.Participants {
@@ -199,6 +433,10 @@ func main() {
 					http.Error(w, "direct conversation between these users already exists", http.StatusConflict)
 					return
 				}
+				if errors.Is(err, store.ErrBlocked) {
+					http.Error(w, "you can't start a conversation with this user", http.StatusForbidden)
+					return
+				}
 				log.Printf("create conversation error: %v", err)
 				http.Error(w, "server error", http.StatusInternalServerError)
 				return
@@ -309,7 +547,10 @@ func main() {
 
 	// GET /api/messages?conversation_id=<uuid>&limit=50
 	// POST /api/messages { conversation_id, author_id, body }
-	mux.Handle("/api/messages", backend.RequireAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("/api/messages", backend.RequireAuthScoped(backend.MethodScopes{
+		http.MethodGet:  backend.ScopeMessagesRead,
+		http.MethodPost: backend.ScopeMessagesWrite,
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
 			cq := r.URL.Query().Get("conversation_id")
@@ -322,8 +563,22 @@ func main() {
 				http.Error(w, "invalid conversation_id", http.StatusBadRequest)
 				return
 			}
+			viewerID, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+			if err != nil {
+				http.Error(w, "invalid user", http.StatusUnauthorized)
+				return
+			}
+			member, err := store.IsUserInConversation(r.Context(), pool, cid, viewerID)
+			if err != nil {
+				http.Error(w, "server error", http.StatusInternalServerError)
+				return
+			}
+			if !member {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
 			limit := 50
-			msgs, err := store.GetMessagesForConversation(r.Context(), pool, cid, limit)
+			msgs, err := store.GetMessagesForConversation(r.Context(), pool, cid, viewerID, limit)
 			if err != nil {
 				http.Error(w, "database error", http.StatusInternalServerError)
 				return
@@ -335,8 +590,10 @@ func main() {
 		case http.MethodPost:
 			// decoding client payload; author will be taken from JWT
 			var req struct {
-				ConversationID string `json:"conversation_id"`
-				Body           string `json:"body"`
+				ConversationID  string `json:"conversation_id"`
+				Body            string `json:"body"`
+				ParentMessageID string `json:"parent_message_id"`
+				ReplyToID       string `json:"reply_to_id"`
 			}
 			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 				w.Header().Set("Content-Type", "application/json")
@@ -390,7 +647,132 @@ func main() {
 				return
 			}
 
-			saved, err := store.SaveMessage(r.Context(), pool, convID, authorID, req.Body)
+			// flood control: the sender's bucket first, then the conversation's
+			var exceeded *ratelimit.Exceeded
+			if err := limiter.AllowMessage(r.Context(), authorID.String(), convID.String()); errors.As(err, &exceeded) {
+				exceeded.WriteHTTP(w)
+				return
+			}
+
+			// replying in a thread
+			var parentID *uuid.UUID
+			if req.ParentMessageID != "" {
+				pid, err := uuid.Parse(req.ParentMessageID)
+				if err != nil {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusBadRequest)
+					json.NewEncoder(w).Encode(map[string]string{"error": "invalid parent_message_id"})
+					return
+				}
+				parentID = &pid
+			}
+			// quoting an earlier message
+			var replyToID *uuid.UUID
+			if req.ReplyToID != "" {
+				qid, err := uuid.Parse(req.ReplyToID)
+				if err != nil {
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusBadRequest)
+					json.NewEncoder(w).Encode(map[string]string{"error": "invalid reply_to_id"})
+					return
+				}
+				replyToID = &qid
+			}
+
+			// slash commands are handled before anything is saved
+			if cmd, isCmd := bots.ParseCommand(body); isCmd {
+				cmd.ConversationID = convID
+				cmd.UserID = authorID
+				res, err := commands.Dispatch(r.Context(), cmd)
+				if err != nil {
+					var invalid bots.ErrInvalidCommand
+					w.Header().Set("Content-Type", "application/json")
+					switch {
+					case errors.Is(err, bots.ErrUnknownCommand):
+						w.WriteHeader(http.StatusBadRequest)
+						json.NewEncoder(w).Encode(map[string]string{"error": "unknown command /" + cmd.Name})
+					case errors.As(err, &invalid):
+						w.WriteHeader(http.StatusBadRequest)
+						json.NewEncoder(w).Encode(map[string]string{"error": invalid.Msg})
+					default:
+						log.Printf("command /%s error: %v", cmd.Name, err)
+						w.WriteHeader(http.StatusInternalServerError)
+						json.NewEncoder(w).Encode(map[string]string{"error": "server error"})
+					}
+					return
+				}
+				if res.Message == "" {
+					// nothing to post; the reply is only for the caller
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusAccepted)
+					json.NewEncoder(w).Encode(map[string]string{"command": cmd.Name, "reply": res.Reply})
+					return
+				}
+				req.Body = res.Message
+			}
+
+			// content filters: a message can be rejected, rewritten (e.g. masked) or flagged for moderators
+			filtered, err := contentFilters.Apply(r.Context(), pool, filters.Message{
+				ConversationID: convID,
+				AuthorID:       authorID,
+				Body:           req.Body,
+			})
+			var rejection *filters.Rejection
+			if errors.As(err, &rejection) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				json.NewEncoder(w).Encode(map[string]string{"error": rejection.Reason, "filter": rejection.Filter})
+				return
+			}
+			if err != nil {
+				log.Printf("content filters conv=%s error: %v", convID, err)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"error": "server error"})
+				return
+			}
+			req.Body = filtered.Body
+			flagMessage := func(m store.Message) {
+				if err := filtered.Flag(r.Context(), pool, m); err != nil {
+					log.Printf("flag message %s error: %v", m.ID, err)
+				}
+			}
+
+			if parentID != nil {
+				reply, root, err := store.SaveThreadReply(r.Context(), pool, convID, authorID, *parentID, replyToID, req.Body)
+				if err != nil {
+					w.Header().Set("Content-Type", "application/json")
+					if errors.Is(err, store.ErrInvalidParent) || errors.Is(err, store.ErrInvalidReplyTo) {
+						w.WriteHeader(http.StatusBadRequest)
+						json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+						return
+					}
+					log.Printf("save thread reply error: %v", err)
+					w.WriteHeader(http.StatusInternalServerError)
+					json.NewEncoder(w).Encode(map[string]string{"error": "database error"})
+					return
+				}
+				flagMessage(reply)
+				publishThreadReply(reply, root)
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusCreated)
+				json.NewEncoder(w).Encode(reply)
+				return
+			}
+
+			var saved store.Message
+			if replyToID != nil {
+				saved, err = store.SaveQuotedMessage(r.Context(), pool, convID, authorID, *replyToID, req.Body)
+			} else {
+				saved, err = store.SaveMessage(r.Context(), pool, convID, authorID, req.Body)
+			}
+			if errors.Is(err, store.ErrInvalidReplyTo) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
 			if err != nil {
 				log.Printf("save message error: %v", err)
 				w.Header().Set("Content-Type", "application/json")
@@ -399,10 +781,8 @@ func main() {
 				return
 			}
 
-			// publishing to redis
-			if err := hub.PublishMessage(convID.String(), saved); err != nil {
-				log.Printf("redis publish error: %v", err)
-			}
+			flagMessage(saved)
+			publishMessage(saved)
 
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusCreated)
@@ -415,6 +795,120 @@ func main() {
 
 	})))
 
+	// GET /api/messages/{id}/thread returns a thread root with its replies (participants only)
+	mux.Handle("/api/messages/{id}/thread", backend.RequireAuthScoped(backend.MethodScopes{
+		http.MethodGet: backend.ScopeMessagesRead,
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		uid, err := uuid.Parse(backend.GetUserIDFromCtx(r.Context()))
+		if err != nil {
+			http.Error(w, "invalid user", http.StatusUnauthorized)
+			return
+		}
+		msgID, err := uuid.Parse(r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "invalid message id", http.StatusBadRequest)
+			return
+		}
+		root, err := store.GetMessage(r.Context(), pool, msgID)
+		if err == nil && root.ParentMessageID != nil {
+			// asked for a reply: returning its whole thread
+			root, err = store.GetMessage(r.Context(), pool, *root.ParentMessageID)
+		}
+		if err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				http.Error(w, "message not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		ok, err := store.IsUserInConversation(r.Context(), pool, root.ConversationID, uid)
+		if err != nil {
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "message not found", http.StatusNotFound)
+			return
+		}
+		replies, err := store.GetThreadReplies(r.Context(), pool, root.ID, uid, 0)
+		if err == nil {
+			roots := []store.Message{root}
+			err = store.AttachReactions(r.Context(), pool, roots, uid)
+			root = roots[0]
+		}
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"parent":  root,
+			"replies": replies,
+		})
+	})))
+
+	// POST/DELETE /api/messages/{id}/reactions adds/removes the caller's emoji reaction
+	mux.Handle("/api/messages/{id}/reactions", backend.RequireAuthScoped(backend.MethodScopes{
+		http.MethodPost:   backend.ScopeMessagesWrite,
+		http.MethodDelete: backend.ScopeMessagesWrite,
+	}, reactions.Handler(pool, reactionEvents)))
+
+	// attachments: multipart upload posts an attachment message; downloads and thumbnails are for participants only
+	mux.Handle("/api/conversations/{id}/attachments", backend.RequireAuthScoped(backend.MethodScopes{
+		http.MethodPost: backend.ScopeMessagesWrite,
+	}, attachments.UploadHandler(pool, blobs, imageProcessor, contentFilters, limiter, publishMessage)))
+	mux.Handle("/api/attachments/{id}", backend.RequireAuthScoped(backend.MethodScopes{
+		http.MethodGet:  backend.ScopeMessagesRead,
+		http.MethodHead: backend.ScopeMessagesRead,
+	}, attachments.DownloadHandler(pool, blobs)))
+	mux.Handle("/api/attachments/{id}/thumbnail", backend.RequireAuthScoped(backend.MethodScopes{
+		http.MethodGet:  backend.ScopeMessagesRead,
+		http.MethodHead: backend.ScopeMessagesRead,
+	}, attachments.ThumbnailHandler(pool, blobs)))
+
+	// conversation details: GET for participants, PATCH title/avatar/description (group owners/admins)
+	mux.Handle("/api/conversations/{id}", backend.RequireAuthScoped(backend.MethodScopes{
+		http.MethodGet:   backend.ScopeMessagesRead,
+		http.MethodPatch: backend.ScopeConversationsManage,
+	}, conversations.Handler(pool, hub, publishMessage)))
+
+	// the caller's own conversation settings: GET reads, PATCH mutes (optionally until a time), archives or pins to top
+	mux.Handle("/api/conversations/{id}/settings", backend.RequireAuthScoped(backend.MethodScopes{
+		http.MethodGet:   backend.ScopeMessagesRead,
+		http.MethodPatch: backend.ScopeConversationsManage,
+	}, conversations.SettingsHandler(pool)))
+	mux.Handle("/api/conversations/{id}/filters", backend.RequireAuthScoped(backend.MethodScopes{
+		http.MethodGet:   backend.ScopeMessagesRead,
+		http.MethodPatch: backend.ScopeConversationsManage,
+	}, filters.SettingsHandler(pool)))
+
+	// pinned messages: POST/DELETE pin or unpin (owners/admins in groups), GET lists a conversation's pins
+	mux.Handle("/api/messages/{id}/pin", backend.RequireAuthScoped(backend.MethodScopes{
+		http.MethodPost:   backend.ScopeConversationsManage,
+		http.MethodDelete: backend.ScopeConversationsManage,
+	}, pins.Handler(pool, hub)))
+	mux.Handle("/api/conversations/{id}/pins", backend.RequireAuthScoped(backend.MethodScopes{
+		http.MethodGet: backend.ScopeMessagesRead,
+	}, pins.ListHandler(pool)))
+
+	// full-text message search over the caller's conversations
+	mux.Handle("/api/search", backend.RequireAuthScoped(backend.MethodScopes{
+		http.MethodGet: backend.ScopeMessagesRead,
+	}, search.Handler(pool)))
+
+	// mention inbox: GET lists the caller's mentions, POST /read marks them as read
+	mux.Handle("/api/mentions", backend.RequireAuthScoped(backend.MethodScopes{
+		http.MethodGet: backend.ScopeMessagesRead,
+	}, mentions.Handler(pool)))
+	mux.Handle("/api/mentions/read", backend.RequireAuthScoped(backend.MethodScopes{
+		http.MethodPost: backend.ScopeMessagesWrite,
+	}, mentions.ReadHandler(pool)))
+
 	mux.Handle("/", fs)
 
 	handler := backend.LoggingMiddleware(mux)